@@ -0,0 +1,337 @@
+package console
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+const (
+	defaultTimeFormat = "15:04:05.000"
+	subeventIndent    = "    "
+)
+
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorBlue   = "\x1b[34m"
+	colorCyan   = "\x1b[36m"
+	colorGray   = "\x1b[90m"
+	colorBold   = "\x1b[1m"
+)
+
+// Emitter renders ECS formatted events as human-readable lines for local
+// development: timestamp, level, message, then key=value pairs for the
+// remaining fields. HTTP request events are summarized as e.g.
+// 'GET /path 200 12ms', and subevents are printed indented below their span.
+type Emitter struct {
+	writer     io.Writer
+	color      bool
+	timeFormat string
+
+	// mu serializes writes so that lines are never interleaved.
+	mu sync.Mutex
+}
+
+// Option configures an Emitter as it's being initialized.
+type Option func(*Emitter)
+
+// Color forces colored output on or off. By default, color is enabled only
+// when writing to a terminal and the NO_COLOR environment variable is unset.
+func Color(enabled bool) Option {
+	return func(e *Emitter) {
+		e.color = enabled
+	}
+}
+
+// TimeFormat sets the layout timestamps are rendered with. Defaults to
+// '15:04:05.000'.
+func TimeFormat(layout string) Option {
+	return func(e *Emitter) {
+		e.timeFormat = layout
+	}
+}
+
+// New creates a new Emitter writing to w, typically os.Stderr, with the given
+// Option functions applied.
+func New(w io.Writer, opts ...Option) *Emitter {
+	e := &Emitter{
+		writer:     w,
+		color:      isTerminal(w),
+		timeFormat: defaultTimeFormat,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// isTerminal reports whether colored output is appropriate for w.
+func isTerminal(w io.Writer) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Emit takes a map of ECS fields and values and writes it as a line of text,
+// followed by one indented line per subevent.
+func (e *Emitter) Emit(event map[string]interface{}) {
+	flat := ecsevent.Unnest(event)
+	var subevents []map[string]interface{}
+	switch v := flat[ecsevent.FieldEventSubevents].(type) {
+	case []map[string]interface{}:
+		subevents = v
+	case []interface{}:
+		for _, subevent := range v {
+			if m, ok := subevent.(map[string]interface{}); ok {
+				subevents = append(subevents, m)
+			}
+		}
+	}
+	delete(flat, ecsevent.FieldEventSubevents)
+
+	var sb strings.Builder
+	e.writeLine(&sb, flat, nil)
+	for _, subevent := range subevents {
+		sb.WriteString(subeventIndent)
+		e.writeLine(&sb, ecsevent.Unnest(subevent), flat)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	io.WriteString(e.writer, sb.String())
+}
+
+// writeLine renders a single event. Fields with the same value as in parent
+// are omitted, since subevents repeat their span's fields.
+func (e *Emitter) writeLine(sb *strings.Builder, flat map[string]interface{}, parent map[string]interface{}) {
+	consumed := map[string]bool{
+		ecsevent.FieldTimestamp: true,
+		ecsevent.FieldLogLevel:  true,
+		ecsevent.FieldMessage:   true,
+	}
+
+	timestamp := ecsevent.Timestamp(flat)
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	e.colorize(sb, colorGray, timestamp.Format(e.timeFormat))
+	sb.WriteByte(' ')
+
+	level, _ := flat[ecsevent.FieldLogLevel].(string)
+	abbreviation, color := levelStyle(level)
+	e.colorize(sb, color, abbreviation)
+
+	if summary, fields := e.httpSummary(flat); summary != "" {
+		sb.WriteByte(' ')
+		sb.WriteString(summary)
+		for _, field := range fields {
+			consumed[field] = true
+		}
+	}
+	if message, ok := flat[ecsevent.FieldMessage].(string); ok && message != "" {
+		sb.WriteByte(' ')
+		e.colorize(sb, colorBold, escapeControl(message))
+	}
+
+	keys := make([]string, 0, len(flat))
+	for key, value := range flat {
+		if consumed[key] {
+			continue
+		}
+		if parentValue, ok := parent[key]; ok && fmt.Sprint(parentValue) == fmt.Sprint(value) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sb.WriteByte(' ')
+		e.colorize(sb, colorCyan, key+"=")
+		sb.WriteString(formatValue(flat[key]))
+	}
+	sb.WriteByte('\n')
+}
+
+// httpSummary renders HTTP request events compactly, returning the fields
+// it consumed.
+func (e *Emitter) httpSummary(flat map[string]interface{}) (string, []string) {
+	method, ok := flat[ecsevent.FieldHTTPRequestMethod].(string)
+	if !ok {
+		return "", nil
+	}
+	fields := []string{ecsevent.FieldHTTPRequestMethod}
+	parts := []string{escapeControl(method)}
+	if path, ok := flat[ecsevent.FieldURLPath].(string); ok {
+		fields = append(fields, ecsevent.FieldURLPath)
+		if query, ok := flat[ecsevent.FieldURLQuery].(string); ok && query != "" {
+			fields = append(fields, ecsevent.FieldURLQuery)
+			path += "?" + query
+		}
+		parts = append(parts, escapeControl(path))
+	}
+	if value, ok := flat[ecsevent.FieldHTTPResponseStatusCode]; ok {
+		fields = append(fields, ecsevent.FieldHTTPResponseStatusCode)
+		status := fmt.Sprint(value)
+		var sb strings.Builder
+		e.colorize(&sb, statusColor(status), status)
+		parts = append(parts, sb.String())
+	}
+	if value, ok := flat[ecsevent.FieldEventDuration]; ok {
+		if duration, ok := toDuration(value); ok {
+			fields = append(fields, ecsevent.FieldEventDuration)
+			parts = append(parts, formatDuration(duration))
+		}
+	}
+	return strings.Join(parts, " "), fields
+}
+
+func (e *Emitter) colorize(sb *strings.Builder, color string, s string) {
+	if !e.color || color == "" {
+		sb.WriteString(s)
+		return
+	}
+	sb.WriteString(color)
+	sb.WriteString(s)
+	sb.WriteString(colorReset)
+}
+
+// levelStyle returns a fixed-width abbreviation and color for a level.
+func levelStyle(level string) (string, string) {
+	if level == "" {
+		return "???", ""
+	}
+	severity, ok := ecsevent.ParseSeverity(level)
+	if !ok {
+		abbreviation := strings.ToUpper(level)
+		if len(abbreviation) > 3 {
+			abbreviation = abbreviation[:3]
+		}
+		return abbreviation, ""
+	}
+	switch severity {
+	case ecsevent.SeverityEmergency:
+		return "EMR", colorRed + colorBold
+	case ecsevent.SeverityAlert:
+		return "ALR", colorRed + colorBold
+	case ecsevent.SeverityCritical:
+		return "CRT", colorRed + colorBold
+	case ecsevent.SeverityError:
+		return "ERR", colorRed
+	case ecsevent.SeverityWarning:
+		return "WRN", colorYellow
+	case ecsevent.SeverityNotice:
+		return "NTC", colorGreen
+	case ecsevent.SeverityInformational:
+		return "INF", colorGreen
+	default:
+		return "DBG", colorBlue
+	}
+}
+
+func statusColor(status string) string {
+	switch {
+	case strings.HasPrefix(status, "5"):
+		return colorRed
+	case strings.HasPrefix(status, "4"):
+		return colorYellow
+	case strings.HasPrefix(status, "3"):
+		return colorCyan
+	default:
+		return colorGreen
+	}
+}
+
+// toDuration interprets an event.duration value, which ECS defines in
+// nanoseconds.
+func toDuration(value interface{}) (time.Duration, bool) {
+	switch v := value.(type) {
+	case time.Duration:
+		return v, true
+	case int64:
+		return time.Duration(v), true
+	case int:
+		return time.Duration(v), true
+	case float64:
+		return time.Duration(v), true
+	default:
+		return 0, false
+	}
+}
+
+// formatDuration rounds durations to a readable precision, e.g. 12ms or
+// 1.5s.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return d.Round(100 * time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(time.Millisecond).String()
+	default:
+		return d.Round(time.Microsecond).String()
+	}
+}
+
+// formatValue renders a value, quoting strings that would otherwise be
+// ambiguous.
+func formatValue(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []string:
+		return "[" + strings.Join(v, ",") + "]"
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " \"=") || strings.IndexFunc(s, unicode.IsControl) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// escapeControl escapes control characters, e.g. newlines and terminal escape
+// sequences, so that every event stays on a single line. Everything else is
+// left as is for readability.
+func escapeControl(s string) string {
+	if strings.IndexFunc(s, unicode.IsControl) < 0 {
+		return s
+	}
+	var sb strings.Builder
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			quoted := strconv.QuoteRune(r)
+			sb.WriteString(quoted[1 : len(quoted)-1])
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+var (
+	// This is a compile-time check to make sure our types correctly
+	// implement the interface:
+	// https://medium.com/@matryer/c167afed3aae
+	_ ecsevent.Emitter = &Emitter{}
+)
@@ -0,0 +1,127 @@
+package console
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmitter(t *testing.T) {
+	timestamp := time.Date(2019, 10, 28, 6, 15, 7, 226113003, time.UTC)
+	tcs := []struct {
+		name           string
+		color          bool
+		event          map[string]interface{}
+		expectedOutput string
+	}{
+		{
+			"message",
+			false,
+			map[string]interface{}{
+				ecsevent.FieldTimestamp:    timestamp,
+				ecsevent.FieldLogLevel:     "warning",
+				ecsevent.FieldMessage:      "disk almost full",
+				ecsevent.FieldServiceName:  "checkout",
+				ecsevent.FieldErrorMessage: "only 1% left",
+			},
+			"06:15:07.226 WRN disk almost full error.message=\"only 1% left\" service.name=checkout\n",
+		},
+		{
+			"control characters",
+			false,
+			map[string]interface{}{
+				ecsevent.FieldTimestamp:    timestamp,
+				ecsevent.FieldLogLevel:     "error",
+				ecsevent.FieldMessage:      "panic: \"boom\"\n\tmain.go:12\x1b[31m",
+				ecsevent.FieldErrorMessage: "bad\x1b[0m",
+			},
+			"06:15:07.226 ERR panic: \"boom\"\\n\\tmain.go:12\\x1b[31m error.message=\"bad\\x1b[0m\"\n",
+		},
+		{
+			"nested event without level",
+			false,
+			map[string]interface{}{
+				ecsevent.FieldTimestamp: timestamp,
+				"service": map[string]interface{}{
+					"name": "checkout",
+				},
+				ecsevent.FieldTags: []string{"a", "b"},
+			},
+			"06:15:07.226 ??? service.name=checkout tags=[a,b]\n",
+		},
+		{
+			"http request",
+			false,
+			map[string]interface{}{
+				ecsevent.FieldTimestamp:              timestamp,
+				ecsevent.FieldHTTPRequestMethod:      "GET",
+				ecsevent.FieldURLPath:                "/path",
+				ecsevent.FieldURLQuery:               "q=1",
+				ecsevent.FieldHTTPResponseStatusCode: 200,
+				ecsevent.FieldEventDuration:          int64(12345678),
+				ecsevent.FieldClientIP:               "127.0.0.1",
+			},
+			"06:15:07.226 ??? GET /path?q=1 200 12ms client.ip=127.0.0.1\n",
+		},
+		{
+			"subevents",
+			false,
+			map[string]interface{}{
+				ecsevent.FieldTimestamp:         timestamp,
+				ecsevent.FieldLogLevel:          "info",
+				ecsevent.FieldHTTPRequestMethod: "POST",
+				ecsevent.FieldURLPath:           "/orders",
+				ecsevent.FieldTraceID:           "abc",
+				ecsevent.FieldEventSubevents: []map[string]interface{}{
+					{
+						ecsevent.FieldTimestamp: timestamp.Add(time.Millisecond),
+						ecsevent.FieldLogLevel:  "debug",
+						ecsevent.FieldMessage:   "querying",
+						ecsevent.FieldTraceID:   "abc",
+						"db.rows":               3,
+					},
+				},
+			},
+			"06:15:07.226 INF POST /orders trace.id=abc\n" +
+				"    06:15:07.227 DBG querying db.rows=3\n",
+		},
+		{
+			"color",
+			true,
+			map[string]interface{}{
+				ecsevent.FieldTimestamp:              timestamp,
+				ecsevent.FieldLogLevel:               "error",
+				ecsevent.FieldHTTPRequestMethod:      "GET",
+				ecsevent.FieldHTTPResponseStatusCode: 503,
+				ecsevent.FieldMessage:                "upstream failed",
+			},
+			"\x1b[90m06:15:07.226\x1b[0m \x1b[31mERR\x1b[0m GET \x1b[31m503\x1b[0m \x1b[1mupstream failed\x1b[0m\n",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			buffer := &bytes.Buffer{}
+			emitter := New(buffer, Color(tc.color), TimeFormat("15:04:05.000"))
+			emitter.Emit(tc.event)
+			assert.Equal(tc.expectedOutput, buffer.String())
+		})
+	}
+}
+
+func TestColorDetection(t *testing.T) {
+	assert := assert.New(t)
+	assert.False(New(&bytes.Buffer{}).color)
+}
+
+func TestFormatDuration(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("12ms", formatDuration(12345678*time.Nanosecond))
+	assert.Equal("1.5s", formatDuration(1512*time.Millisecond))
+	assert.Equal("250µs", formatDuration(250400*time.Nanosecond))
+}
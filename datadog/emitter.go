@@ -0,0 +1,334 @@
+package datadog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+	"github.com/sporkmonger/ecsevent/internal/batch"
+)
+
+const (
+	defaultSite          = "datadoghq.com"
+	defaultSource        = "go"
+	defaultBatchSize     = 1000
+	defaultBufferSize    = 10000
+	defaultFlushInterval = 2 * time.Second
+	defaultMaxRetries    = 5
+	defaultRetryBackoff  = 250 * time.Millisecond
+	maxRetryBackoff      = 30 * time.Second
+	// Limits of the logs intake API.
+	maxBatchSize   = 1000
+	maxPayloadSize = 5 * 1024 * 1024
+	maxLogSize     = 1024 * 1024
+)
+
+// ErrLogTooLarge is reported when an event is dropped because it exceeds the
+// 1MB per-log limit of the logs intake API.
+var ErrLogTooLarge = errors.New("event exceeds datadog's maximum log size, event dropped")
+
+// ErrBufferFull is reported when an event is dropped because the buffer
+// already holds the maximum number of events.
+var ErrBufferFull = errors.New("datadog emitter buffer is full, event dropped")
+
+// Emitter buffers ECS formatted events, maps them onto Datadog's standard
+// attributes, and ships them to the Datadog logs intake API.
+//
+// Events are sent flat, in dotted notation, since several Datadog reserved
+// attributes like 'host' and 'service' collide with ECS objects of the same
+// name.
+type Emitter struct {
+	server        string
+	apiKey        string
+	source        string
+	tags          []string
+	compress      bool
+	client        *http.Client
+	batchSize     int
+	bufferSize    int
+	flushInterval time.Duration
+	maxRetries    int
+	retryBackoff  time.Duration
+	errorHandler  func(error)
+
+	batcher *batch.Batcher
+}
+
+// Option configures an Emitter as it's being initialized.
+type Option func(*Emitter)
+
+// Site sets the Datadog site to ship logs to, e.g. 'datadoghq.eu'. Defaults
+// to 'datadoghq.com'.
+func Site(site string) Option {
+	return func(e *Emitter) {
+		e.server = "https://http-intake.logs." + site
+	}
+}
+
+// Server sets the intake origin directly, overriding Site. Useful for
+// proxies. No trailing slash, typically.
+func Server(server string) Option {
+	return func(e *Emitter) {
+		e.server = strings.TrimSuffix(server, "/")
+	}
+}
+
+// APIKey sets the required API key to send logs to Datadog.
+func APIKey(apiKey string) Option {
+	return func(e *Emitter) {
+		e.apiKey = apiKey
+	}
+}
+
+// Source sets the 'ddsource' attribute, which selects the integration
+// pipeline that processes the logs. Defaults to 'go'.
+func Source(source string) Option {
+	return func(e *Emitter) {
+		e.source = source
+	}
+}
+
+// Tags sets tags applied to all logs in 'key:value' form, e.g. 'env:prod'.
+// ECS tags and labels on individual events are added to these.
+func Tags(tags ...string) Option {
+	return func(e *Emitter) {
+		e.tags = tags
+	}
+}
+
+// Compression controls whether request bodies are gzipped. Defaults to true.
+func Compression(compress bool) Option {
+	return func(e *Emitter) {
+		e.compress = compress
+	}
+}
+
+// HTTPClient sets the client used to send requests.
+func HTTPClient(client *http.Client) Option {
+	return func(e *Emitter) {
+		e.client = client
+	}
+}
+
+// BatchSize sets the number of buffered events that triggers a request.
+// Capped at 1000, the limit of the logs intake API.
+func BatchSize(size int) Option {
+	return func(e *Emitter) {
+		e.batchSize = size
+	}
+}
+
+// BufferSize sets the maximum number of events held in memory while waiting
+// to be sent. Events emitted while the buffer is full are dropped and
+// reported as ErrBufferFull. Defaults to 10000.
+func BufferSize(size int) Option {
+	return func(e *Emitter) {
+		e.bufferSize = size
+	}
+}
+
+// FlushInterval sets the maximum time an event will remain buffered. Values
+// below 1 use the default of 2 seconds.
+func FlushInterval(interval time.Duration) Option {
+	return func(e *Emitter) {
+		e.flushInterval = interval
+	}
+}
+
+// MaxRetries sets how many times a request that failed with a 429 or 5xx
+// status or a network error will be retried before its events are dropped.
+func MaxRetries(retries int) Option {
+	return func(e *Emitter) {
+		e.maxRetries = retries
+	}
+}
+
+// RetryBackoff sets the base delay between retries. The delay doubles with
+// each attempt, up to 30 seconds, and has jitter applied.
+func RetryBackoff(backoff time.Duration) Option {
+	return func(e *Emitter) {
+		e.retryBackoff = backoff
+	}
+}
+
+// ErrorHandler sets a callback for errors encountered while shipping events,
+// since Emit has no way to return them.
+func ErrorHandler(handler func(error)) Option {
+	return func(e *Emitter) {
+		e.errorHandler = handler
+	}
+}
+
+// New creates a new Emitter with the given Option functions applied and
+// starts its background flush loop. Call Close to stop it.
+func New(opts ...Option) *Emitter {
+	e := &Emitter{
+		server:        "https://http-intake.logs." + defaultSite,
+		source:        defaultSource,
+		compress:      true,
+		batchSize:     defaultBatchSize,
+		bufferSize:    defaultBufferSize,
+		flushInterval: defaultFlushInterval,
+		maxRetries:    defaultMaxRetries,
+		retryBackoff:  defaultRetryBackoff,
+		errorHandler:  func(error) {},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.batchSize > maxBatchSize || e.batchSize <= 0 {
+		e.batchSize = maxBatchSize
+	}
+	if e.flushInterval <= 0 {
+		e.flushInterval = defaultFlushInterval
+	}
+	if e.client == nil {
+		e.client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: (&net.Dialer{
+					Timeout:   10 * time.Second,
+					KeepAlive: 10 * time.Second,
+				}).DialContext,
+				TLSHandshakeTimeout:   10 * time.Second,
+				ExpectContinueTimeout: 4 * time.Second,
+				ResponseHeaderTimeout: 10 * time.Second,
+			},
+			Timeout: 2 * time.Minute,
+		}
+	}
+	e.batcher = batch.New(batch.Config{
+		Send:          e.send,
+		Limit:         payloadLimit,
+		BatchSize:     e.batchSize,
+		BufferSize:    e.bufferSize,
+		FlushInterval: e.flushInterval,
+	})
+	return e
+}
+
+// Emit takes a map of ECS fields and values and buffers the event for the
+// next request.
+func (e *Emitter) Emit(event map[string]interface{}) {
+	mapped := mapDatadog(ecsevent.Unnest(event), e.source, e.tags)
+	data, err := json.Marshal(mapped)
+	if err != nil {
+		e.errorHandler(err)
+		return
+	}
+	if len(data) > maxLogSize {
+		e.errorHandler(ErrLogTooLarge)
+		return
+	}
+	switch e.batcher.Add(json.RawMessage(data)) {
+	case batch.ErrClosed:
+		e.errorHandler(errors.New("datadog emitter is closed"))
+	case batch.ErrBufferFull:
+		e.errorHandler(ErrBufferFull)
+	}
+}
+
+// Flush synchronously sends all buffered events.
+func (e *Emitter) Flush() {
+	e.batcher.Flush()
+}
+
+// Close flushes any buffered events and stops the background flush loop.
+// Events emitted after Close are dropped.
+func (e *Emitter) Close() error {
+	e.batcher.Close()
+	return nil
+}
+
+// payloadLimit returns how many of the leading logs fit in a single request.
+func payloadLimit(items []interface{}) int {
+	n, size := 0, 2
+	for n < len(items) {
+		// Each log costs its length plus a separating comma.
+		length := len(items[n].(json.RawMessage)) + 1
+		if size+length > maxPayloadSize {
+			break
+		}
+		size += length
+		n++
+	}
+	return n
+}
+
+// send delivers a batch, retrying on network errors and retryable statuses.
+func (e *Emitter) send(items []interface{}) {
+	buf := &bytes.Buffer{}
+	var w io.Writer = buf
+	var zw *gzip.Writer
+	if e.compress {
+		zw = gzip.NewWriter(buf)
+		w = zw
+	}
+	io.WriteString(w, "[")
+	for i, item := range items {
+		if i > 0 {
+			io.WriteString(w, ",")
+		}
+		w.Write(item.(json.RawMessage))
+	}
+	io.WriteString(w, "]")
+	if zw != nil {
+		zw.Close()
+	}
+	body := buf.Bytes()
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(batch.Backoff(e.retryBackoff, maxRetryBackoff, attempt))
+		}
+		retry, err := e.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= e.maxRetries {
+			e.errorHandler(fmt.Errorf("dropped %d events: %v", len(items), err))
+			return
+		}
+	}
+}
+
+// post sends a single request. It reports whether a failed request should
+// be retried.
+func (e *Emitter) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, e.server+"/api/v2/logs", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", e.apiKey)
+	if e.compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	err = fmt.Errorf("datadog logs intake request failed with status %d: %s",
+		resp.StatusCode, strings.TrimSpace(string(data)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+var (
+	// This is a compile-time check to make sure our types correctly
+	// implement the interface:
+	// https://medium.com/@matryer/c167afed3aae
+	_ ecsevent.Emitter = &Emitter{}
+)
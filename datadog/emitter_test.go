@@ -0,0 +1,221 @@
+package datadog
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeIntake is a minimal stand-in for the Datadog logs intake API.
+type fakeIntake struct {
+	mu       sync.Mutex
+	statuses []int
+	requests int
+	apiKeys  []string
+	logs     [][]map[string]interface{}
+}
+
+func (fi *fakeIntake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.requests++
+	fi.apiKeys = append(fi.apiKeys, r.Header.Get("DD-API-KEY"))
+	if r.URL.Path != "/api/v2/logs" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if len(fi.statuses) > 0 {
+		status := fi.statuses[0]
+		fi.statuses = fi.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+	if r.Header.Get("Content-Encoding") != "gzip" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	zr, err := gzip.NewReader(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	logs := []map[string]interface{}{}
+	if err := json.NewDecoder(zr).Decode(&logs); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	fi.logs = append(fi.logs, logs)
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("{}"))
+}
+
+func TestEmitter(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeIntake{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	emitter := New(Server(server.URL), APIKey("secret"), Tags("env:test"))
+	emitter.Emit(map[string]interface{}{
+		"log": map[string]interface{}{
+			"level": "error",
+		},
+		"service": map[string]interface{}{
+			"name":    "checkout",
+			"version": "1.0.0",
+		},
+		ecsevent.FieldMessage: "hello world",
+	})
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldMessage: "goodbye world",
+	})
+	assert.NoError(emitter.Close())
+
+	assert.Equal([]string{"secret"}, fake.apiKeys)
+	assert.Len(fake.logs, 1)
+	if len(fake.logs) == 1 {
+		assert.Equal([]map[string]interface{}{
+			{
+				"status":          "error",
+				"service":         "checkout",
+				"service.version": "1.0.0",
+				"message":         "hello world",
+				"ddsource":        "go",
+				"ddtags":          "env:test",
+			},
+			{
+				"message":  "goodbye world",
+				"ddsource": "go",
+				"ddtags":   "env:test",
+			},
+		}, fake.logs[0])
+	}
+}
+
+func TestEmitterRetry(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeIntake{statuses: []int{http.StatusTooManyRequests, http.StatusInternalServerError}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	var errs []error
+	emitter := New(
+		Server(server.URL),
+		RetryBackoff(time.Millisecond),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+	assert.Equal(3, fake.requests)
+	assert.Len(fake.logs, 1)
+	assert.Empty(errs)
+
+	fake.statuses = []int{http.StatusForbidden}
+	emitter = New(
+		Server(server.URL),
+		RetryBackoff(time.Millisecond),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+	assert.Equal(4, fake.requests)
+	assert.Len(errs, 1)
+}
+
+func TestEmitterLimits(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeIntake{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	var errs []error
+	emitter := New(
+		Server(server.URL),
+		BatchSize(5000),
+		FlushInterval(time.Hour),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	assert.Equal(maxBatchSize, emitter.batchSize)
+	large := make([]byte, maxLogSize)
+	for i := range large {
+		large[i] = 'a'
+	}
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: string(large)})
+	assert.Equal([]error{ErrLogTooLarge}, errs)
+
+	// Six logs just under 1MB each must be split to respect the 5MB payload
+	// limit.
+	for i := 0; i < 6; i++ {
+		emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: string(large[:maxLogSize-100])})
+	}
+	assert.NoError(emitter.Close())
+	assert.Len(fake.logs, 2)
+	if len(fake.logs) == 2 {
+		assert.Len(fake.logs[0], 5)
+		assert.Len(fake.logs[1], 1)
+	}
+}
+
+func TestEmitterBuffer(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeIntake{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	var errs []error
+	emitter := New(
+		Server(server.URL),
+		BufferSize(2),
+		FlushInterval(time.Hour),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	for i := 0; i < 3; i++ {
+		emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	}
+	assert.Equal([]error{ErrBufferFull}, errs)
+	assert.NoError(emitter.Close())
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Equal(1, fake.requests)
+}
+
+func TestEmitterInvalidBatching(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeIntake{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	emitter := New(Server(server.URL), BatchSize(0), FlushInterval(0))
+	assert.Equal(maxBatchSize, emitter.batchSize)
+	assert.Equal(defaultFlushInterval, emitter.flushInterval)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Equal(1, fake.requests)
+}
+
+func TestSite(t *testing.T) {
+	assert := assert.New(t)
+	emitter := New(Site("datadoghq.eu"))
+	defer emitter.Close()
+	assert.Equal("https://http-intake.logs.datadoghq.eu", emitter.server)
+}
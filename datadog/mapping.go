@@ -0,0 +1,187 @@
+package datadog
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+var (
+	fieldDatadogStatus             = "status"
+	fieldDatadogService            = "service"
+	fieldDatadogHost               = "host"
+	fieldDatadogSource             = "ddsource"
+	fieldDatadogTags               = "ddtags"
+	fieldDatadogTraceID            = "dd.trace_id"
+	fieldDatadogSpanID             = "dd.span_id"
+	fieldDatadogDuration           = "duration"
+	fieldDatadogHTTPStatusCode     = "http.status_code"
+	fieldDatadogHTTPMethod         = "http.method"
+	fieldDatadogHTTPURL            = "http.url"
+	fieldDatadogHTTPReferer        = "http.referer"
+	fieldDatadogHTTPUserAgent      = "http.useragent"
+	fieldDatadogNetworkClientIP    = "network.client.ip"
+	fieldDatadogNetworkClientPort  = "network.client.port"
+	fieldDatadogNetworkDestIP      = "network.destination.ip"
+	fieldDatadogNetworkDestPort    = "network.destination.port"
+	fieldDatadogNetworkBytesRead   = "network.bytes_read"
+	fieldDatadogNetworkBytesWrite  = "network.bytes_written"
+	fieldDatadogErrorStack         = "error.stack"
+	fieldDatadogUserID             = "usr.id"
+	fieldDatadogUserName           = "usr.name"
+	fieldDatadogUserEmail          = "usr.email"
+	fieldDatadogHTTPURLDetailsPath = "http.url_details.path"
+	fieldDatadogHTTPURLDetailsHost = "http.url_details.host"
+)
+
+// renames maps ECS fields onto the Datadog standard attribute that replaces
+// them, for fields that need no transform.
+var renames = map[string]string{
+	ecsevent.FieldServiceName:            fieldDatadogService,
+	ecsevent.FieldHostHostname:           fieldDatadogHost,
+	ecsevent.FieldEventDuration:          fieldDatadogDuration,
+	ecsevent.FieldHTTPResponseStatusCode: fieldDatadogHTTPStatusCode,
+	ecsevent.FieldHTTPRequestMethod:      fieldDatadogHTTPMethod,
+	ecsevent.FieldURLFull:                fieldDatadogHTTPURL,
+	ecsevent.FieldURLPath:                fieldDatadogHTTPURLDetailsPath,
+	ecsevent.FieldURLDomain:              fieldDatadogHTTPURLDetailsHost,
+	ecsevent.FieldHTTPRequestReferrer:    fieldDatadogHTTPReferer,
+	ecsevent.FieldUserAgentOriginal:      fieldDatadogHTTPUserAgent,
+	ecsevent.FieldClientIP:               fieldDatadogNetworkClientIP,
+	ecsevent.FieldClientPort:             fieldDatadogNetworkClientPort,
+	ecsevent.FieldDestinationIP:          fieldDatadogNetworkDestIP,
+	ecsevent.FieldDestinationPort:        fieldDatadogNetworkDestPort,
+	ecsevent.FieldHTTPRequestBodyBytes:   fieldDatadogNetworkBytesRead,
+	ecsevent.FieldHTTPResponseBodyBytes:  fieldDatadogNetworkBytesWrite,
+	ecsevent.FieldErrorStackTrace:        fieldDatadogErrorStack,
+	ecsevent.FieldUserID:                 fieldDatadogUserID,
+	ecsevent.FieldUserName:               fieldDatadogUserName,
+	ecsevent.FieldUserEmail:              fieldDatadogUserEmail,
+}
+
+// mapDatadog takes a map in ECS dotted notation and rewrites each field with
+// a Datadog standard or reserved attribute (e.g. 'status') into that
+// attribute, applying any necessary transforms. Fields without a Datadog
+// equivalent are copied over unmodified. The source and tags are added as
+// the reserved 'ddsource' and 'ddtags' attributes.
+func mapDatadog(entry map[string]interface{}, source string, tags []string) map[string]interface{} {
+	newEntry := make(map[string]interface{}, len(entry)+2)
+	tags = append([]string(nil), tags...)
+	for key, value := range entry {
+		if renamed, ok := renames[key]; ok {
+			newEntry[renamed] = value
+			continue
+		}
+
+		switch key {
+		case ecsevent.FieldLogLevel:
+			if level, ok := value.(string); ok {
+				newEntry[fieldDatadogStatus] = datadogStatus(level)
+			} else {
+				newEntry[key] = value
+			}
+		case ecsevent.FieldHostName:
+			// host.hostname is preferred when both are present.
+			if _, ok := entry[ecsevent.FieldHostHostname]; ok {
+				newEntry[key] = value
+			} else {
+				newEntry[fieldDatadogHost] = value
+			}
+		case ecsevent.FieldTraceID:
+			newEntry[fieldDatadogTraceID] = datadogID(value)
+		case ecsevent.FieldSpanID:
+			newEntry[fieldDatadogSpanID] = datadogID(value)
+		case ecsevent.FieldTags:
+			if values, ok := value.([]string); ok {
+				tags = append(tags, values...)
+			} else {
+				newEntry[key] = value
+			}
+		case ecsevent.FieldLabels:
+			if labels, ok := value.(map[string]interface{}); ok {
+				tags = append(tags, labelTags("", labels)...)
+			} else if labels, ok := value.(map[string]string); ok {
+				for k, v := range labels {
+					tags = append(tags, k+":"+v)
+				}
+			} else {
+				newEntry[key] = value
+			}
+		default:
+			if strings.HasPrefix(key, ecsevent.FieldLabels+".") {
+				// Labels that were unnested from a map.
+				tags = append(tags, fmt.Sprintf("%s:%v", strings.TrimPrefix(key, ecsevent.FieldLabels+"."), value))
+				continue
+			}
+			// copy existing fields over unmodified
+			newEntry[key] = value
+		}
+	}
+	if source != "" {
+		newEntry[fieldDatadogSource] = source
+	}
+	if len(tags) > 0 {
+		sort.Strings(tags)
+		newEntry[fieldDatadogTags] = strings.Join(tags, ",")
+	}
+	return newEntry
+}
+
+func labelTags(prefix string, labels map[string]interface{}) []string {
+	tags := []string{}
+	for k, v := range labels {
+		if nested, ok := v.(map[string]interface{}); ok {
+			tags = append(tags, labelTags(prefix+k+".", nested)...)
+			continue
+		}
+		tags = append(tags, fmt.Sprintf("%s%s:%v", prefix, k, v))
+	}
+	return tags
+}
+
+// datadogID converts a trace or span ID to the decimal 64-bit form Datadog
+// uses to correlate logs with traces. ECS IDs are typically hex encoded, and
+// 128-bit trace IDs are truncated to their lower 64 bits as the Datadog
+// tracers do. Anything else is passed through unmodified.
+func datadogID(value interface{}) interface{} {
+	id, ok := value.(string)
+	if !ok || id == "" {
+		return value
+	}
+	if len(id) > 16 {
+		id = id[len(id)-16:]
+	}
+	n, err := strconv.ParseUint(id, 16, 64)
+	if err != nil {
+		return value
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+func datadogStatus(level string) string {
+	severity, ok := ecsevent.ParseSeverity(level)
+	if !ok {
+		return strings.ToLower(level)
+	}
+	switch severity {
+	case ecsevent.SeverityDebug:
+		return "debug"
+	case ecsevent.SeverityInformational:
+		return "info"
+	case ecsevent.SeverityNotice:
+		return "notice"
+	case ecsevent.SeverityWarning:
+		return "warn"
+	case ecsevent.SeverityError:
+		return "error"
+	case ecsevent.SeverityCritical:
+		return "critical"
+	case ecsevent.SeverityAlert:
+		return "alert"
+	default:
+		return "emergency"
+	}
+}
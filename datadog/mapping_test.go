@@ -0,0 +1,121 @@
+package datadog
+
+import (
+	"testing"
+
+	"github.com/sporkmonger/ecsevent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapDatadog(t *testing.T) {
+	tcs := []struct {
+		name           string
+		input          map[string]interface{}
+		source         string
+		tags           []string
+		expectedOutput map[string]interface{}
+	}{
+		{
+			"simple message",
+			map[string]interface{}{
+				ecsevent.FieldTimestamp:    "2019-10-28T06:15:07.226113003Z",
+				ecsevent.FieldLogLevel:     "wrn",
+				ecsevent.FieldMessage:      "something happened",
+				ecsevent.FieldServiceName:  "checkout",
+				ecsevent.FieldHostHostname: "web-1",
+				ecsevent.FieldHostName:     "web-1.example.com",
+			},
+			"go",
+			nil,
+			map[string]interface{}{
+				ecsevent.FieldTimestamp: "2019-10-28T06:15:07.226113003Z",
+				ecsevent.FieldMessage:   "something happened",
+				ecsevent.FieldHostName:  "web-1.example.com",
+				"status":                "warn",
+				"service":               "checkout",
+				"host":                  "web-1",
+				"ddsource":              "go",
+			},
+		},
+		{
+			"http request",
+			map[string]interface{}{
+				ecsevent.FieldHTTPRequestMethod:      "GET",
+				ecsevent.FieldHTTPVersion:            "1.1",
+				ecsevent.FieldHTTPResponseBodyBytes:  int64(42),
+				ecsevent.FieldHTTPResponseStatusCode: 200,
+				ecsevent.FieldURLPath:                "/",
+				ecsevent.FieldURLFull:                "https://example.com/",
+				ecsevent.FieldClientIP:               "127.0.0.1",
+				ecsevent.FieldEventDuration:          int64(50000000),
+			},
+			"",
+			nil,
+			map[string]interface{}{
+				ecsevent.FieldHTTPVersion: "1.1",
+				"http.method":             "GET",
+				"http.status_code":        200,
+				"http.url":                "https://example.com/",
+				"http.url_details.path":   "/",
+				"network.bytes_written":   int64(42),
+				"network.client.ip":       "127.0.0.1",
+				"duration":                int64(50000000),
+			},
+		},
+		{
+			"trace correlation",
+			map[string]interface{}{
+				ecsevent.FieldTraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+				ecsevent.FieldSpanID:  "00f067aa0ba902b7",
+			},
+			"",
+			nil,
+			map[string]interface{}{
+				"dd.trace_id": "11803532876627986230",
+				"dd.span_id":  "67667974448284343",
+			},
+		},
+		{
+			"tags and labels",
+			map[string]interface{}{
+				ecsevent.FieldTags:      []string{"canary"},
+				"labels.region":         "us-east-1",
+				ecsevent.FieldUserID:    "42",
+				ecsevent.FieldErrorCode: "E1",
+			},
+			"",
+			[]string{"env:prod"},
+			map[string]interface{}{
+				ecsevent.FieldErrorCode: "E1",
+				"usr.id":                "42",
+				"ddtags":                "canary,env:prod,region:us-east-1",
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			output := mapDatadog(tc.input, tc.source, tc.tags)
+			assert.Equal(tc.expectedOutput, output)
+		})
+	}
+}
+
+func TestDatadogID(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("1", datadogID("0000000000000001"))
+	assert.Equal("1", datadogID("00000000000000000000000000000001"))
+	assert.Equal("not-hex", datadogID("not-hex"))
+	assert.Equal(1234, datadogID(1234))
+}
+
+func TestDatadogStatus(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("debug", datadogStatus("trace"))
+	assert.Equal("info", datadogStatus("INFO"))
+	assert.Equal("warn", datadogStatus("warning"))
+	assert.Equal("emergency", datadogStatus("fatal"))
+	assert.Equal("verbose", datadogStatus("Verbose"))
+}
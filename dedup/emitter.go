@@ -0,0 +1,356 @@
+package dedup
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+// FieldEventCount holds the number of duplicate events a summary event
+// stands for. It's not part of ECS.
+const FieldEventCount = "event.count"
+
+const (
+	defaultWindow = 10 * time.Second
+	defaultBurst  = 1
+)
+
+var defaultFields = []string{
+	ecsevent.FieldMessage,
+	ecsevent.FieldErrorType,
+	ecsevent.FieldErrorMessage,
+	ecsevent.FieldURLPath,
+	// Keep e.g. a failing request distinct from earlier successful ones.
+	ecsevent.FieldHTTPResponseStatusCode,
+	ecsevent.FieldLogLevel,
+	ecsevent.FieldEventOutcome,
+}
+
+// Emitter suppresses repeated events before passing them on to another
+// Emitter. Events are fingerprinted by the values of a set of fields. The
+// first events with a fingerprint, up to the burst size, are passed on and
+// open a window; further events with the same fingerprint are suppressed
+// until the window closes. A summary event is then passed on, carrying the
+// fields of the first event, the number of suppressed events in event.count,
+// and the times the first and last events were seen in event.start and
+// event.end. Summing event.count, counting 1 for events without it, gives
+// the total number of events.
+//
+// Only errors and warnings are deduplicated by default, see Match. Other
+// events, and events with none of the fingerprint fields, are passed on
+// unchanged.
+//
+// The Emitter can also run as a Processor, see Process.
+type Emitter struct {
+	next   ecsevent.Emitter
+	fields []string
+	match  func(event map[string]interface{}) bool
+	window time.Duration
+	burst  int
+	now    func() time.Time
+
+	// mu gates the open windows and the queue. It's never held while
+	// calling the next Emitter.
+	mu      sync.Mutex
+	windows map[string]*window
+	closed  bool
+	// queue holds the events waiting to be passed on, in order. Whichever
+	// goroutine sets draining passes them on, so that calls to the next
+	// Emitter never overlap.
+	queue    []map[string]interface{}
+	draining bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type window struct {
+	event       map[string]interface{}
+	first, last time.Time
+	end         time.Time
+	count       int
+	suppressed  int
+}
+
+// Option configures an Emitter as it's being initialized.
+type Option func(*Emitter)
+
+// Fields sets the fields events are fingerprinted by. Defaults to message,
+// error.type, error.message, url.path, http.response.status_code, log.level
+// and event.outcome.
+func Fields(fields ...string) Option {
+	return func(e *Emitter) {
+		e.fields = fields
+	}
+}
+
+// Match sets which events are deduplicated; the rest are passed on
+// unchanged. Defaults to errors and warnings: events with an error.type,
+// error.message or error.code, a failure outcome, a 5xx status or a
+// log.level of warning or more severe. Routine events like successful HTTP
+// requests are often identical by the default fields and shouldn't be
+// collapsed.
+func Match(match func(event map[string]interface{}) bool) Option {
+	return func(e *Emitter) {
+		e.match = match
+	}
+}
+
+// Window sets how long duplicates are suppressed after the first event with
+// a fingerprint. Defaults to 10 seconds.
+func Window(d time.Duration) Option {
+	return func(e *Emitter) {
+		e.window = d
+	}
+}
+
+// Burst sets how many events with the same fingerprint are passed on in a
+// window before the rest are suppressed. Defaults to 1.
+func Burst(n int) Option {
+	return func(e *Emitter) {
+		e.burst = n
+	}
+}
+
+// New creates a new Emitter passing events on to next with the given Option
+// functions applied. Close it to emit the summaries of open windows.
+func New(next ecsevent.Emitter, opts ...Option) *Emitter {
+	e := &Emitter{
+		next:    next,
+		fields:  defaultFields,
+		match:   isErrorOrWarning,
+		window:  defaultWindow,
+		burst:   defaultBurst,
+		now:     time.Now,
+		windows: map[string]*window{},
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.match == nil {
+		e.match = isErrorOrWarning
+	}
+	if e.window <= 0 {
+		e.window = defaultWindow
+	}
+	if e.burst < 1 {
+		e.burst = defaultBurst
+	}
+	e.wg.Add(1)
+	go e.loop()
+	return e
+}
+
+// Emit passes the event on unless it duplicates an earlier one.
+func (e *Emitter) Emit(event map[string]interface{}) {
+	e.mu.Lock()
+	if e.observe(event) {
+		e.queue = append(e.queue, event)
+	}
+	e.mu.Unlock()
+	e.drain()
+}
+
+// Process runs the Emitter as a Processor, e.g. with
+// ecsevent.EmitterProcessors, dropping duplicates instead of passing events
+// on. Summaries are still passed to the next Emitter as windows close,
+// skipping any processors that follow.
+func (e *Emitter) Process(event map[string]interface{}) (map[string]interface{}, bool) {
+	e.mu.Lock()
+	ok := e.observe(event)
+	e.mu.Unlock()
+	e.drain()
+	return event, ok
+}
+
+// observe records an event in its window, queueing the summary of the
+// previous window if it expired, and reports whether the event should be
+// passed on. The caller must hold mu.
+func (e *Emitter) observe(event map[string]interface{}) bool {
+	if e.closed || !e.match(event) {
+		return true
+	}
+	key, ok := e.fingerprint(event)
+	if !ok {
+		return true
+	}
+	now := e.now()
+	w := e.windows[key]
+	if w != nil && !now.Before(w.end) {
+		e.closeWindow(key, w)
+		w = nil
+	}
+	if w == nil {
+		e.windows[key] = &window{
+			event: event,
+			first: now,
+			last:  now,
+			end:   now.Add(e.window),
+			count: 1,
+		}
+		return true
+	}
+	w.count++
+	w.last = now
+	if w.count <= e.burst {
+		return true
+	}
+	w.suppressed++
+	return false
+}
+
+// Flush closes all open windows, emitting their summaries.
+func (e *Emitter) Flush() {
+	e.mu.Lock()
+	for key, w := range e.windows {
+		e.closeWindow(key, w)
+	}
+	e.mu.Unlock()
+	e.drain()
+}
+
+// Close emits the summaries of open windows and stops suppressing events.
+func (e *Emitter) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	close(e.done)
+	e.mu.Unlock()
+	e.wg.Wait()
+	e.Flush()
+	return nil
+}
+
+func (e *Emitter) loop() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(e.window / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.closeExpired()
+		case <-e.done:
+			return
+		}
+	}
+}
+
+func (e *Emitter) closeExpired() {
+	e.mu.Lock()
+	now := e.now()
+	for key, w := range e.windows {
+		if !now.Before(w.end) {
+			e.closeWindow(key, w)
+		}
+	}
+	e.mu.Unlock()
+	e.drain()
+}
+
+// drain passes queued events on to the next Emitter, one at a time and in
+// order. The caller must not hold mu, so that the next Emitter may block or
+// call back into this one. Events queued while another call is draining,
+// including one further up the stack, are left to that call.
+func (e *Emitter) drain() {
+	e.mu.Lock()
+	if e.draining {
+		e.mu.Unlock()
+		return
+	}
+	e.draining = true
+	for len(e.queue) > 0 {
+		events := e.queue
+		e.queue = nil
+		e.mu.Unlock()
+		for _, event := range events {
+			e.next.Emit(event)
+		}
+		e.mu.Lock()
+	}
+	e.draining = false
+	e.mu.Unlock()
+}
+
+// closeWindow removes a window and queues its summary if any events were
+// suppressed. The caller must hold mu.
+func (e *Emitter) closeWindow(key string, w *window) {
+	delete(e.windows, key)
+	if w.suppressed == 0 {
+		return
+	}
+	summary := ecsevent.Unnest(w.event)
+	summary[FieldEventCount] = w.suppressed
+	summary[ecsevent.FieldEventStart] = w.first
+	summary[ecsevent.FieldEventEnd] = w.last
+	summary[ecsevent.FieldTimestamp] = w.last
+	if isNested(w.event) {
+		summary = ecsevent.Nest(summary)
+	}
+	e.queue = append(e.queue, summary)
+}
+
+// isErrorOrWarning reports whether an event describes an error or warning.
+func isErrorOrWarning(event map[string]interface{}) bool {
+	for _, field := range []string{
+		ecsevent.FieldErrorType,
+		ecsevent.FieldErrorMessage,
+		ecsevent.FieldErrorCode,
+	} {
+		if _, ok := ecsevent.Lookup(event, field); ok {
+			return true
+		}
+	}
+	if outcome, ok := ecsevent.Lookup(event, ecsevent.FieldEventOutcome); ok && outcome == "failure" {
+		return true
+	}
+	if level, ok := ecsevent.Lookup(event, ecsevent.FieldLogLevel); ok {
+		if s, ok := level.(string); ok {
+			if severity, ok := ecsevent.ParseSeverity(s); ok && severity <= ecsevent.SeverityWarning {
+				return true
+			}
+		}
+	}
+	if status, ok := ecsevent.Lookup(event, ecsevent.FieldHTTPResponseStatusCode); ok {
+		if code, ok := status.(int); ok && code >= 500 {
+			return true
+		}
+	}
+	return false
+}
+
+// fingerprint joins the values of the fingerprint fields. It returns false
+// if the event has none of them.
+func (e *Emitter) fingerprint(event map[string]interface{}) (string, bool) {
+	values := make([]string, len(e.fields))
+	found := false
+	for i, field := range e.fields {
+		if value, ok := ecsevent.Lookup(event, field); ok {
+			values[i] = fmt.Sprint(value)
+			found = true
+		}
+	}
+	return strings.Join(values, "\x1f"), found
+}
+
+// isNested reports whether an event was nested by the RootMonitor.
+func isNested(event map[string]interface{}) bool {
+	for _, value := range event {
+		if _, ok := value.(map[string]interface{}); ok {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// This is a compile-time check to make sure our types correctly
+	// implement the interface:
+	// https://medium.com/@matryer/c167afed3aae
+	_ ecsevent.Emitter = &Emitter{}
+)
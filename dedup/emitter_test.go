@@ -0,0 +1,257 @@
+package dedup
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+type captureEmitter struct {
+	mu     sync.Mutex
+	events []map[string]interface{}
+}
+
+func (ce *captureEmitter) Emit(event map[string]interface{}) {
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+	ce.events = append(ce.events, event)
+}
+
+func (ce *captureEmitter) Events() []map[string]interface{} {
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+	return append([]map[string]interface{}(nil), ce.events...)
+}
+
+func TestEmitter(t *testing.T) {
+	assert := assert.New(t)
+	start := time.Date(2019, 10, 28, 6, 15, 7, 0, time.UTC)
+	now := start
+	capture := &captureEmitter{}
+	e := New(capture, Window(time.Hour), Burst(2))
+	e.now = func() time.Time { return now }
+	defer e.Close()
+
+	failure := map[string]interface{}{
+		ecsevent.FieldMessage:      "query failed",
+		ecsevent.FieldErrorMessage: "connection refused",
+	}
+	for i := 0; i < 10; i++ {
+		now = start.Add(time.Duration(i) * time.Second)
+		e.Emit(failure)
+	}
+	e.Emit(map[string]interface{}{ecsevent.FieldMessage: "other"})
+	e.Emit(map[string]interface{}{"metric": 1})
+	e.Emit(map[string]interface{}{"metric": 1})
+	assert.Equal([]map[string]interface{}{failure, failure,
+		{ecsevent.FieldMessage: "other"}, {"metric": 1}, {"metric": 1}}, capture.Events())
+
+	// The next event after the window closes emits the summary first.
+	now = start.Add(time.Hour)
+	e.Emit(failure)
+	events := capture.Events()
+	if assert.Len(events, 7) {
+		assert.Equal(map[string]interface{}{
+			ecsevent.FieldMessage:      "query failed",
+			ecsevent.FieldErrorMessage: "connection refused",
+			FieldEventCount:            8,
+			ecsevent.FieldEventStart:   start,
+			ecsevent.FieldEventEnd:     start.Add(9 * time.Second),
+			ecsevent.FieldTimestamp:    start.Add(9 * time.Second),
+		}, events[5])
+		assert.Equal(failure, events[6])
+	}
+}
+
+func TestEmitterNested(t *testing.T) {
+	assert := assert.New(t)
+	capture := &captureEmitter{}
+	e := New(capture, Fields(ecsevent.FieldURLPath))
+	event := map[string]interface{}{
+		"url": map[string]interface{}{"path": "/checkout"},
+		"log": map[string]interface{}{"level": "error"},
+	}
+	e.Emit(event)
+	e.Emit(event)
+	e.Emit(event)
+	e.Close()
+	events := capture.Events()
+	if assert.Len(events, 2) {
+		url, ok := events[1]["url"].(map[string]interface{})
+		assert.True(ok)
+		assert.Equal("/checkout", url["path"])
+		eventFields, ok := events[1]["event"].(map[string]interface{})
+		if assert.True(ok) {
+			assert.Equal(2, eventFields["count"])
+		}
+	}
+
+	// Closed emitters pass everything on.
+	e.Emit(event)
+	assert.Len(capture.Events(), 3)
+}
+
+func TestEmitterStatusChange(t *testing.T) {
+	assert := assert.New(t)
+	capture := &captureEmitter{}
+	e := New(capture, Window(time.Hour))
+	defer e.Close()
+	request := func(status int, level string) map[string]interface{} {
+		return map[string]interface{}{
+			ecsevent.FieldURLPath:                "/checkout",
+			ecsevent.FieldHTTPResponseStatusCode: status,
+			ecsevent.FieldLogLevel:               level,
+		}
+	}
+	// Successful requests aren't deduplicated by default.
+	e.Emit(request(200, "info"))
+	e.Emit(request(200, "info"))
+	e.Emit(request(503, "error"))
+	e.Emit(request(503, "error"))
+	e.Emit(request(500, "error"))
+	assert.Equal([]map[string]interface{}{
+		request(200, "info"),
+		request(200, "info"),
+		request(503, "error"),
+		request(500, "error"),
+	}, capture.Events())
+}
+
+func TestEmitterMatch(t *testing.T) {
+	assert := assert.New(t)
+	capture := &captureEmitter{}
+	e := New(capture, Window(time.Hour), Match(func(map[string]interface{}) bool {
+		return true
+	}))
+	defer e.Close()
+	e.Emit(map[string]interface{}{ecsevent.FieldURLPath: "/checkout"})
+	e.Emit(map[string]interface{}{ecsevent.FieldURLPath: "/checkout"})
+	assert.Len(capture.Events(), 1)
+}
+
+func TestEmitterErrorType(t *testing.T) {
+	assert := assert.New(t)
+	capture := &captureEmitter{}
+	e := New(capture, Window(time.Hour))
+	defer e.Close()
+	e.Emit(map[string]interface{}{ecsevent.FieldErrorType: "*net.OpError"})
+	e.Emit(map[string]interface{}{ecsevent.FieldErrorType: "*net.OpError"})
+	e.Emit(map[string]interface{}{ecsevent.FieldErrorType: "*url.Error"})
+	assert.Len(capture.Events(), 2)
+}
+
+func TestProcess(t *testing.T) {
+	assert := assert.New(t)
+	capture := &captureEmitter{}
+	e := New(capture, Window(time.Hour))
+	failure := map[string]interface{}{ecsevent.FieldErrorMessage: "connection refused"}
+	rm := ecsevent.NewRootMonitor(ecsevent.NestEvents(false))
+	rm.AppendEmitter(capture, ecsevent.EmitterProcessors(e.Process))
+	for i := 0; i < 3; i++ {
+		rm.Record(failure)
+	}
+	assert.Len(capture.Events(), 1)
+	assert.NoError(e.Close())
+	events := capture.Events()
+	if assert.Len(events, 2) {
+		assert.Equal(2, events[1][FieldEventCount])
+	}
+}
+
+// flushingEmitter calls back into the dedup Emitter feeding it.
+type flushingEmitter struct {
+	captureEmitter
+	dedup *Emitter
+}
+
+func (fe *flushingEmitter) Emit(event map[string]interface{}) {
+	fe.captureEmitter.Emit(event)
+	if _, ok := event[FieldEventCount]; !ok {
+		fe.dedup.Flush()
+	}
+}
+
+func TestEmitterReentrant(t *testing.T) {
+	assert := assert.New(t)
+	next := &flushingEmitter{}
+	e := New(next, Window(time.Hour))
+	next.dedup = e
+	defer e.Close()
+	boom := map[string]interface{}{ecsevent.FieldErrorMessage: "boom"}
+	e.Emit(boom)
+	e.Emit(boom)
+	e.Emit(boom)
+	// Every event is passed on, since next flushes its window while it's
+	// being emitted.
+	assert.Len(next.Events(), 3)
+}
+
+func TestEmitterTimer(t *testing.T) {
+	assert := assert.New(t)
+	capture := &captureEmitter{}
+	e := New(capture, Window(20*time.Millisecond))
+	defer e.Close()
+	rm := ecsevent.NewRootMonitor(ecsevent.NestEvents(false))
+	rm.AppendEmitter(e)
+	for i := 0; i < 5; i++ {
+		rm.Record(map[string]interface{}{ecsevent.FieldErrorMessage: "boom"})
+	}
+	assert.Eventually(func() bool {
+		return len(capture.Events()) == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(4, capture.Events()[1][FieldEventCount])
+}
+
+// appendEmitter collects events without any locking of its own, relying on
+// the dedup Emitter never calling it concurrently.
+type appendEmitter struct {
+	events []map[string]interface{}
+}
+
+func (ae *appendEmitter) Emit(event map[string]interface{}) {
+	ae.events = append(ae.events, event)
+}
+
+func TestEmitterSerializesNext(t *testing.T) {
+	assert := assert.New(t)
+	// Overlapping calls need real parallelism to be caught by the race
+	// detector.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	next := &appendEmitter{}
+	e := New(next, Fields("k"), Window(time.Millisecond), Burst(1), Match(func(map[string]interface{}) bool {
+		return true
+	}))
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			for j := 0; j < 1000; j++ {
+				e.Emit(map[string]interface{}{"k": (i + j) % 10})
+				if j%100 == 0 {
+					e.Flush()
+				}
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	assert.NoError(e.Close())
+
+	total := 0
+	for _, event := range next.events {
+		if count, ok := event[FieldEventCount].(int); ok {
+			total += count
+		} else {
+			total++
+		}
+	}
+	assert.Equal(8*1000, total)
+}
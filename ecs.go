@@ -79,6 +79,7 @@ var (
 	FieldErrorID                      = "error.id"
 	FieldErrorMessage                 = "error.message"
 	FieldErrorStackTrace              = "error.stack_trace"
+	FieldErrorType                    = "error.type"
 	FieldEventAction                  = "event.action"
 	FieldEventCategory                = "event.category"
 	FieldEventCreated                 = "event.created"
@@ -152,6 +153,10 @@ var (
 	FieldHTTPResponseStatusCode       = "http.response.status_code"
 	FieldHTTPVersion                  = "http.version"
 	FieldLogLevel                     = "log.level"
+	FieldLogLogger                    = "log.logger"
+	FieldLogOriginFileLine            = "log.origin.file.line"
+	FieldLogOriginFileName            = "log.origin.file.name"
+	FieldLogOriginFunction            = "log.origin.function"
 	FieldLogOriginal                  = "log.original"
 	FieldNetworkApplication           = "network.application"
 	FieldNetworkBytes                 = "network.bytes"
@@ -239,6 +244,9 @@ var (
 	FieldSourceUserHash               = "source.user.hash"
 	FieldSourceUserID                 = "source.user.id"
 	FieldSourceUserName               = "source.user.name"
+	FieldSpanID                       = "span.id"
+	FieldTraceID                      = "trace.id"
+	FieldTransactionID                = "transaction.id"
 	FieldURLDomain                    = "url.domain"
 	FieldURLFragment                  = "url.fragment"
 	FieldURLFull                      = "url.full"
@@ -328,6 +336,7 @@ var fieldKinds = map[string]reflect.Kind{
 	FieldErrorID:                      reflect.String,
 	FieldErrorMessage:                 reflect.String,
 	FieldErrorStackTrace:              reflect.String,
+	FieldErrorType:                    reflect.String,
 	FieldEventAction:                  reflect.String,
 	FieldEventCategory:                reflect.String,
 	FieldEventCreated:                 reflect.Struct, // time.Time
@@ -399,6 +408,10 @@ var fieldKinds = map[string]reflect.Kind{
 	FieldHTTPResponseStatusCode:       reflect.Int,
 	FieldHTTPVersion:                  reflect.String,
 	FieldLogLevel:                     reflect.String,
+	FieldLogLogger:                    reflect.String,
+	FieldLogOriginFileLine:            reflect.Int,
+	FieldLogOriginFileName:            reflect.String,
+	FieldLogOriginFunction:            reflect.String,
 	FieldLogOriginal:                  reflect.String,
 	FieldNetworkApplication:           reflect.String,
 	FieldNetworkBytes:                 reflect.Int,
@@ -486,6 +499,9 @@ var fieldKinds = map[string]reflect.Kind{
 	FieldSourceUserHash:               reflect.String,
 	FieldSourceUserID:                 reflect.String,
 	FieldSourceUserName:               reflect.String,
+	FieldSpanID:                       reflect.String,
+	FieldTraceID:                      reflect.String,
+	FieldTransactionID:                reflect.String,
 	FieldURLDomain:                    reflect.String,
 	FieldURLFragment:                  reflect.String,
 	FieldURLFull:                      reflect.String,
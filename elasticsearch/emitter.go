@@ -0,0 +1,411 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+	"github.com/sporkmonger/ecsevent/internal/batch"
+	"github.com/sporkmonger/ecsevent/internal/interpolate"
+)
+
+const (
+	defaultServer        = "http://localhost:9200"
+	defaultIndex         = "ecs-%{+2006.01.02}"
+	defaultBatchSize     = 500
+	defaultBufferSize    = 10000
+	defaultFlushInterval = 5 * time.Second
+	defaultMaxRetries    = 3
+	defaultRetryBackoff  = 500 * time.Millisecond
+)
+
+// Emitter buffers ECS formatted events and ships them to Elasticsearch
+// using the bulk API.
+//
+// Events are sent when the batch size is reached, when the flush interval
+// elapses, or when Flush or Close is called.
+type Emitter struct {
+	server        string
+	index         string
+	dataStream    string
+	username      string
+	password      string
+	apiKey        string
+	client        *http.Client
+	batchSize     int
+	bufferSize    int
+	flushInterval time.Duration
+	maxRetries    int
+	retryBackoff  time.Duration
+	errorHandler  func(error)
+
+	batcher *batch.Batcher
+}
+
+// ErrBufferFull is reported when an event is dropped because the buffer
+// already holds the maximum number of events, e.g. while Elasticsearch is
+// unavailable and earlier batches are being retried.
+var ErrBufferFull = errors.New("elasticsearch emitter buffer is full, event dropped")
+
+// Option configures an Emitter as it's being initialized.
+type Option func(*Emitter)
+
+// Server sets the Elasticsearch origin to ship events to. No trailing slash,
+// typically. Defaults to 'http://localhost:9200'.
+func Server(server string) Option {
+	return func(e *Emitter) {
+		e.server = strings.TrimSuffix(server, "/")
+	}
+}
+
+// Index sets the index name template. Field values may be interpolated with
+// `%{field.name}` and the event timestamp with `%{+layout}`, where layout is
+// a Go time layout, e.g. 'ecs-%{service.name}-%{+2006.01.02}'. Fields missing
+// from the event interpolate as empty strings. Defaults to
+// 'ecs-%{+2006.01.02}'.
+func Index(template string) Option {
+	return func(e *Emitter) {
+		e.index = template
+	}
+}
+
+// DataStream sends events to the 'logs-<dataset>-<namespace>' data stream
+// instead of an index. The dataset is taken from the event's event.dataset
+// field, falling back to 'generic'. Overrides Index.
+func DataStream(namespace string) Option {
+	return func(e *Emitter) {
+		e.dataStream = namespace
+	}
+}
+
+// BasicAuth authenticates requests with a username and password.
+func BasicAuth(username, password string) Option {
+	return func(e *Emitter) {
+		e.username = username
+		e.password = password
+	}
+}
+
+// APIKey authenticates requests with a base64 encoded API key, as returned
+// in the 'encoded' field of the create API key response.
+func APIKey(apiKey string) Option {
+	return func(e *Emitter) {
+		e.apiKey = apiKey
+	}
+}
+
+// HTTPClient sets the client used to send bulk requests.
+func HTTPClient(client *http.Client) Option {
+	return func(e *Emitter) {
+		e.client = client
+	}
+}
+
+// BatchSize sets the number of buffered events that triggers a bulk request.
+// Values below 1 use the default of 500.
+func BatchSize(size int) Option {
+	return func(e *Emitter) {
+		e.batchSize = size
+	}
+}
+
+// BufferSize sets the maximum number of events held in memory while waiting
+// to be sent. Events emitted while the buffer is full are dropped and
+// reported as ErrBufferFull. Defaults to 10000.
+func BufferSize(size int) Option {
+	return func(e *Emitter) {
+		e.bufferSize = size
+	}
+}
+
+// FlushInterval sets the maximum time an event will remain buffered. Values
+// below 1 use the default of 5 seconds.
+func FlushInterval(interval time.Duration) Option {
+	return func(e *Emitter) {
+		e.flushInterval = interval
+	}
+}
+
+// MaxRetries sets how many times events rejected with a 429 or 5xx status
+// will be retried before they're dropped.
+func MaxRetries(retries int) Option {
+	return func(e *Emitter) {
+		e.maxRetries = retries
+	}
+}
+
+// RetryBackoff sets the base delay between retries. The delay doubles with
+// each attempt and has jitter applied.
+func RetryBackoff(backoff time.Duration) Option {
+	return func(e *Emitter) {
+		e.retryBackoff = backoff
+	}
+}
+
+// ErrorHandler sets a callback for errors encountered while shipping events,
+// since Emit has no way to return them. Rejected events are reported as
+// *ItemError values.
+func ErrorHandler(handler func(error)) Option {
+	return func(e *Emitter) {
+		e.errorHandler = handler
+	}
+}
+
+// New creates a new Emitter with the given Option functions applied and
+// starts its background flush loop. Call Close to stop it.
+func New(opts ...Option) *Emitter {
+	e := &Emitter{
+		server:        defaultServer,
+		index:         defaultIndex,
+		batchSize:     defaultBatchSize,
+		bufferSize:    defaultBufferSize,
+		flushInterval: defaultFlushInterval,
+		maxRetries:    defaultMaxRetries,
+		retryBackoff:  defaultRetryBackoff,
+		errorHandler:  func(error) {},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.batchSize <= 0 {
+		e.batchSize = defaultBatchSize
+	}
+	if e.flushInterval <= 0 {
+		e.flushInterval = defaultFlushInterval
+	}
+	if e.client == nil {
+		e.client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: (&net.Dialer{
+					Timeout:   10 * time.Second,
+					KeepAlive: 10 * time.Second,
+				}).DialContext,
+				TLSHandshakeTimeout:   10 * time.Second,
+				ExpectContinueTimeout: 4 * time.Second,
+				ResponseHeaderTimeout: 30 * time.Second,
+			},
+			Timeout: 2 * time.Minute,
+		}
+	}
+	e.batcher = batch.New(batch.Config{
+		Send:          e.send,
+		BatchSize:     e.batchSize,
+		BufferSize:    e.bufferSize,
+		FlushInterval: e.flushInterval,
+	})
+	return e
+}
+
+// ItemError describes an event rejected by Elasticsearch.
+type ItemError struct {
+	Index  string
+	Status int
+	Type   string
+	Reason string
+}
+
+func (ie *ItemError) Error() string {
+	return fmt.Sprintf("elasticsearch rejected event for index '%s' with status %d: %s: %s",
+		ie.Index, ie.Status, ie.Type, ie.Reason)
+}
+
+type bulkItem struct {
+	action   string
+	index    string
+	document []byte
+}
+
+type bulkResponse struct {
+	Errors bool                          `json:"errors"`
+	Items  []map[string]bulkResponseItem `json:"items"`
+}
+
+type bulkResponseItem struct {
+	Index  string `json:"_index"`
+	Status int    `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// Emit takes a map of ECS fields and values and buffers the event for the
+// next bulk request.
+func (e *Emitter) Emit(event map[string]interface{}) {
+	document, err := json.Marshal(event)
+	if err != nil {
+		e.errorHandler(err)
+		return
+	}
+	item := &bulkItem{
+		action:   "index",
+		index:    e.indexName(event),
+		document: document,
+	}
+	if e.dataStream != "" {
+		// Data streams are append-only.
+		item.action = "create"
+	}
+	switch e.batcher.Add(item) {
+	case batch.ErrClosed:
+		e.errorHandler(errors.New("elasticsearch emitter is closed"))
+	case batch.ErrBufferFull:
+		e.errorHandler(ErrBufferFull)
+	}
+}
+
+// Flush synchronously sends all buffered events.
+func (e *Emitter) Flush() {
+	e.batcher.Flush()
+}
+
+// Close flushes any buffered events and stops the background flush loop.
+// Events emitted after Close are dropped.
+func (e *Emitter) Close() error {
+	e.batcher.Close()
+	return nil
+}
+
+// send delivers a batch, retrying the items that failed with a retryable
+// status.
+func (e *Emitter) send(items []interface{}) {
+	bulk := make([]*bulkItem, len(items))
+	for i, item := range items {
+		bulk[i] = item.(*bulkItem)
+	}
+	for attempt := 0; len(bulk) > 0; attempt++ {
+		if attempt > 0 {
+			time.Sleep(batch.Backoff(e.retryBackoff, 0, attempt))
+		}
+		retry, err := e.post(bulk)
+		if err != nil {
+			e.errorHandler(err)
+		}
+		if len(retry) > 0 && attempt >= e.maxRetries {
+			for _, re := range retry {
+				e.errorHandler(re.ItemError)
+			}
+			return
+		}
+		bulk = make([]*bulkItem, 0, len(retry))
+		for _, re := range retry {
+			bulk = append(bulk, re.item)
+		}
+	}
+}
+
+type retryableError struct {
+	*ItemError
+	item *bulkItem
+}
+
+// post sends a single bulk request. It returns the items that should be
+// retried along with any error not attributable to a single item.
+func (e *Emitter) post(batch []*bulkItem) ([]retryableError, error) {
+	body := &bytes.Buffer{}
+	for _, item := range batch {
+		action := map[string]map[string]string{
+			item.action: {"_index": item.index},
+		}
+		header, _ := json.Marshal(action)
+		body.Write(header)
+		body.WriteByte('\n')
+		body.Write(item.document)
+		body.WriteByte('\n')
+	}
+	req, err := http.NewRequest(http.MethodPost, e.server+"/_bulk", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+e.apiKey)
+	} else if e.username != "" {
+		req.SetBasicAuth(e.username, e.password)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return retryAll(batch, 0, err.Error()), nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return retryAll(batch, resp.StatusCode, resp.Status), nil
+	}
+	if resp.StatusCode >= 300 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("elasticsearch bulk request failed with status %d: %s",
+			resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	br := &bulkResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(br); err != nil {
+		return nil, fmt.Errorf("could not parse elasticsearch bulk response: %v", err)
+	}
+	if !br.Errors {
+		return nil, nil
+	}
+	var retry []retryableError
+	for i, result := range br.Items {
+		if i >= len(batch) {
+			break
+		}
+		for _, ri := range result {
+			if ri.Error == nil && ri.Status < 300 {
+				continue
+			}
+			ie := &ItemError{Index: ri.Index, Status: ri.Status}
+			if ri.Error != nil {
+				ie.Type = ri.Error.Type
+				ie.Reason = ri.Error.Reason
+			}
+			if ri.Status == http.StatusTooManyRequests || ri.Status >= 500 {
+				retry = append(retry, retryableError{ItemError: ie, item: batch[i]})
+			} else {
+				e.errorHandler(ie)
+			}
+		}
+	}
+	return retry, nil
+}
+
+func retryAll(batch []*bulkItem, status int, reason string) []retryableError {
+	retry := make([]retryableError, 0, len(batch))
+	for _, item := range batch {
+		retry = append(retry, retryableError{
+			ItemError: &ItemError{Index: item.index, Status: status, Reason: reason},
+			item:      item,
+		})
+	}
+	return retry
+}
+
+// indexName renders the index template for an event.
+func (e *Emitter) indexName(event map[string]interface{}) string {
+	if e.dataStream != "" {
+		dataset := "generic"
+		if value, ok := ecsevent.Lookup(event, ecsevent.FieldEventDataset); ok {
+			if s, ok := value.(string); ok && s != "" {
+				// Hyphens separate the components of a data stream name.
+				dataset = strings.Replace(s, "-", "_", -1)
+			}
+		}
+		return strings.ToLower("logs-" + dataset + "-" + e.dataStream)
+	}
+	// Index names must be lowercase.
+	return strings.ToLower(interpolate.Event(e.index, event))
+}
+
+var (
+	// This is a compile-time check to make sure our types correctly
+	// implement the interface:
+	// https://medium.com/@matryer/c167afed3aae
+	_ ecsevent.Emitter = &Emitter{}
+)
@@ -0,0 +1,351 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type bulkAction struct {
+	action   string
+	index    string
+	document map[string]interface{}
+}
+
+// fakeElasticsearch is a minimal stand-in for the _bulk endpoint. The
+// respond function decides the status of each item.
+type fakeElasticsearch struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	actions  [][]bulkAction
+	respond  func(request int, item int, action bulkAction) (int, string)
+}
+
+func (fe *fakeElasticsearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	if r.URL.Path != "/_bulk" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	actions := []bulkAction{}
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		header := map[string]map[string]string{}
+		json.Unmarshal(scanner.Bytes(), &header)
+		scanner.Scan()
+		document := map[string]interface{}{}
+		json.Unmarshal(scanner.Bytes(), &document)
+		for action, meta := range header {
+			actions = append(actions, bulkAction{action: action, index: meta["_index"], document: document})
+		}
+	}
+	requestNumber := len(fe.requests)
+	fe.requests = append(fe.requests, r)
+	fe.actions = append(fe.actions, actions)
+
+	errors := false
+	items := []string{}
+	for i, action := range actions {
+		status, errorType := http.StatusCreated, ""
+		if fe.respond != nil {
+			status, errorType = fe.respond(requestNumber, i, action)
+		}
+		if errorType != "" {
+			errors = true
+			items = append(items, fmt.Sprintf(
+				`{"%s":{"_index":"%s","status":%d,"error":{"type":"%s","reason":"nope"}}}`,
+				action.action, action.index, status, errorType))
+		} else {
+			items = append(items, fmt.Sprintf(
+				`{"%s":{"_index":"%s","status":%d}}`, action.action, action.index, status))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"took":1,"errors":%t,"items":[%s]}`, errors, strings.Join(items, ","))
+}
+
+func TestEmitter(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeElasticsearch{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	emitter := New(Server(server.URL), BasicAuth("elastic", "changeme"))
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldTimestamp: time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC),
+		ecsevent.FieldMessage:   "hello world",
+	})
+	emitter.Emit(map[string]interface{}{
+		"@timestamp": "2020-04-02T12:00:00Z",
+		"http": map[string]interface{}{
+			"request": map[string]interface{}{
+				"method": "GET",
+			},
+		},
+	})
+	assert.NoError(emitter.Close())
+
+	assert.Len(fake.requests, 1)
+	username, password, ok := fake.requests[0].BasicAuth()
+	assert.True(ok)
+	assert.Equal("elastic", username)
+	assert.Equal("changeme", password)
+	assert.Equal("application/x-ndjson", fake.requests[0].Header.Get("Content-Type"))
+	assert.Equal([]bulkAction{
+		{
+			action: "index",
+			index:  "ecs-2020.04.01",
+			document: map[string]interface{}{
+				"@timestamp": "2020-04-01T12:00:00Z",
+				"message":    "hello world",
+			},
+		},
+		{
+			action: "index",
+			index:  "ecs-2020.04.02",
+			document: map[string]interface{}{
+				"@timestamp": "2020-04-02T12:00:00Z",
+				"http": map[string]interface{}{
+					"request": map[string]interface{}{
+						"method": "GET",
+					},
+				},
+			},
+		},
+	}, fake.actions[0])
+}
+
+func TestEmitterIndexName(t *testing.T) {
+	timestamp := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	tcs := []struct {
+		name          string
+		options       []Option
+		event         map[string]interface{}
+		expectedIndex string
+	}{
+		{
+			"static",
+			[]Option{Index("logs")},
+			map[string]interface{}{},
+			"logs",
+		},
+		{
+			"field and date",
+			[]Option{Index("ecs-%{service.name}-%{+2006.01}")},
+			map[string]interface{}{
+				ecsevent.FieldTimestamp:   timestamp,
+				ecsevent.FieldServiceName: "Checkout",
+			},
+			"ecs-checkout-2020.04",
+		},
+		{
+			"missing field",
+			[]Option{Index("ecs-%{service.name}")},
+			map[string]interface{}{},
+			"ecs-",
+		},
+		{
+			"data stream",
+			[]Option{DataStream("production")},
+			map[string]interface{}{
+				ecsevent.FieldEventDataset: "nginx-access",
+			},
+			"logs-nginx_access-production",
+		},
+		{
+			"data stream without dataset",
+			[]Option{DataStream("default")},
+			map[string]interface{}{},
+			"logs-generic-default",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			emitter := New(tc.options...)
+			defer emitter.Close()
+			assert.Equal(tc.expectedIndex, emitter.indexName(tc.event))
+		})
+	}
+}
+
+func TestEmitterDataStream(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeElasticsearch{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	emitter := New(Server(server.URL), DataStream("default"), APIKey("c2VjcmV0"))
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+
+	assert.Len(fake.requests, 1)
+	assert.Equal("ApiKey c2VjcmV0", fake.requests[0].Header.Get("Authorization"))
+	assert.Equal("create", fake.actions[0][0].action)
+	assert.Equal("logs-generic-default", fake.actions[0][0].index)
+}
+
+func TestEmitterItemErrors(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeElasticsearch{
+		respond: func(request int, item int, action bulkAction) (int, string) {
+			switch action.document["message"] {
+			case "throttled":
+				// Rejected on the first attempt only.
+				if request == 0 {
+					return http.StatusTooManyRequests, "es_rejected_execution_exception"
+				}
+			case "invalid":
+				return http.StatusBadRequest, "mapper_parsing_exception"
+			case "unavailable":
+				return http.StatusServiceUnavailable, "unavailable_shards_exception"
+			}
+			return http.StatusCreated, ""
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	var errs []error
+	emitter := New(
+		Server(server.URL),
+		MaxRetries(2),
+		RetryBackoff(time.Millisecond),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "ok"})
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "throttled"})
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "invalid"})
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "unavailable"})
+	assert.NoError(emitter.Close())
+
+	// 1 initial request, then 2 retries for the unavailable event, the first
+	// of which also carries the throttled event.
+	assert.Len(fake.requests, 3)
+	assert.Len(fake.actions[0], 4)
+	assert.Len(fake.actions[1], 2)
+	assert.Len(fake.actions[2], 1)
+	assert.Equal("throttled", fake.actions[1][0].document["message"])
+
+	assert.Len(errs, 2)
+	if len(errs) == 2 {
+		ie, ok := errs[0].(*ItemError)
+		assert.True(ok)
+		assert.Equal(http.StatusBadRequest, ie.Status)
+		assert.Equal("mapper_parsing_exception", ie.Type)
+		ie, ok = errs[1].(*ItemError)
+		assert.True(ok)
+		assert.Equal(http.StatusServiceUnavailable, ie.Status)
+	}
+}
+
+func TestEmitterRequestRetry(t *testing.T) {
+	assert := assert.New(t)
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, `{"took":1,"errors":false,"items":[{"index":{"status":201}}]}`)
+	}))
+	defer server.Close()
+
+	var errs []error
+	emitter := New(
+		Server(server.URL),
+		RetryBackoff(time.Millisecond),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+	assert.Equal(2, attempts)
+	assert.Empty(errs)
+}
+
+func TestEmitterBatchSize(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeElasticsearch{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	emitter := New(Server(server.URL), BatchSize(2), FlushInterval(time.Hour))
+	for i := 0; i < 5; i++ {
+		emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	}
+	assert.NoError(emitter.Close())
+
+	total := 0
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	for _, actions := range fake.actions {
+		assert.True(len(actions) <= 2)
+		total += len(actions)
+	}
+	assert.Equal(5, total)
+}
+
+func TestEmitterBuffer(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeElasticsearch{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	var errs []error
+	emitter := New(
+		Server(server.URL),
+		BufferSize(2),
+		FlushInterval(time.Hour),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	for i := 0; i < 3; i++ {
+		emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	}
+	assert.Equal([]error{ErrBufferFull}, errs)
+	emitter.Flush()
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+	assert.Len(errs, 1)
+
+	total := 0
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	for _, actions := range fake.actions {
+		total += len(actions)
+	}
+	assert.Equal(3, total)
+}
+
+func TestEmitterInvalidBatching(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeElasticsearch{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	emitter := New(Server(server.URL), BatchSize(0), FlushInterval(0))
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Len(fake.actions, 1)
+}
@@ -0,0 +1,130 @@
+package ecsevent
+
+import (
+	"path"
+)
+
+// EmitterConfig holds the settings of an emitter appended to a RootMonitor.
+type EmitterConfig struct {
+	// Processors are run only on the events passed to the emitter, after the
+	// RootMonitor's processors.
+	Processors []Processor
+	// Filters are run after Processors. The filtering options, e.g.
+	// MinSeverity, append to them.
+	Filters []Processor
+	// Nested overrides the RootMonitor's NestEvents setting, if not nil.
+	Nested *bool
+}
+
+// EmitterOption configures an emitter as it's appended to a RootMonitor, so
+// that one monitor can feed emitters with different needs, e.g. a verbose
+// local file and a filtered remote backend.
+type EmitterOption func(*EmitterConfig)
+
+// EmitterProcessors appends processors run only on the events passed to the
+// emitter, after the RootMonitor's processors.
+func EmitterProcessors(processors ...Processor) EmitterOption {
+	return func(ec *EmitterConfig) {
+		ec.Processors = append(ec.Processors, processors...)
+	}
+}
+
+// EmitNested overrides the RootMonitor's NestEvents setting for the emitter,
+// e.g. to pass flat events to an emitter that would otherwise unnest them.
+func EmitNested(nested bool) EmitterOption {
+	return func(ec *EmitterConfig) {
+		ec.Nested = &nested
+	}
+}
+
+// MinSeverity drops events with a log.level less severe than severity.
+// Events without a recognized log.level, e.g. those of a SpanMonitor, are
+// passed on.
+func MinSeverity(severity Severity) EmitterOption {
+	return func(ec *EmitterConfig) {
+		ec.Filters = append(ec.Filters, func(event map[string]interface{}) (map[string]interface{}, bool) {
+			level, ok := event[FieldLogLevel].(string)
+			if !ok {
+				return event, true
+			}
+			eventSeverity, ok := ParseSeverity(level)
+			return event, !ok || eventSeverity <= severity
+		})
+	}
+}
+
+// EventKinds passes on only events with one of the given event.kind values,
+// e.g. "event" or "metric". Events without event.kind are of kind "event".
+func EventKinds(kinds ...string) EmitterOption {
+	allowed := make(map[string]bool, len(kinds))
+	for _, kind := range kinds {
+		allowed[kind] = true
+	}
+	return func(ec *EmitterConfig) {
+		ec.Filters = append(ec.Filters, func(event map[string]interface{}) (map[string]interface{}, bool) {
+			kind, ok := event[FieldEventKind].(string)
+			if !ok {
+				kind = "event"
+			}
+			return event, allowed[kind]
+		})
+	}
+}
+
+// IncludeFields passes on only the fields matching one of the glob patterns,
+// e.g. "http.*" or "message". Patterns use path.Match syntax, where '*'
+// also matches dots. The fields of subevents are filtered too.
+func IncludeFields(patterns ...string) EmitterOption {
+	return func(ec *EmitterConfig) {
+		ec.Filters = append(ec.Filters, func(event map[string]interface{}) (map[string]interface{}, bool) {
+			return filterFields(event, patterns, true), true
+		})
+	}
+}
+
+// ExcludeFields removes the fields matching any of the glob patterns, e.g.
+// "user.*". Patterns use path.Match syntax, where '*' also matches dots. The
+// fields of subevents are filtered too.
+func ExcludeFields(patterns ...string) EmitterOption {
+	return func(ec *EmitterConfig) {
+		ec.Filters = append(ec.Filters, func(event map[string]interface{}) (map[string]interface{}, bool) {
+			return filterFields(event, patterns, false), true
+		})
+	}
+}
+
+// filterFields returns a copy of a flat event with only the fields matching
+// the patterns, if include is true, or only those not matching them.
+// event.subevents is kept, filtered, if any of its subevents' fields are.
+func filterFields(event map[string]interface{}, patterns []string, include bool) map[string]interface{} {
+	filtered := make(map[string]interface{}, len(event))
+	for key, value := range event {
+		if key == FieldEventSubevents {
+			if subevents, ok := value.([]map[string]interface{}); ok {
+				filteredSubevents := make([]map[string]interface{}, 0, len(subevents))
+				for _, subevent := range subevents {
+					if subevent = filterFields(subevent, patterns, include); len(subevent) > 0 {
+						filteredSubevents = append(filteredSubevents, subevent)
+					}
+				}
+				if len(filteredSubevents) > 0 {
+					filtered[key] = filteredSubevents
+				}
+				continue
+			}
+		}
+		if matchAny(patterns, key) == include {
+			filtered[key] = value
+		}
+	}
+	return filtered
+}
+
+func matchAny(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package ecsevent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmitterOptions(t *testing.T) {
+	tcs := []struct {
+		name           string
+		opts           []EmitterOption
+		events         []map[string]interface{}
+		expectedOutput []map[string]interface{}
+	}{
+		{
+			"monitor nesting",
+			nil,
+			[]map[string]interface{}{
+				{FieldMessage: "hello", FieldLogLevel: "info"},
+			},
+			[]map[string]interface{}{
+				{"message": "hello", "log": map[string]interface{}{"level": "info"}},
+			},
+		},
+		{
+			"flat",
+			[]EmitterOption{EmitNested(false)},
+			[]map[string]interface{}{
+				{FieldMessage: "hello", FieldLogLevel: "info"},
+			},
+			[]map[string]interface{}{
+				{FieldMessage: "hello", FieldLogLevel: "info"},
+			},
+		},
+		{
+			"min severity",
+			[]EmitterOption{EmitNested(false), MinSeverity(SeverityWarning)},
+			[]map[string]interface{}{
+				{FieldMessage: "debug", FieldLogLevel: "debug"},
+				{FieldMessage: "warn", FieldLogLevel: "WARN"},
+				{FieldMessage: "error", FieldLogLevel: "error"},
+				{FieldMessage: "no level"},
+			},
+			[]map[string]interface{}{
+				{FieldMessage: "warn", FieldLogLevel: "WARN"},
+				{FieldMessage: "error", FieldLogLevel: "error"},
+				{FieldMessage: "no level"},
+			},
+		},
+		{
+			"event kinds",
+			[]EmitterOption{EmitNested(false), EventKinds("event", "alert")},
+			[]map[string]interface{}{
+				{FieldMessage: "default"},
+				{FieldMessage: "metric", FieldEventKind: "metric"},
+				{FieldMessage: "alert", FieldEventKind: "alert"},
+			},
+			[]map[string]interface{}{
+				{FieldMessage: "default"},
+				{FieldMessage: "alert", FieldEventKind: "alert"},
+			},
+		},
+		{
+			"include fields",
+			[]EmitterOption{EmitNested(false), IncludeFields("http.*", FieldMessage)},
+			[]map[string]interface{}{
+				{
+					FieldMessage:           "hello",
+					FieldHTTPRequestMethod: "GET",
+					FieldUserName:          "alice",
+					FieldEventSubevents: []map[string]interface{}{
+						{FieldMessage: "sub", FieldUserName: "alice"},
+						{FieldUserName: "alice"},
+					},
+				},
+			},
+			[]map[string]interface{}{
+				{
+					FieldMessage:           "hello",
+					FieldHTTPRequestMethod: "GET",
+					FieldEventSubevents: []map[string]interface{}{
+						{FieldMessage: "sub"},
+					},
+				},
+			},
+		},
+		{
+			"exclude fields",
+			[]EmitterOption{EmitNested(false), ExcludeFields("user.*")},
+			[]map[string]interface{}{
+				{FieldMessage: "hello", FieldUserName: "alice", FieldUserEmail: "alice@example.com"},
+			},
+			[]map[string]interface{}{
+				{FieldMessage: "hello"},
+			},
+		},
+		{
+			"custom option",
+			[]EmitterOption{
+				EventKinds("event"),
+				func(ec *EmitterConfig) {
+					nested := false
+					ec.Nested = &nested
+					ec.Filters = append([]Processor{func(event map[string]interface{}) (map[string]interface{}, bool) {
+						return event, event[FieldMessage] != "secret"
+					}}, ec.Filters...)
+				},
+			},
+			[]map[string]interface{}{
+				{FieldMessage: "hello"},
+				{FieldMessage: "secret"},
+			},
+			[]map[string]interface{}{
+				{FieldMessage: "hello"},
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			mock := &mockEmitter{}
+			verbose := &mockEmitter{}
+			rm := NewRootMonitor()
+			rm.AppendEmitter(mock, tc.opts...)
+			rm.AppendEmitter(verbose, EmitNested(false))
+			for _, event := range tc.events {
+				rm.Record(event)
+			}
+			assert.Equal(tc.expectedOutput, mock.events)
+			assert.Equal(tc.events, verbose.events)
+		})
+	}
+}
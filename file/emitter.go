@@ -0,0 +1,528 @@
+package file
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+const (
+	defaultMaxSize   = 100 * 1024 * 1024
+	backupTimeLayout = "2006-01-02T15-04-05.000"
+	compressSuffix   = ".gz"
+)
+
+// SyncPolicy selects when written events are flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncNever leaves flushing to the operating system.
+	SyncNever SyncPolicy = iota
+	// SyncAlways calls fsync after every event.
+	SyncAlways
+	// SyncInterval calls fsync periodically, see SyncEvery.
+	SyncInterval
+)
+
+// ErrClosed is reported when events are emitted after Close.
+var ErrClosed = errors.New("file emitter is closed")
+
+// Emitter writes ECS formatted events as nested NDJSON to a local file,
+// suitable for Filebeat to tail. The file is rotated when it reaches a
+// maximum size or when a rotation interval elapses.
+type Emitter struct {
+	path         string
+	maxSize      int64
+	rotateEvery  time.Duration
+	maxBackups   int
+	compress     bool
+	syncPolicy   SyncPolicy
+	syncInterval time.Duration
+	reopenOnHUP  bool
+	perm         os.FileMode
+	now          func() time.Time
+	errorHandler func(error)
+
+	// mu gates the file and its bookkeeping.
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	dirty    bool
+	closed   bool
+	// millMu serializes compression and pruning of backups.
+	millMu  sync.Mutex
+	signals chan os.Signal
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Option configures an Emitter as it's being initialized.
+type Option func(*Emitter)
+
+// MaxSize sets the size in bytes at which the file is rotated. Defaults to
+// 100MB. Zero disables size-based rotation.
+func MaxSize(size int64) Option {
+	return func(e *Emitter) {
+		e.maxSize = size
+	}
+}
+
+// RotateEvery rotates the file when the wall clock crosses a multiple of the
+// interval, e.g. time.Hour rotates at the top of each hour. Disabled by
+// default.
+func RotateEvery(interval time.Duration) Option {
+	return func(e *Emitter) {
+		e.rotateEvery = interval
+	}
+}
+
+// MaxBackups sets the number of rotated files to keep, oldest are removed
+// first. Zero keeps all of them, which is the default.
+func MaxBackups(backups int) Option {
+	return func(e *Emitter) {
+		e.maxBackups = backups
+	}
+}
+
+// Compress gzips rotated files in the background.
+func Compress(compress bool) Option {
+	return func(e *Emitter) {
+		e.compress = compress
+	}
+}
+
+// Sync sets the fsync policy. Defaults to SyncNever.
+func Sync(policy SyncPolicy) Option {
+	return func(e *Emitter) {
+		e.syncPolicy = policy
+	}
+}
+
+// SyncEvery calls fsync at most once per interval if events were written,
+// implying the SyncInterval policy.
+func SyncEvery(interval time.Duration) Option {
+	return func(e *Emitter) {
+		e.syncPolicy = SyncInterval
+		e.syncInterval = interval
+	}
+}
+
+// ReopenOnSIGHUP reopens the file whenever the process receives SIGHUP, so
+// that logrotate may move it aside without using copytruncate.
+func ReopenOnSIGHUP() Option {
+	return func(e *Emitter) {
+		e.reopenOnHUP = true
+	}
+}
+
+// Permissions sets the mode new files are created with. Defaults to 0644.
+func Permissions(perm os.FileMode) Option {
+	return func(e *Emitter) {
+		e.perm = perm
+	}
+}
+
+// ErrorHandler sets a callback for errors encountered while writing events,
+// since Emit has no way to return them.
+func ErrorHandler(handler func(error)) Option {
+	return func(e *Emitter) {
+		e.errorHandler = handler
+	}
+}
+
+// New opens path for appending, creating it if necessary, and returns an
+// Emitter with the given Option functions applied. Call Close to release the
+// file.
+func New(path string, opts ...Option) (*Emitter, error) {
+	e := &Emitter{
+		path:         path,
+		maxSize:      defaultMaxSize,
+		syncInterval: time.Second,
+		perm:         0644,
+		now:          time.Now,
+		errorHandler: func(error) {},
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	if e.syncPolicy == SyncInterval {
+		e.wg.Add(1)
+		go e.syncLoop()
+	}
+	if e.reopenOnHUP {
+		e.signals = make(chan os.Signal, 1)
+		signal.Notify(e.signals, syscall.SIGHUP)
+		e.wg.Add(1)
+		go e.signalLoop()
+	}
+	return e, nil
+}
+
+// Emit takes a map of ECS fields and values and appends it to the file as a
+// line of nested JSON.
+func (e *Emitter) Emit(event map[string]interface{}) {
+	data, err := json.Marshal(ecsevent.Nest(event))
+	if err != nil {
+		e.errorHandler(err)
+		return
+	}
+	data = append(data, '\n')
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		e.errorHandler(ErrClosed)
+		return
+	}
+	if e.shouldRotate(int64(len(data))) {
+		if err := e.rotate(); err != nil {
+			e.errorHandler(err)
+			if e.file == nil {
+				return
+			}
+		}
+	}
+	n, err := e.file.Write(data)
+	e.size += int64(n)
+	if err != nil {
+		e.errorHandler(err)
+		return
+	}
+	e.dirty = true
+	if e.syncPolicy == SyncAlways {
+		if err := e.file.Sync(); err != nil {
+			e.errorHandler(err)
+		}
+		e.dirty = false
+	}
+}
+
+// Rotate closes the current file, moves it aside as a backup and opens a new
+// one.
+func (e *Emitter) Rotate() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return ErrClosed
+	}
+	return e.rotate()
+}
+
+// Reopen closes and reopens the file at the configured path without moving
+// it, for use after an external tool such as logrotate has renamed it.
+func (e *Emitter) Reopen() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return ErrClosed
+	}
+	if err := e.closeFile(); err != nil {
+		e.errorHandler(err)
+	}
+	return e.open()
+}
+
+// Close syncs and closes the file and waits for any background compression
+// to finish.
+func (e *Emitter) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	err := e.closeFile()
+	e.mu.Unlock()
+	if e.signals != nil {
+		signal.Stop(e.signals)
+	}
+	close(e.done)
+	e.wg.Wait()
+	return err
+}
+
+// open opens the file for appending. It must be called with mu held.
+func (e *Emitter) open() error {
+	if dir := filepath.Dir(e.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(e.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, e.perm)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	e.file = f
+	e.size = info.Size()
+	e.openedAt = e.now()
+	return nil
+}
+
+// closeFile syncs and closes the file. It must be called with mu held.
+func (e *Emitter) closeFile() error {
+	if e.file == nil {
+		return nil
+	}
+	err := e.file.Sync()
+	if closeErr := e.file.Close(); err == nil {
+		err = closeErr
+	}
+	e.file = nil
+	e.dirty = false
+	return err
+}
+
+// shouldRotate reports whether writing n more bytes requires a rotation. It
+// must be called with mu held.
+func (e *Emitter) shouldRotate(n int64) bool {
+	if e.file == nil {
+		return true
+	}
+	if e.maxSize > 0 && e.size > 0 && e.size+n > e.maxSize {
+		return true
+	}
+	if e.rotateEvery > 0 {
+		return !e.now().Truncate(e.rotateEvery).Equal(e.openedAt.Truncate(e.rotateEvery))
+	}
+	return false
+}
+
+// rotate moves the current file aside and opens a new one. It must be called
+// with mu held.
+func (e *Emitter) rotate() error {
+	if err := e.closeFile(); err != nil {
+		e.errorHandler(err)
+	}
+	backup := ""
+	if info, err := os.Stat(e.path); err == nil && info.Size() > 0 {
+		backup = e.backupName()
+		if err := os.Rename(e.path, backup); err != nil {
+			return err
+		}
+	}
+	if err := e.open(); err != nil {
+		return err
+	}
+	e.wg.Add(1)
+	go e.mill(backup)
+	return nil
+}
+
+// backupName returns an unused name for a backup, derived from the current
+// time, e.g. 'ecs-2006-01-02T15-04-05.000.json'.
+func (e *Emitter) backupName() string {
+	dir, base := filepath.Split(e.path)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+	stamp := e.now().UTC().Format(backupTimeLayout)
+	// Number the backup after every other one with the same stamp, even if
+	// earlier ones were pruned, so that it sorts as the newest.
+	seq := 0
+	if backups, err := e.backups(); err == nil {
+		for _, b := range backups {
+			if b.stamp == stamp && b.seq >= seq {
+				seq = b.seq + 1
+			}
+		}
+	}
+	for ; ; seq++ {
+		name := filepath.Join(dir, prefix+stamp+ext)
+		if seq > 0 {
+			name = filepath.Join(dir, fmt.Sprintf("%s%s-%d%s", prefix, stamp, seq, ext))
+		}
+		if !exists(name) && !exists(name+compressSuffix) {
+			return name
+		}
+	}
+}
+
+// mill compresses a new backup, if enabled, and removes backups beyond the
+// maximum.
+func (e *Emitter) mill(backup string) {
+	defer e.wg.Done()
+	e.millMu.Lock()
+	defer e.millMu.Unlock()
+	if e.compress && backup != "" {
+		if err := compressFile(backup); err != nil {
+			e.errorHandler(err)
+		}
+	}
+	if e.maxBackups <= 0 {
+		return
+	}
+	backups, err := e.backups()
+	if err != nil {
+		e.errorHandler(err)
+		return
+	}
+	for len(backups) > e.maxBackups {
+		if err := os.Remove(backups[0].name); err != nil {
+			e.errorHandler(err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// backup is an existing backup file. Backups made within the same
+// millisecond share a stamp and are told apart by a '-N' suffix, see
+// backupName.
+type backup struct {
+	name  string
+	stamp string
+	seq   int
+}
+
+// backups lists existing backups, oldest first.
+func (e *Emitter) backups() ([]backup, error) {
+	dir, base := filepath.Split(e.path)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	var backups []backup
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name, compressSuffix), ext)
+		stamp = strings.TrimPrefix(stamp, prefix)
+		if len(stamp) < len(backupTimeLayout) {
+			continue
+		}
+		if _, err := time.Parse(backupTimeLayout, stamp[:len(backupTimeLayout)]); err != nil {
+			continue
+		}
+		seq := 0
+		if suffix := stamp[len(backupTimeLayout):]; suffix != "" {
+			n, err := strconv.Atoi(strings.TrimPrefix(suffix, "-"))
+			if err != nil || !strings.HasPrefix(suffix, "-") {
+				continue
+			}
+			seq = n
+		}
+		backups = append(backups, backup{
+			name:  filepath.Join(dir, name),
+			stamp: stamp[:len(backupTimeLayout)],
+			seq:   seq,
+		})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].stamp != backups[j].stamp {
+			return backups[i].stamp < backups[j].stamp
+		}
+		return backups[i].seq < backups[j].seq
+	})
+	return backups, nil
+}
+
+func (e *Emitter) syncLoop() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(e.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.mu.Lock()
+			if e.dirty && e.file != nil {
+				if err := e.file.Sync(); err != nil {
+					e.errorHandler(err)
+				}
+				e.dirty = false
+			}
+			e.mu.Unlock()
+		case <-e.done:
+			return
+		}
+	}
+}
+
+func (e *Emitter) signalLoop() {
+	defer e.wg.Done()
+	for {
+		select {
+		case <-e.signals:
+			if err := e.Reopen(); err != nil && err != ErrClosed {
+				e.errorHandler(err)
+			}
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// compressFile gzips a file, replacing it with a '.gz' suffixed copy.
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(name+compressSuffix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(name + compressSuffix)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(name + compressSuffix)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(name + compressSuffix)
+		return err
+	}
+	return os.Remove(name)
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+var (
+	// This is a compile-time check to make sure our types correctly
+	// implement the interface:
+	// https://medium.com/@matryer/c167afed3aae
+	_ ecsevent.Emitter = &Emitter{}
+)
@@ -0,0 +1,229 @@
+package file
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readLines decodes every line of a file, transparently decompressing it.
+func readLines(t *testing.T, name string) []map[string]interface{} {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if strings.HasSuffix(name, compressSuffix) {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner = bufio.NewScanner(zr)
+	}
+	var lines []map[string]interface{}
+	for scanner.Scan() {
+		line := map[string]interface{}{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func listDir(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestEmitter(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "ecsevent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "logs", "ecs.json")
+	emitter, err := New(path, Sync(SyncAlways))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldLogLevel: "info",
+		ecsevent.FieldMessage:  "hello world",
+	})
+	assert.NoError(emitter.Close())
+
+	// Reopening appends to the existing file.
+	emitter, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "goodbye world"})
+	assert.NoError(emitter.Close())
+
+	var errs []error
+	emitter.errorHandler = func(err error) {
+		errs = append(errs, err)
+	}
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "after close"})
+	assert.Equal([]error{ErrClosed}, errs)
+
+	assert.Equal([]map[string]interface{}{
+		{
+			"log":     map[string]interface{}{"level": "info"},
+			"message": "hello world",
+		},
+		{
+			"message": "goodbye world",
+		},
+	}, readLines(t, path))
+}
+
+func TestEmitterSizeRotation(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "ecsevent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ecs.json")
+	line, _ := json.Marshal(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	clock := time.Date(2019, 10, 28, 6, 15, 7, 0, time.UTC)
+	emitter, err := New(
+		path,
+		MaxSize(int64(2*(len(line)+1))),
+		MaxBackups(2),
+		Compress(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitter.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	// Two events per file: three rotations, one backup pruned.
+	for i := 0; i < 7; i++ {
+		emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	}
+	assert.NoError(emitter.Close())
+
+	names := listDir(t, dir)
+	assert.Equal([]string{
+		"ecs-2019-10-28T06-15-10.000.json.gz",
+		"ecs-2019-10-28T06-15-12.000.json.gz",
+		"ecs.json",
+	}, names)
+	for _, name := range names[:2] {
+		assert.Len(readLines(t, filepath.Join(dir, name)), 2)
+	}
+	assert.Len(readLines(t, path), 1)
+}
+
+func TestEmitterBackupOrder(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "ecsevent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ecs.json")
+	line, _ := json.Marshal(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	clock := time.Date(2019, 10, 28, 6, 15, 7, 0, time.UTC)
+	emitter, err := New(path, MaxSize(int64(len(line)+1)), MaxBackups(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitter.now = func() time.Time {
+		return clock
+	}
+	// Every rotation happens within the same millisecond, so backups are
+	// told apart by their suffix alone and the oldest must be pruned first.
+	for i := 0; i < 12; i++ {
+		emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	}
+	assert.NoError(emitter.Close())
+
+	assert.Equal([]string{
+		"ecs-2019-10-28T06-15-07.000-10.json",
+		"ecs-2019-10-28T06-15-07.000-9.json",
+		"ecs.json",
+	}, listDir(t, dir))
+}
+
+func TestEmitterTimeRotation(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "ecsevent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ecs.json")
+	clock := time.Date(2019, 10, 28, 6, 59, 0, 0, time.UTC)
+	emitter, err := New(path, RotateEvery(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitter.now = func() time.Time {
+		return clock
+	}
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "first"})
+	clock = clock.Add(30 * time.Second)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "second"})
+	clock = clock.Add(time.Minute)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "third"})
+	assert.NoError(emitter.Close())
+
+	assert.Equal([]string{"ecs-2019-10-28T07-00-30.000.json", "ecs.json"}, listDir(t, dir))
+	assert.Len(readLines(t, filepath.Join(dir, "ecs-2019-10-28T07-00-30.000.json")), 2)
+	assert.Equal([]map[string]interface{}{{"message": "third"}}, readLines(t, path))
+}
+
+func TestEmitterReopen(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "ecsevent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ecs.json")
+	emitter, err := New(path, SyncEvery(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "before"})
+	// Simulate logrotate moving the file aside.
+	assert.NoError(os.Rename(path, path+".1"))
+	assert.NoError(emitter.Reopen())
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "after"})
+	assert.NoError(emitter.Close())
+
+	assert.Equal([]map[string]interface{}{{"message": "before"}}, readLines(t, path+".1"))
+	assert.Equal([]map[string]interface{}{{"message": "after"}}, readLines(t, path))
+}
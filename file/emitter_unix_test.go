@@ -0,0 +1,44 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmitterSIGHUP(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "ecsevent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ecs.json")
+	emitter, err := New(path, ReopenOnSIGHUP())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emitter.Close()
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "before"})
+	assert.NoError(os.Rename(path, path+".1"))
+	assert.NoError(syscall.Kill(os.Getpid(), syscall.SIGHUP))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !exists(path) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "after"})
+	assert.NoError(emitter.Close())
+	assert.Equal([]map[string]interface{}{{"message": "after"}}, readLines(t, path))
+}
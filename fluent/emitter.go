@@ -0,0 +1,398 @@
+package fluent
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+	"github.com/sporkmonger/ecsevent/internal/batch"
+	"github.com/sporkmonger/ecsevent/internal/interpolate"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+const (
+	defaultAddress       = "localhost:24224"
+	defaultTag           = "ecs"
+	defaultBatchSize     = 100
+	defaultBufferSize    = 10000
+	defaultFlushInterval = time.Second
+	defaultMaxRetries    = 3
+	defaultRetryBackoff  = 250 * time.Millisecond
+	defaultAckTimeout    = 30 * time.Second
+	defaultTimeout       = 10 * time.Second
+)
+
+// ErrAckMismatch is reported when the server acknowledges a chunk other than
+// the one that was sent.
+var ErrAckMismatch = errors.New("fluent server acknowledged an unexpected chunk")
+
+// ErrBufferFull is reported when an event is dropped because the buffer
+// already holds the maximum number of events.
+var ErrBufferFull = errors.New("fluent emitter buffer is full, event dropped")
+
+// Emitter buffers ECS formatted events and ships them to Fluentd or Fluent
+// Bit using the Forward protocol. Events are grouped by tag and sent in
+// PackedForward mode.
+//
+// Events are sent when the batch size is reached, when the flush interval
+// elapses, or when Flush or Close is called.
+type Emitter struct {
+	address       string
+	tlsConfig     *tls.Config
+	tag           string
+	eventTime     bool
+	ack           bool
+	ackTimeout    time.Duration
+	sharedKey     string
+	selfHostname  string
+	username      string
+	password      string
+	timeout       time.Duration
+	batchSize     int
+	bufferSize    int
+	flushInterval time.Duration
+	maxRetries    int
+	retryBackoff  time.Duration
+	errorHandler  func(error)
+
+	batcher *batch.Batcher
+	// connMu gates the connection, which is used by sends and closed by
+	// Close.
+	connMu sync.Mutex
+	conn   net.Conn
+	enc    *msgpack.Encoder
+	dec    *msgpack.Decoder
+}
+
+// entry is a msgpack encoded [time, record] pair awaiting delivery.
+type entry struct {
+	tag  string
+	data []byte
+}
+
+// Option configures an Emitter as it's being initialized.
+type Option func(*Emitter)
+
+// Address sets the 'host:port' of the forward input. Defaults to
+// 'localhost:24224'.
+func Address(address string) Option {
+	return func(e *Emitter) {
+		e.address = address
+	}
+}
+
+// TLSConfig enables TLS using the given configuration.
+func TLSConfig(config *tls.Config) Option {
+	return func(e *Emitter) {
+		e.tlsConfig = config
+	}
+}
+
+// Tag sets the tag template. Field values may be interpolated with
+// `%{field.name}` and the event timestamp with `%{+layout}`, where layout is
+// a Go time layout, e.g. 'app.%{service.name}'. Fields missing from the event
+// interpolate as empty strings. Defaults to 'ecs'.
+func Tag(template string) Option {
+	return func(e *Emitter) {
+		e.tag = template
+	}
+}
+
+// EventTime selects whether timestamps are sent with the EventTime extension,
+// which preserves nanoseconds, or as integer seconds for servers older than
+// Fluentd v0.14. Defaults to true.
+func EventTime(enabled bool) Option {
+	return func(e *Emitter) {
+		e.eventTime = enabled
+	}
+}
+
+// RequireAck requests an acknowledgment for every chunk and waits up to
+// timeout for it. Unacknowledged chunks are resent.
+func RequireAck(timeout time.Duration) Option {
+	return func(e *Emitter) {
+		e.ack = true
+		e.ackTimeout = timeout
+	}
+}
+
+// SharedKey enables the shared key handshake performed on every new
+// connection.
+func SharedKey(key string) Option {
+	return func(e *Emitter) {
+		e.sharedKey = key
+	}
+}
+
+// SelfHostname sets the hostname sent during the handshake. Defaults to the
+// system hostname.
+func SelfHostname(hostname string) Option {
+	return func(e *Emitter) {
+		e.selfHostname = hostname
+	}
+}
+
+// UserAuth sets the credentials sent during the handshake if the server
+// requires user authentication.
+func UserAuth(username, password string) Option {
+	return func(e *Emitter) {
+		e.username = username
+		e.password = password
+	}
+}
+
+// Timeout sets the dial and write timeout.
+func Timeout(timeout time.Duration) Option {
+	return func(e *Emitter) {
+		e.timeout = timeout
+	}
+}
+
+// BatchSize sets the number of buffered events that triggers a send. Values
+// below 1 use the default of 100.
+func BatchSize(size int) Option {
+	return func(e *Emitter) {
+		e.batchSize = size
+	}
+}
+
+// BufferSize sets the maximum number of events held in memory while waiting
+// to be sent. Events emitted while the buffer is full are dropped and
+// reported as ErrBufferFull. Defaults to 10000.
+func BufferSize(size int) Option {
+	return func(e *Emitter) {
+		e.bufferSize = size
+	}
+}
+
+// FlushInterval sets the maximum time an event will remain buffered. Values
+// below 1 use the default of one second.
+func FlushInterval(interval time.Duration) Option {
+	return func(e *Emitter) {
+		e.flushInterval = interval
+	}
+}
+
+// MaxRetries sets how many times a chunk that failed to send or wasn't
+// acknowledged will be retried before its events are dropped.
+func MaxRetries(retries int) Option {
+	return func(e *Emitter) {
+		e.maxRetries = retries
+	}
+}
+
+// RetryBackoff sets the base delay between retries. The delay doubles with
+// each attempt and has jitter applied.
+func RetryBackoff(backoff time.Duration) Option {
+	return func(e *Emitter) {
+		e.retryBackoff = backoff
+	}
+}
+
+// ErrorHandler sets a callback for errors encountered while shipping events,
+// since Emit has no way to return them.
+func ErrorHandler(handler func(error)) Option {
+	return func(e *Emitter) {
+		e.errorHandler = handler
+	}
+}
+
+// New creates a new Emitter with the given Option functions applied and
+// starts its background flush loop. Call Close to stop it.
+func New(opts ...Option) *Emitter {
+	e := &Emitter{
+		address:       defaultAddress,
+		tag:           defaultTag,
+		eventTime:     true,
+		ackTimeout:    defaultAckTimeout,
+		timeout:       defaultTimeout,
+		batchSize:     defaultBatchSize,
+		bufferSize:    defaultBufferSize,
+		flushInterval: defaultFlushInterval,
+		maxRetries:    defaultMaxRetries,
+		retryBackoff:  defaultRetryBackoff,
+		errorHandler:  func(error) {},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.batchSize <= 0 {
+		e.batchSize = defaultBatchSize
+	}
+	if e.flushInterval <= 0 {
+		e.flushInterval = defaultFlushInterval
+	}
+	if e.selfHostname == "" {
+		e.selfHostname, _ = os.Hostname()
+	}
+	e.batcher = batch.New(batch.Config{
+		Send:          e.sendBatch,
+		BatchSize:     e.batchSize,
+		BufferSize:    e.bufferSize,
+		FlushInterval: e.flushInterval,
+	})
+	return e
+}
+
+// Emit takes a map of ECS fields and values and buffers the event for the
+// next send.
+func (e *Emitter) Emit(event map[string]interface{}) {
+	timestamp := ecsevent.Timestamp(event)
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	var t interface{} = timestamp.Unix()
+	if e.eventTime {
+		t = eventTime(timestamp)
+	}
+	buf := &bytes.Buffer{}
+	enc := msgpack.NewEncoder(buf).SortMapKeys(true)
+	if err := enc.Encode([]interface{}{t, normalize(event)}); err != nil {
+		e.errorHandler(err)
+		return
+	}
+	ent := &entry{tag: interpolate.Event(e.tag, event), data: buf.Bytes()}
+	switch e.batcher.Add(ent) {
+	case batch.ErrClosed:
+		e.errorHandler(errors.New("fluent emitter is closed"))
+	case batch.ErrBufferFull:
+		e.errorHandler(ErrBufferFull)
+	}
+}
+
+// Flush synchronously sends all buffered events.
+func (e *Emitter) Flush() {
+	e.batcher.Flush()
+}
+
+// Close flushes any buffered events, stops the background flush loop and
+// closes the connection. Events emitted after Close are dropped.
+func (e *Emitter) Close() error {
+	if !e.batcher.Close() {
+		return nil
+	}
+	e.connMu.Lock()
+	defer e.connMu.Unlock()
+	if e.conn == nil {
+		return nil
+	}
+	err := e.conn.Close()
+	e.conn = nil
+	return err
+}
+
+// sendBatch groups a batch by tag, keeping tags in order of first
+// appearance, and sends each group.
+func (e *Emitter) sendBatch(items []interface{}) {
+	var tags []string
+	groups := map[string][][]byte{}
+	for _, item := range items {
+		ent := item.(*entry)
+		if _, ok := groups[ent.tag]; !ok {
+			tags = append(tags, ent.tag)
+		}
+		groups[ent.tag] = append(groups[ent.tag], ent.data)
+	}
+	for _, tag := range tags {
+		e.send(tag, groups[tag])
+	}
+}
+
+// send delivers the entries for a tag as a single PackedForward message,
+// retrying on a new connection after errors.
+func (e *Emitter) send(tag string, entries [][]byte) {
+	option := map[string]interface{}{"size": len(entries)}
+	chunk := ""
+	if e.ack {
+		chunk = newChunkID()
+		option["chunk"] = chunk
+	}
+	message := []interface{}{tag, bytes.Join(entries, nil), option}
+	e.connMu.Lock()
+	defer e.connMu.Unlock()
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(batch.Backoff(e.retryBackoff, 0, attempt))
+		}
+		err := e.write(message, chunk)
+		if err == nil {
+			return
+		}
+		if e.conn != nil {
+			e.conn.Close()
+			e.conn = nil
+		}
+		if attempt >= e.maxRetries {
+			e.errorHandler(fmt.Errorf("dropped %d events: %v", len(entries), err))
+			return
+		}
+	}
+}
+
+func (e *Emitter) write(message []interface{}, chunk string) error {
+	if err := e.dial(); err != nil {
+		return err
+	}
+	e.conn.SetWriteDeadline(time.Now().Add(e.timeout))
+	if err := e.enc.Encode(message); err != nil {
+		return err
+	}
+	if chunk == "" {
+		return nil
+	}
+	e.conn.SetReadDeadline(time.Now().Add(e.ackTimeout))
+	var response map[string]interface{}
+	if err := e.dec.Decode(&response); err != nil {
+		return err
+	}
+	if ack, _ := asString(response["ack"]); ack != chunk {
+		return ErrAckMismatch
+	}
+	return nil
+}
+
+// dial establishes a connection, performing the handshake if a shared key is
+// configured. It must be called with connMu held.
+func (e *Emitter) dial() error {
+	if e.conn != nil {
+		return nil
+	}
+	dialer := &net.Dialer{Timeout: e.timeout}
+	var conn net.Conn
+	var err error
+	if e.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", e.address, e.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", e.address)
+	}
+	if err != nil {
+		return err
+	}
+	enc := msgpack.NewEncoder(conn)
+	dec := msgpack.NewDecoder(conn)
+	if e.sharedKey != "" {
+		conn.SetDeadline(time.Now().Add(e.timeout))
+		if err := e.handshake(enc, dec); err != nil {
+			conn.Close()
+			return err
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	e.conn = conn
+	e.enc = enc
+	e.dec = dec
+	return nil
+}
+
+var (
+	// This is a compile-time check to make sure our types correctly
+	// implement the interface:
+	// https://medium.com/@matryer/c167afed3aae
+	_ ecsevent.Emitter = &Emitter{}
+)
@@ -0,0 +1,333 @@
+package fluent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+	"github.com/vmihailenco/msgpack/v4"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	msgpack.RegisterExt(0, (*testEventTime)(nil))
+}
+
+// testEventTime decodes the EventTime extension on the receiving side.
+type testEventTime struct {
+	time.Time
+}
+
+func (tm *testEventTime) UnmarshalMsgpack(b []byte) error {
+	if len(b) != 8 {
+		return fmt.Errorf("invalid data length: got %d, wanted 8", len(b))
+	}
+	tm.Time = time.Unix(int64(binary.BigEndian.Uint32(b)), int64(binary.BigEndian.Uint32(b[4:])))
+	return nil
+}
+
+type forwardMessage struct {
+	tag     string
+	times   []interface{}
+	records []map[string]interface{}
+	option  map[string]interface{}
+}
+
+// fakeForward is a minimal in-process Forward protocol input.
+type fakeForward struct {
+	listener  net.Listener
+	sharedKey string
+	// dropAcks is the number of chunks to drop without acknowledging them.
+	dropAcks int
+
+	mu       sync.Mutex
+	messages []forwardMessage
+	wg       sync.WaitGroup
+}
+
+func newFakeForward(t *testing.T, sharedKey string, dropAcks int) *fakeForward {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ff := &fakeForward{listener: listener, sharedKey: sharedKey, dropAcks: dropAcks}
+	ff.wg.Add(1)
+	go func() {
+		defer ff.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			ff.wg.Add(1)
+			go ff.serve(conn)
+		}
+	}()
+	return ff
+}
+
+func (ff *fakeForward) Close() {
+	ff.listener.Close()
+	ff.wg.Wait()
+}
+
+func (ff *fakeForward) serve(conn net.Conn) {
+	defer ff.wg.Done()
+	defer conn.Close()
+	enc := msgpack.NewEncoder(conn)
+	dec := msgpack.NewDecoder(conn)
+	if ff.sharedKey != "" {
+		nonce := "server-nonce"
+		enc.Encode([]interface{}{"HELO", map[string]interface{}{"nonce": nonce, "auth": "", "keepalive": true}})
+		var ping []string
+		if err := dec.Decode(&ping); err != nil || len(ping) != 6 {
+			return
+		}
+		if ping[3] != digest(ping[2], ping[1], nonce, ff.sharedKey) {
+			enc.Encode([]interface{}{"PONG", false, "shared key mismatch", "", ""})
+			return
+		}
+		enc.Encode([]interface{}{"PONG", true, "", "server", digest(ping[2], "server", nonce, ff.sharedKey)})
+	}
+	for {
+		var raw []interface{}
+		if err := dec.Decode(&raw); err != nil {
+			return
+		}
+		message := forwardMessage{tag: raw[0].(string), option: raw[2].(map[string]interface{})}
+		entries := msgpack.NewDecoder(bytes.NewReader(raw[1].([]byte)))
+		for {
+			var pair []interface{}
+			if err := entries.Decode(&pair); err == io.EOF {
+				break
+			} else if err != nil {
+				return
+			}
+			message.times = append(message.times, pair[0])
+			message.records = append(message.records, pair[1].(map[string]interface{}))
+		}
+		ff.mu.Lock()
+		if chunk, ok := message.option["chunk"]; ok {
+			if ff.dropAcks > 0 {
+				ff.dropAcks--
+				ff.mu.Unlock()
+				return
+			}
+			enc.Encode(map[string]interface{}{"ack": chunk})
+		}
+		ff.messages = append(ff.messages, message)
+		ff.mu.Unlock()
+	}
+}
+
+func TestEmitter(t *testing.T) {
+	assert := assert.New(t)
+	ff := newFakeForward(t, "", 0)
+	defer ff.Close()
+
+	timestamp := time.Date(2019, 10, 28, 6, 15, 7, 226113003, time.UTC)
+	emitter := New(Address(ff.listener.Addr().String()), Tag("app.%{service.name}"))
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldTimestamp: timestamp,
+		"service": map[string]interface{}{
+			"name": "checkout",
+		},
+		ecsevent.FieldMessage: "first",
+	})
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldTimestamp:   timestamp,
+		ecsevent.FieldServiceName: "cart",
+		ecsevent.FieldMessage:     "second",
+	})
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldTimestamp:   timestamp,
+		ecsevent.FieldServiceName: "checkout",
+		ecsevent.FieldMessage:     "third",
+	})
+	assert.NoError(emitter.Close())
+	ff.Close()
+
+	if assert.Len(ff.messages, 2) {
+		assert.Equal("app.checkout", ff.messages[0].tag)
+		assert.Equal(map[string]interface{}{"size": int64(2)}, ff.messages[0].option)
+		assert.Equal([]map[string]interface{}{
+			{
+				"@timestamp": "2019-10-28T06:15:07.226113003Z",
+				"service":    map[string]interface{}{"name": "checkout"},
+				"message":    "first",
+			},
+			{
+				"@timestamp":   "2019-10-28T06:15:07.226113003Z",
+				"service.name": "checkout",
+				"message":      "third",
+			},
+		}, ff.messages[0].records)
+		if assert.Len(ff.messages[0].times, 2) {
+			eventTime, ok := ff.messages[0].times[0].(*testEventTime)
+			if assert.True(ok) {
+				assert.True(timestamp.Equal(eventTime.Time))
+			}
+		}
+		assert.Equal("app.cart", ff.messages[1].tag)
+		assert.Len(ff.messages[1].records, 1)
+	}
+}
+
+func TestEmitterSubevents(t *testing.T) {
+	assert := assert.New(t)
+	ff := newFakeForward(t, "", 0)
+	defer ff.Close()
+
+	timestamp := time.Date(2019, 10, 28, 6, 15, 7, 226113003, time.UTC)
+	emitter := New(Address(ff.listener.Addr().String()))
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldTimestamp: timestamp,
+		ecsevent.FieldMessage:   "span",
+		ecsevent.FieldEventSubevents: []map[string]interface{}{
+			{
+				ecsevent.FieldTimestamp: timestamp,
+				ecsevent.FieldMessage:   "subevent",
+				"labels": map[string]time.Time{
+					"deadline": timestamp,
+				},
+			},
+		},
+		"tags": []time.Time{timestamp},
+	})
+	assert.NoError(emitter.Close())
+	ff.Close()
+
+	if assert.Len(ff.messages, 1) && assert.Len(ff.messages[0].records, 1) {
+		assert.Equal(map[string]interface{}{
+			"@timestamp": "2019-10-28T06:15:07.226113003Z",
+			"message":    "span",
+			"event.subevents": []interface{}{
+				map[string]interface{}{
+					"@timestamp": "2019-10-28T06:15:07.226113003Z",
+					"message":    "subevent",
+					"labels": map[string]interface{}{
+						"deadline": "2019-10-28T06:15:07.226113003Z",
+					},
+				},
+			},
+			"tags": []interface{}{"2019-10-28T06:15:07.226113003Z"},
+		}, ff.messages[0].records[0])
+	}
+}
+
+func TestEmitterIntegerTime(t *testing.T) {
+	assert := assert.New(t)
+	ff := newFakeForward(t, "", 0)
+	defer ff.Close()
+
+	emitter := New(Address(ff.listener.Addr().String()), EventTime(false))
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldTimestamp: "2019-10-28T06:15:07.226113003Z",
+	})
+	assert.NoError(emitter.Close())
+	ff.Close()
+
+	if assert.Len(ff.messages, 1) {
+		assert.Equal("ecs", ff.messages[0].tag)
+		assert.Equal([]interface{}{int64(1572243307)}, ff.messages[0].times)
+	}
+}
+
+func TestEmitterBuffer(t *testing.T) {
+	assert := assert.New(t)
+	ff := newFakeForward(t, "", 0)
+	defer ff.Close()
+
+	var errs []error
+	emitter := New(
+		Address(ff.listener.Addr().String()),
+		BufferSize(2),
+		FlushInterval(time.Hour),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	for i := 0; i < 3; i++ {
+		emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	}
+	assert.Equal([]error{ErrBufferFull}, errs)
+	assert.NoError(emitter.Close())
+	ff.Close()
+
+	if assert.Len(ff.messages, 1) {
+		assert.Len(ff.messages[0].times, 2)
+	}
+}
+
+func TestEmitterInvalidBatching(t *testing.T) {
+	assert := assert.New(t)
+	ff := newFakeForward(t, "", 0)
+	defer ff.Close()
+
+	emitter := New(Address(ff.listener.Addr().String()), BatchSize(0), FlushInterval(0))
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+	ff.Close()
+
+	assert.Len(ff.messages, 1)
+}
+
+func TestEmitterAckAndHandshake(t *testing.T) {
+	assert := assert.New(t)
+	ff := newFakeForward(t, "secret", 1)
+	defer ff.Close()
+
+	var errs []error
+	emitter := New(
+		Address(ff.listener.Addr().String()),
+		SharedKey("secret"),
+		SelfHostname("client"),
+		RequireAck(time.Second),
+		RetryBackoff(time.Millisecond),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+	ff.Close()
+
+	assert.Empty(errs)
+	// The first chunk was dropped without an ack, so it must be resent.
+	if assert.Len(ff.messages, 1) {
+		assert.Contains(ff.messages[0].option, "chunk")
+		assert.Equal("hello world", ff.messages[0].records[0]["message"])
+	}
+}
+
+func TestEmitterHandshakeFailure(t *testing.T) {
+	assert := assert.New(t)
+	ff := newFakeForward(t, "secret", 0)
+	defer ff.Close()
+
+	var errs []error
+	emitter := New(
+		Address(ff.listener.Addr().String()),
+		SharedKey("wrong"),
+		MaxRetries(1),
+		RetryBackoff(time.Millisecond),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+	ff.Close()
+
+	assert.Empty(ff.messages)
+	if assert.Len(errs, 1) {
+		assert.Contains(errs[0].Error(), "shared key mismatch")
+	}
+}
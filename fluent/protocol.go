@@ -0,0 +1,173 @@
+package fluent
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// eventTime is the Forward protocol's EventTime extension type, which carries
+// nanosecond precision timestamps.
+type eventTime time.Time
+
+// MarshalMsgpack encodes the timestamp as a fixext8 of type 0 holding the
+// seconds and nanoseconds as big-endian 32-bit integers.
+func (et eventTime) MarshalMsgpack() ([]byte, error) {
+	t := time.Time(et)
+	b := make([]byte, 10)
+	b[0] = 0xd7
+	b[1] = 0x00
+	binary.BigEndian.PutUint32(b[2:], uint32(t.Unix()))
+	binary.BigEndian.PutUint32(b[6:], uint32(t.Nanosecond()))
+	return b, nil
+}
+
+// normalize converts values msgpack would encode as extension types the
+// Forward protocol doesn't understand, such as time.Time, recursively,
+// including within subevents and other slices and maps.
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case map[string]interface{}:
+		record := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			record[key] = normalize(value)
+		}
+		return record
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, value := range v {
+			values[i] = normalize(value)
+		}
+		return values
+	case []map[string]interface{}:
+		values := make([]interface{}, len(v))
+		for i, value := range v {
+			values[i] = normalize(value)
+		}
+		return values
+	case nil, string, []byte, bool, int, int64, float64:
+		return v
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			// Byte slices like net.IP are encoded as bin.
+			return value
+		}
+		values := make([]interface{}, rv.Len())
+		for i := range values {
+			values[i] = normalize(rv.Index(i).Interface())
+		}
+		return values
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return value
+		}
+		record := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			record[iter.Key().String()] = normalize(iter.Value().Interface())
+		}
+		return record
+	default:
+		return value
+	}
+}
+
+// newChunkID generates a unique chunk ID for the ack option.
+func newChunkID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// digest computes the hex encoded SHA-512 of the concatenated parts, as used
+// throughout the handshake.
+func digest(parts ...string) string {
+	h := sha512.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// asString accepts both the str and bin msgpack types, since servers differ
+// in how they encode the handshake's nonce and salt.
+func asString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	default:
+		return "", false
+	}
+}
+
+// handshake performs the shared key authentication: the server sends HELO, the
+// client answers with PING and the server confirms with PONG.
+func (e *Emitter) handshake(enc *msgpack.Encoder, dec *msgpack.Decoder) error {
+	var helo []interface{}
+	if err := dec.Decode(&helo); err != nil {
+		return fmt.Errorf("fluent handshake failed reading HELO: %v", err)
+	}
+	if len(helo) < 2 || helo[0] != "HELO" {
+		return errors.New("fluent handshake failed: expected HELO")
+	}
+	options, ok := helo[1].(map[string]interface{})
+	if !ok {
+		return errors.New("fluent handshake failed: malformed HELO options")
+	}
+	nonce, ok := asString(options["nonce"])
+	if !ok {
+		return errors.New("fluent handshake failed: HELO without nonce")
+	}
+	authSalt, _ := asString(options["auth"])
+
+	saltBytes := make([]byte, 16)
+	rand.Read(saltBytes)
+	salt := hex.EncodeToString(saltBytes)
+	username, password := "", ""
+	if authSalt != "" {
+		username = e.username
+		password = digest(authSalt, e.username, e.password)
+	}
+	ping := []interface{}{
+		"PING",
+		e.selfHostname,
+		salt,
+		digest(salt, e.selfHostname, nonce, e.sharedKey),
+		username,
+		password,
+	}
+	if err := enc.Encode(ping); err != nil {
+		return err
+	}
+
+	var pong []interface{}
+	if err := dec.Decode(&pong); err != nil {
+		return fmt.Errorf("fluent handshake failed reading PONG: %v", err)
+	}
+	if len(pong) < 5 || pong[0] != "PONG" {
+		return errors.New("fluent handshake failed: expected PONG")
+	}
+	if authenticated, _ := pong[1].(bool); !authenticated {
+		return fmt.Errorf("fluent handshake failed: %v", pong[2])
+	}
+	serverHostname, _ := asString(pong[3])
+	if serverDigest, _ := asString(pong[4]); serverDigest != digest(salt, serverHostname, nonce, e.sharedKey) {
+		return errors.New("fluent handshake failed: server digest mismatch")
+	}
+	return nil
+}
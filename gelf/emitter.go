@@ -0,0 +1,357 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+const (
+	defaultChunkSize = 1420
+	maxChunks        = 128
+	chunkHeaderSize  = 12
+)
+
+// Transport selects how GELF messages are delivered.
+type Transport int
+
+const (
+	// UDP sends each message as a datagram, chunked if it exceeds the chunk
+	// size.
+	UDP Transport = iota
+	// TCP sends null-byte delimited messages over a persistent connection.
+	TCP
+	// HTTP posts each message to a GELF HTTP input.
+	HTTP
+)
+
+// Compression selects the compression applied to UDP messages and HTTP
+// request bodies. TCP messages are never compressed, since Graylog's TCP
+// input doesn't support it.
+type Compression int
+
+const (
+	// None disables compression.
+	None Compression = iota
+	// Gzip compresses messages with gzip.
+	Gzip
+	// Zlib compresses messages with zlib. Not supported over HTTP.
+	Zlib
+)
+
+// ErrMessageTooLarge is reported when a UDP message would need more than
+// the 128 chunks GELF allows.
+var ErrMessageTooLarge = errors.New("gelf message requires too many chunks, message dropped")
+
+// Emitter converts ECS formatted events into GELF 1.1 messages and sends
+// them to Graylog or any other GELF receiver.
+type Emitter struct {
+	transport    Transport
+	address      string
+	compression  Compression
+	chunkSize    int
+	host         string
+	client       *http.Client
+	timeout      time.Duration
+	errorHandler func(error)
+
+	// mu gates the connection, since Emit may be called concurrently
+	// from multiple monitors.
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// Option configures an Emitter as it's being initialized.
+type Option func(*Emitter)
+
+// Address sets where messages are sent: 'host:port' for UDP and TCP, or the
+// URL of the input, e.g. 'http://graylog:12201/gelf', for HTTP.
+func Address(address string) Option {
+	return func(e *Emitter) {
+		e.address = address
+	}
+}
+
+// WithTransport selects the transport. Defaults to UDP.
+func WithTransport(transport Transport) Option {
+	return func(e *Emitter) {
+		e.transport = transport
+	}
+}
+
+// WithCompression selects the compression. Defaults to None.
+func WithCompression(compression Compression) Option {
+	return func(e *Emitter) {
+		e.compression = compression
+	}
+}
+
+// ChunkSize sets the maximum UDP datagram size, including the chunk header.
+// Defaults to 1420 bytes, which fits in a typical Ethernet MTU. Sizes too
+// small to hold the 12 byte chunk header and any data fall back to the
+// default.
+func ChunkSize(size int) Option {
+	return func(e *Emitter) {
+		if size <= chunkHeaderSize {
+			size = defaultChunkSize
+		}
+		e.chunkSize = size
+	}
+}
+
+// Host sets the GELF host field for events without a host.hostname.
+// Defaults to the system hostname.
+func Host(host string) Option {
+	return func(e *Emitter) {
+		e.host = host
+	}
+}
+
+// HTTPClient sets the client used by the HTTP transport.
+func HTTPClient(client *http.Client) Option {
+	return func(e *Emitter) {
+		e.client = client
+	}
+}
+
+// Timeout sets the dial and write timeout for the UDP and TCP transports.
+func Timeout(timeout time.Duration) Option {
+	return func(e *Emitter) {
+		e.timeout = timeout
+	}
+}
+
+// ErrorHandler sets a callback for errors encountered while sending
+// messages, since Emit has no way to return them.
+func ErrorHandler(handler func(error)) Option {
+	return func(e *Emitter) {
+		e.errorHandler = handler
+	}
+}
+
+// New creates a new Emitter with the given Option functions applied.
+// Connections are established lazily and re-established after errors.
+func New(opts ...Option) *Emitter {
+	e := &Emitter{
+		chunkSize:    defaultChunkSize,
+		timeout:      10 * time.Second,
+		errorHandler: func(error) {},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.host == "" {
+		e.host, _ = os.Hostname()
+	}
+	if e.address == "" {
+		switch e.transport {
+		case HTTP:
+			e.address = "http://localhost:12201/gelf"
+		default:
+			e.address = "localhost:12201"
+		}
+	}
+	if e.client == nil {
+		e.client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return e
+}
+
+// Emit takes a map of ECS fields and values and sends it as a GELF message.
+func (e *Emitter) Emit(event map[string]interface{}) {
+	data, err := json.Marshal(toGELF(event, e.host))
+	if err != nil {
+		e.errorHandler(err)
+		return
+	}
+	switch e.transport {
+	case TCP:
+		err = e.writeTCP(data)
+	case HTTP:
+		err = e.postHTTP(data)
+	default:
+		err = e.writeUDP(data)
+	}
+	if err != nil {
+		e.errorHandler(err)
+	}
+}
+
+// Close closes the underlying connection, if any.
+func (e *Emitter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		return nil
+	}
+	err := e.conn.Close()
+	e.conn = nil
+	return err
+}
+
+func (e *Emitter) dial(network string) (net.Conn, error) {
+	if e.conn != nil {
+		return e.conn, nil
+	}
+	conn, err := net.DialTimeout(network, e.address, e.timeout)
+	if err != nil {
+		return nil, err
+	}
+	e.conn = conn
+	return conn, nil
+}
+
+func (e *Emitter) writeUDP(data []byte) error {
+	data, err := e.compress(data)
+	if err != nil {
+		return err
+	}
+	chunks, err := chunk(data, e.chunkSize)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	conn, err := e.dial("udp")
+	if err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		conn.SetWriteDeadline(time.Now().Add(e.timeout))
+		if _, err := conn.Write(c); err != nil {
+			conn.Close()
+			e.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Emitter) writeTCP(data []byte) error {
+	if bytes.IndexByte(data, 0) != -1 {
+		// JSON encoding escapes control characters, so this shouldn't
+		// happen, but a null byte would corrupt the stream.
+		return errors.New("gelf message contains a null byte, message dropped")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// Retry once on a fresh connection, since the server may have closed an
+	// idle one.
+	for attempt := 0; ; attempt++ {
+		conn, err := e.dial("tcp")
+		if err != nil {
+			return err
+		}
+		conn.SetWriteDeadline(time.Now().Add(e.timeout))
+		_, err = conn.Write(append(data, 0))
+		if err == nil {
+			return nil
+		}
+		conn.Close()
+		e.conn = nil
+		if attempt > 0 {
+			return err
+		}
+	}
+}
+
+func (e *Emitter) postHTTP(data []byte) error {
+	if e.compression == Zlib {
+		return errors.New("zlib compression is not supported by the gelf http transport")
+	}
+	data, err := e.compress(data)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.address, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.compression == Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("gelf http request failed with status %d: %s",
+			resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (e *Emitter) compress(data []byte) ([]byte, error) {
+	var w io.WriteCloser
+	buf := &bytes.Buffer{}
+	switch e.compression {
+	case Gzip:
+		w = gzip.NewWriter(buf)
+	case Zlib:
+		w = zlib.NewWriter(buf)
+	default:
+		return data, nil
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// chunk splits a message into GELF chunks. Messages that fit in a single
+// datagram are sent as-is.
+func chunk(data []byte, chunkSize int) ([][]byte, error) {
+	if len(data) <= chunkSize {
+		return [][]byte{data}, nil
+	}
+	payloadSize := chunkSize - chunkHeaderSize
+	count := (len(data) + payloadSize - 1) / payloadSize
+	if count > maxChunks {
+		return nil, ErrMessageTooLarge
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * payloadSize
+		if end > len(data) {
+			end = len(data)
+		}
+		c := make([]byte, 0, chunkHeaderSize+end-i*payloadSize)
+		c = append(c, 0x1e, 0x0f)
+		c = append(c, id...)
+		c = append(c, byte(i), byte(count))
+		c = append(c, data[i*payloadSize:end]...)
+		chunks = append(chunks, c)
+	}
+	return chunks, nil
+}
+
+var (
+	// This is a compile-time check to make sure our types correctly
+	// implement the interface:
+	// https://medium.com/@matryer/c167afed3aae
+	_ ecsevent.Emitter = &Emitter{}
+)
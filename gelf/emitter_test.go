@@ -0,0 +1,219 @@
+package gelf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readUDPMessage reads datagrams until a full message is reassembled and
+// decompresses it.
+func readUDPMessage(t *testing.T, conn net.PacketConn) map[string]interface{} {
+	chunks := map[byte][]byte{}
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var data []byte
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		datagram := append([]byte(nil), buf[:n]...)
+		if len(datagram) < 2 || datagram[0] != 0x1e || datagram[1] != 0x0f {
+			data = datagram
+			break
+		}
+		chunks[datagram[10]] = datagram[12:]
+		if len(chunks) == int(datagram[11]) {
+			for i := 0; i < len(chunks); i++ {
+				data = append(data, chunks[byte(i)]...)
+			}
+			break
+		}
+	}
+	var r io.Reader = bytes.NewReader(data)
+	switch {
+	case len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b:
+		r, _ = gzip.NewReader(r)
+	case len(data) > 1 && data[0] == 0x78:
+		r, _ = zlib.NewReader(r)
+	}
+	message := map[string]interface{}{}
+	if err := json.NewDecoder(r).Decode(&message); err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+func TestEmitterUDP(t *testing.T) {
+	tcs := []struct {
+		name        string
+		compression Compression
+		messageSize int
+		chunkSize   int
+	}{
+		{"uncompressed", None, 10, 512},
+		{"uncompressed, chunked", None, 5000, 512},
+		{"gzip, chunked", Gzip, 5000, 512},
+		{"zlib", Zlib, 10, 512},
+		{"invalid chunk size", None, 5000, chunkHeaderSize},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			emitter := New(
+				Address(conn.LocalAddr().String()),
+				WithCompression(tc.compression),
+				ChunkSize(tc.chunkSize),
+				Host("test-host"),
+				ErrorHandler(func(err error) {
+					t.Error(err)
+				}),
+			)
+			defer emitter.Close()
+			// Random-ish content so gzip still needs several chunks.
+			content := make([]byte, tc.messageSize)
+			for i := range content {
+				content[i] = byte('a' + (i*7919)%26)
+			}
+			emitter.Emit(map[string]interface{}{
+				ecsevent.FieldMessage:  string(content),
+				ecsevent.FieldLogLevel: "error",
+			})
+			message := readUDPMessage(t, conn)
+			assert.Equal(string(content), message["short_message"])
+			assert.Equal("test-host", message["host"])
+			assert.Equal(float64(3), message["level"])
+		})
+	}
+}
+
+func TestEmitterTCP(t *testing.T) {
+	assert := assert.New(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	messages := make(chan map[string]interface{}, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					data, err := reader.ReadBytes(0)
+					if err != nil {
+						return
+					}
+					message := map[string]interface{}{}
+					json.Unmarshal(data[:len(data)-1], &message)
+					messages <- message
+				}
+			}(conn)
+		}
+	}()
+
+	emitter := New(
+		WithTransport(TCP),
+		Address(listener.Addr().String()),
+		// Ignored for TCP.
+		WithCompression(Gzip),
+		ErrorHandler(func(err error) {
+			t.Error(err)
+		}),
+	)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "first"})
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "second"})
+	for _, expected := range []string{"first", "second"} {
+		select {
+		case message := <-messages:
+			assert.Equal(expected, message["short_message"])
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for message")
+		}
+	}
+
+	// A dropped connection is re-established.
+	emitter.mu.Lock()
+	emitter.conn.Close()
+	emitter.mu.Unlock()
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "third"})
+	select {
+	case message := <-messages:
+		assert.Equal("third", message["short_message"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	assert.NoError(emitter.Close())
+}
+
+func TestEmitterHTTP(t *testing.T) {
+	assert := assert.New(t)
+	var messages []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			body, _ = gzip.NewReader(r.Body)
+		}
+		data, _ := ioutil.ReadAll(body)
+		message := map[string]interface{}{}
+		if r.URL.Path != "/gelf" || json.Unmarshal(data, &message) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		messages = append(messages, message)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	var errs []error
+	emitter := New(
+		WithTransport(HTTP),
+		Address(server.URL+"/gelf"),
+		WithCompression(Gzip),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.Empty(errs)
+	assert.Len(messages, 1)
+	if len(messages) == 1 {
+		assert.Equal("hello world", messages[0]["short_message"])
+	}
+
+	emitter = New(
+		WithTransport(HTTP),
+		Address(server.URL+"/wrong"),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.Len(errs, 1)
+}
@@ -0,0 +1,115 @@
+package gelf
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+// toGELF converts an ECS event into a GELF 1.1 message. Fields are flattened
+// into additional fields with an underscore prefix and dots replaced by
+// underscores, e.g. http.request.method becomes _http_request_method.
+func toGELF(event map[string]interface{}, host string) map[string]interface{} {
+	flat := ecsevent.Unnest(event)
+	message := map[string]interface{}{
+		"version":       "1.1",
+		"host":          host,
+		"short_message": shortMessage(flat),
+	}
+	if hostname, ok := flat[ecsevent.FieldHostHostname].(string); ok && hostname != "" {
+		message["host"] = hostname
+	}
+	timestamp := ecsevent.Timestamp(flat)
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	// Seconds since the epoch with millisecond precision.
+	message["timestamp"] = float64(timestamp.UnixNano()/int64(time.Millisecond)) / 1000
+	// GELF receivers treat a missing level as alert, so events without a
+	// recognized log.level are sent as informational instead.
+	message["level"] = int(ecsevent.SeverityInformational)
+	if level, ok := flat[ecsevent.FieldLogLevel].(string); ok {
+		if severity, ok := ecsevent.ParseSeverity(level); ok {
+			message["level"] = int(severity)
+		}
+	}
+	if stackTrace, ok := flat[ecsevent.FieldErrorStackTrace].(string); ok {
+		message["full_message"] = stackTrace
+	}
+	for key, value := range flat {
+		switch key {
+		case ecsevent.FieldMessage, ecsevent.FieldTimestamp:
+			// Already mapped onto GELF's own fields.
+			continue
+		}
+		name := additionalFieldName(key)
+		if name == "_id" {
+			// Reserved by GELF.
+			name = "_id_"
+		}
+		message[name] = additionalFieldValue(value)
+	}
+	return message
+}
+
+// shortMessage picks the required short_message, falling back to a summary of
+// HTTP events, since spans recorded by httpmw have no message of their own.
+func shortMessage(flat map[string]interface{}) string {
+	if message, ok := flat[ecsevent.FieldMessage].(string); ok && message != "" {
+		return message
+	}
+	if action, ok := flat[ecsevent.FieldEventAction].(string); ok && action != "" {
+		return action
+	}
+	if method, ok := flat[ecsevent.FieldHTTPRequestMethod].(string); ok {
+		summary := method
+		if path, ok := flat[ecsevent.FieldURLPath].(string); ok {
+			summary += " " + path
+		}
+		if status, ok := flat[ecsevent.FieldHTTPResponseStatusCode]; ok {
+			summary += fmt.Sprintf(" %v", status)
+		}
+		return summary
+	}
+	return "-"
+}
+
+func additionalFieldName(key string) string {
+	b := []byte("_" + key)
+	for i, c := range b {
+		// GELF field names must match ^[\w\.\-]*$, but Graylog stores dots
+		// differently across versions, so they're replaced too.
+		if !(c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// additionalFieldValue coerces a value into a string or number, the only
+// types GELF allows for additional fields.
+func additionalFieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	case bool:
+		return fmt.Sprint(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		return int64(v)
+	case []string:
+		return strings.Join(v, ",")
+	case nil:
+		return ""
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
@@ -0,0 +1,135 @@
+package gelf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToGELF(t *testing.T) {
+	tcs := []struct {
+		name           string
+		input          map[string]interface{}
+		expectedOutput map[string]interface{}
+	}{
+		{
+			"simple message",
+			map[string]interface{}{
+				ecsevent.FieldTimestamp: time.Date(2020, 4, 1, 12, 0, 0, 123456789, time.UTC),
+				ecsevent.FieldLogLevel:  "warn",
+				ecsevent.FieldMessage:   "something happened",
+				ecsevent.FieldTags:      []string{"a", "b"},
+			},
+			map[string]interface{}{
+				"version":       "1.1",
+				"host":          "default-host",
+				"short_message": "something happened",
+				"timestamp":     1585742400.123,
+				"level":         4,
+				"_log_level":    "warn",
+				"_tags":         "a,b",
+			},
+		},
+		{
+			"nested http request",
+			map[string]interface{}{
+				ecsevent.FieldTimestamp: "2020-04-01T12:00:00Z",
+				"http": map[string]interface{}{
+					"request": map[string]interface{}{
+						"method": "GET",
+					},
+					"response": map[string]interface{}{
+						"status_code": 200,
+					},
+				},
+				"url": map[string]interface{}{
+					"path": "/health",
+				},
+				"host": map[string]interface{}{
+					"hostname": "web-1",
+				},
+				"error": map[string]interface{}{
+					"stack_trace": "goroutine 1 [running]:",
+				},
+				"event": map[string]interface{}{
+					"start": time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC),
+				},
+				"related": map[string]interface{}{
+					"ip": []interface{}{"10.0.0.1"},
+				},
+				"id": "reserved",
+			},
+			map[string]interface{}{
+				"version":                    "1.1",
+				"host":                       "web-1",
+				"short_message":              "GET /health 200",
+				"full_message":               "goroutine 1 [running]:",
+				"timestamp":                  1585742400.0,
+				"level":                      6,
+				"_http_request_method":       "GET",
+				"_http_response_status_code": 200,
+				"_url_path":                  "/health",
+				"_host_hostname":             "web-1",
+				"_error_stack_trace":         "goroutine 1 [running]:",
+				"_event_start":               "2020-04-01T12:00:00Z",
+				"_related_ip":                `["10.0.0.1"]`,
+				"_id_":                       "reserved",
+			},
+		},
+		{
+			"unknown level",
+			map[string]interface{}{
+				ecsevent.FieldTimestamp: "2020-04-01T12:00:00Z",
+				ecsevent.FieldLogLevel:  "verbose",
+			},
+			map[string]interface{}{
+				"version":       "1.1",
+				"host":          "default-host",
+				"short_message": "-",
+				"timestamp":     1585742400.0,
+				"level":         6,
+				"_log_level":    "verbose",
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			output := toGELF(tc.input, "default-host")
+			assert.Equal(tc.expectedOutput, output)
+		})
+	}
+}
+
+func TestChunk(t *testing.T) {
+	assert := assert.New(t)
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	chunks, err := chunk(data, 200)
+	assert.NoError(err)
+	assert.Equal([][]byte{data}, chunks)
+
+	chunks, err = chunk(data, 52)
+	assert.NoError(err)
+	assert.Len(chunks, 3)
+	reassembled := []byte{}
+	for i, c := range chunks {
+		assert.Equal([]byte{0x1e, 0x0f}, c[:2])
+		assert.Equal(chunks[0][2:10], c[2:10])
+		assert.Equal(byte(i), c[10])
+		assert.Equal(byte(3), c[11])
+		assert.True(len(c) <= 52)
+		reassembled = append(reassembled, c[12:]...)
+	}
+	assert.Equal(data, reassembled)
+
+	_, err = chunk(make([]byte, 129*10), 22)
+	assert.Equal(ErrMessageTooLarge, err)
+}
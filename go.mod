@@ -3,12 +3,17 @@ module github.com/sporkmonger/ecsevent
 go 1.12
 
 require (
+	github.com/Shopify/sarama v1.26.4
 	github.com/codahale/hdrhistogram v0.0.0-20161010025455-3a0bb77429bd // indirect
+	github.com/golang/snappy v0.0.1
 	github.com/honeycombio/libhoney-go v1.12.4
 	github.com/opentracing/opentracing-go v1.1.0
 	github.com/rs/zerolog v1.18.0
+	github.com/segmentio/kafka-go v0.3.5
+	github.com/sirupsen/logrus v1.5.0
 	github.com/stretchr/testify v1.5.1
 	github.com/uber/jaeger-client-go v2.23.1+incompatible
 	github.com/uber/jaeger-lib v2.2.0+incompatible // indirect
-	go.uber.org/atomic v1.6.0 // indirect
+	github.com/vmihailenco/msgpack/v4 v4.3.11
+	go.uber.org/zap v1.14.1
 )
@@ -1,23 +1,371 @@
 package honeycomb
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
 	libhoney "github.com/honeycombio/libhoney-go"
 
 	"github.com/sporkmonger/ecsevent"
 )
 
+// Honeycomb's trace fields, see https://docs.honeycomb.io/working-with-your-data/tracing/send-trace-data/
+const (
+	fieldTraceID        = "trace.trace_id"
+	fieldTraceSpanID    = "trace.span_id"
+	fieldTraceParentID  = "trace.parent_id"
+	fieldName           = "name"
+	fieldDurationMs     = "duration_ms"
+	fieldAnnotationType = "meta.annotation_type"
+
+	annotationSpanEvent = "span_event"
+)
+
 // Emitter wraps a Beeline allowing ECS formatted events to be emitted.
+//
+// Events recorded by a SpanMonitor are sent as a trace: the span itself and
+// each of its subevents become separate Honeycomb events linked by
+// trace.trace_id, trace.span_id and trace.parent_id, instead of a single
+// event with an event.subevents array. Subevents that are themselves spans,
+// i.e. that have subevents or their own duration, become child spans, while
+// the others become span events. The ECS trace.id and span.id fields are used
+// as IDs when present, otherwise random IDs are generated.
+//
+// Events without subevents, duration or trace.id are sent as flat events.
+//
+// Events already sampled by the monitor carry event.sample_rate, which is
+// sent as the Honeycomb sample rate, multiplied by DynamicSampling's own, so
+// that Honeycomb reweights counts.
 type Emitter struct {
 	Client *libhoney.Client
+
+	spanName       func(map[string]interface{}) string
+	sampler        *dynamicSampler
+	sampleInterval time.Duration
+	datasetFields  []string
+	errorHandler   func(error)
+}
+
+// Option configures an Emitter as it's being initialized.
+type Option func(*Emitter)
+
+// SpanName sets the function deriving each event's name from its flat ECS
+// fields. By default the name is event.action, the HTTP method and
+// url.path, or the message, whichever is found first, except for span
+// events, which prefer the message.
+func SpanName(name func(event map[string]interface{}) string) Option {
+	return func(e *Emitter) {
+		e.spanName = name
+	}
+}
+
+// DynamicSampling keeps on average one in goalRate events, assigning each
+// combination of the values of keyFields, e.g. http.response.status_code and
+// url.path, its own sample rate so that rare combinations like errors are
+// kept more often than common ones. Combinations not seen in the previous
+// interval are always kept. The sample rate is sent with each event so that
+// Honeycomb reweights counts. A trace is sampled as a whole, based on its
+// root span.
+//
+// Sampling replaces the client's own SampleRate.
+func DynamicSampling(goalRate uint, keyFields ...string) Option {
+	return func(e *Emitter) {
+		e.sampler = newDynamicSampler(goalRate, keyFields)
+	}
+}
+
+// SampleInterval sets how often DynamicSampling recomputes its sample rates
+// from the events seen since. Defaults to 30 seconds.
+func SampleInterval(interval time.Duration) Option {
+	return func(e *Emitter) {
+		e.sampleInterval = interval
+	}
+}
+
+// RouteDatasets sends each event to the dataset named by the first of the
+// fields it has, e.g. event.dataset or service.name. Events with none of
+// them go to the client's dataset. A trace is routed as a whole, based on
+// its root span.
+func RouteDatasets(fields ...string) Option {
+	return func(e *Emitter) {
+		e.datasetFields = fields
+	}
+}
+
+// ErrorHandler sets a function to call when an event can't be sent. By
+// default errors are ignored.
+func ErrorHandler(handler func(error)) Option {
+	return func(e *Emitter) {
+		e.errorHandler = handler
+	}
+}
+
+// New creates a new Emitter sending events with the client with the given
+// Option functions applied.
+func New(client *libhoney.Client, opts ...Option) *Emitter {
+	e := &Emitter{
+		Client: client,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.sampler != nil && e.sampleInterval > 0 {
+		e.sampler.interval = e.sampleInterval
+	}
+	return e
 }
 
 // Emit takes a map of ECS fields and values and emits the event into the
 // Beeline.
 func (e *Emitter) Emit(event map[string]interface{}) {
-	unnested := ecsevent.Unnest(event)
+	flat := ecsevent.Unnest(event)
+	r := route{
+		dataset:    e.dataset(flat),
+		sampleRate: sampleRate(flat),
+	}
+	if e.sampler != nil {
+		rate, keep := e.sampler.sample(flat)
+		if !keep {
+			return
+		}
+		if r.sampleRate > 0 {
+			rate *= r.sampleRate
+		}
+		r.sampleRate = rate
+	}
+	if isSpan(flat) {
+		e.sendSpan(r, flat, "", "")
+		return
+	}
+	e.send(r, flat)
+}
+
+// route holds the dataset and sample rate shared by all events of a trace.
+type route struct {
+	dataset string
+	// sampleRate is zero if the Emitter doesn't sample.
+	sampleRate uint
+}
+
+// dataset returns the dataset to route a flat event to, or "" for the
+// client's.
+func (e *Emitter) dataset(flat map[string]interface{}) string {
+	for _, field := range e.datasetFields {
+		if dataset := stringField(flat, field); dataset != "" {
+			return dataset
+		}
+	}
+	return ""
+}
+
+// sendSpan sends a span and, recursively, its subevents.
+func (e *Emitter) sendSpan(r route, flat map[string]interface{}, traceID, parentID string) {
+	subevents := subeventsOf(flat)
+	if traceID == "" {
+		traceID = stringField(flat, ecsevent.FieldTraceID)
+		if traceID == "" {
+			traceID = newID(16)
+		}
+	}
+	// Subevents inherit their span's fields, including span.id, so an ID
+	// equal to the parent's isn't the subevent's own.
+	spanID := stringField(flat, ecsevent.FieldSpanID)
+	if spanID == "" || spanID == parentID {
+		spanID = newID(8)
+	}
+
+	data := make(map[string]interface{}, len(flat)+5)
+	for key, value := range flat {
+		if key != ecsevent.FieldEventSubevents {
+			data[key] = value
+		}
+	}
+	data[fieldTraceID] = traceID
+	data[fieldTraceSpanID] = spanID
+	if parentID != "" {
+		data[fieldTraceParentID] = parentID
+	}
+	data[fieldName] = e.name(flat, false)
+	data[fieldDurationMs] = durationMs(flat)
+	e.send(r, data)
+
+	for _, subevent := range subevents {
+		subFlat := ecsevent.Unnest(subevent)
+		if isChildSpan(subFlat, flat) {
+			e.sendSpan(r, subFlat, traceID, spanID)
+		} else {
+			e.sendSpanEvent(r, subFlat, traceID, spanID)
+		}
+	}
+}
+
+// sendSpanEvent sends a subevent that isn't a span itself.
+func (e *Emitter) sendSpanEvent(r route, flat map[string]interface{}, traceID, parentID string) {
+	data := make(map[string]interface{}, len(flat)+6)
+	for key, value := range flat {
+		data[key] = value
+	}
+	data[fieldTraceID] = traceID
+	data[fieldTraceSpanID] = newID(8)
+	data[fieldTraceParentID] = parentID
+	data[fieldName] = e.name(flat, true)
+	data[fieldDurationMs] = 0.0
+	data[fieldAnnotationType] = annotationSpanEvent
+	e.send(r, data)
+}
+
+func (e *Emitter) send(r route, data map[string]interface{}) {
 	he := e.Client.NewEvent()
-	he.Add(unnested)
-	he.Send()
+	if r.dataset != "" {
+		he.Dataset = r.dataset
+	}
+	if timestamp := ecsevent.Timestamp(data); !timestamp.IsZero() {
+		he.Timestamp = timestamp
+	} else if start, ok := data[ecsevent.FieldEventStart].(time.Time); ok {
+		he.Timestamp = start
+	}
+	he.Add(data)
+	var err error
+	if r.sampleRate > 0 {
+		// The event was already sampled, libhoney only records the rate.
+		he.SampleRate = r.sampleRate
+		err = he.SendPresampled()
+	} else {
+		err = he.Send()
+	}
+	if err != nil && e.errorHandler != nil {
+		e.errorHandler(err)
+	}
+}
+
+// name derives an event's name. Span events inherit their span's fields, so
+// their message is the most specific name they have.
+func (e *Emitter) name(flat map[string]interface{}, spanEvent bool) string {
+	if e.spanName != nil {
+		return e.spanName(flat)
+	}
+	if message := stringField(flat, ecsevent.FieldMessage); spanEvent && message != "" {
+		return message
+	}
+	if action := stringField(flat, ecsevent.FieldEventAction); action != "" {
+		return action
+	}
+	if method := stringField(flat, ecsevent.FieldHTTPRequestMethod); method != "" {
+		if path := stringField(flat, ecsevent.FieldURLPath); path != "" {
+			return method + " " + path
+		}
+		return method
+	}
+	return stringField(flat, ecsevent.FieldMessage)
+}
+
+// isSpan reports whether a flat event should be sent as part of a trace.
+func isSpan(flat map[string]interface{}) bool {
+	if subeventsOrDuration(flat) {
+		return true
+	}
+	_, ok := flat[ecsevent.FieldTraceID]
+	return ok
+}
+
+// isChildSpan reports whether a flat subevent is a span itself. Subevents
+// inherit their span's fields, so only a duration differing from the
+// parent's counts.
+func isChildSpan(flat, parent map[string]interface{}) bool {
+	if len(subeventsOf(flat)) > 0 {
+		return true
+	}
+	duration, ok := flat[ecsevent.FieldEventDuration]
+	return ok && duration != parent[ecsevent.FieldEventDuration]
+}
+
+func subeventsOrDuration(flat map[string]interface{}) bool {
+	if len(subeventsOf(flat)) > 0 {
+		return true
+	}
+	if _, ok := flat[ecsevent.FieldEventDuration]; ok {
+		return true
+	}
+	_, ok := flat[ecsevent.FieldEventStart].(time.Time)
+	return ok
+}
+
+// subeventsOf returns an event's subevents, which are usually a
+// []map[string]interface{} but may have been converted to []interface{}.
+func subeventsOf(flat map[string]interface{}) []map[string]interface{} {
+	switch v := flat[ecsevent.FieldEventSubevents].(type) {
+	case []map[string]interface{}:
+		return v
+	case []interface{}:
+		subevents := make([]map[string]interface{}, 0, len(v))
+		for _, elem := range v {
+			if subevent, ok := elem.(map[string]interface{}); ok {
+				subevents = append(subevents, subevent)
+			}
+		}
+		return subevents
+	default:
+		return nil
+	}
+}
+
+// durationMs returns event.duration, in nanoseconds, or the time between
+// event.start and event.end as fractional milliseconds.
+func durationMs(flat map[string]interface{}) float64 {
+	switch v := flat[ecsevent.FieldEventDuration].(type) {
+	case int:
+		return float64(v) / float64(time.Millisecond)
+	case int64:
+		return float64(v) / float64(time.Millisecond)
+	case float64:
+		return v / float64(time.Millisecond)
+	case time.Duration:
+		return float64(v) / float64(time.Millisecond)
+	}
+	start, ok := flat[ecsevent.FieldEventStart].(time.Time)
+	if !ok {
+		return 0
+	}
+	end, ok := flat[ecsevent.FieldEventEnd].(time.Time)
+	if !ok {
+		return 0
+	}
+	return float64(end.Sub(start)) / float64(time.Millisecond)
+}
+
+// sampleRate returns the rate the monitor sampled an event at, or zero if it
+// wasn't sampled.
+func sampleRate(flat map[string]interface{}) uint {
+	switch v := flat[ecsevent.FieldEventSampleRate].(type) {
+	case int:
+		if v > 0 {
+			return uint(v)
+		}
+	case int64:
+		if v > 0 {
+			return uint(v)
+		}
+	case uint:
+		return v
+	case float64:
+		if v >= 1 {
+			return uint(v)
+		}
+	}
+	return 0
+}
+
+func stringField(flat map[string]interface{}, key string) string {
+	s, _ := flat[key].(string)
+	return s
+}
+
+// newID returns a random hex ID of n bytes.
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
 var (
@@ -2,10 +2,12 @@ package honeycomb
 
 import (
 	"testing"
+	"time"
 
 	"github.com/sporkmonger/ecsevent"
 
 	libhoney "github.com/honeycombio/libhoney-go"
+	"github.com/honeycombio/libhoney-go/transmission"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -49,3 +51,172 @@ func TestEmitter(t *testing.T) {
 		})
 	}
 }
+
+func newMockClient(t *testing.T) (*libhoney.Client, *transmission.MockSender) {
+	sender := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "key",
+		Dataset:      "ecs",
+		Transmission: sender,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, sender
+}
+
+func TestEmitterFlatEvent(t *testing.T) {
+	assert := assert.New(t)
+	client, sender := newMockClient(t)
+	emitter := New(client)
+	timestamp := time.Date(2019, 10, 28, 6, 15, 7, 0, time.UTC)
+	emitter.Emit(map[string]interface{}{
+		"@timestamp": timestamp,
+		"message":    "hello world",
+		"service":    map[string]interface{}{"name": "checkout"},
+	})
+	events := sender.Events()
+	if assert.Len(events, 1) {
+		assert.Equal(timestamp, events[0].Timestamp)
+		assert.Equal(map[string]interface{}{
+			ecsevent.FieldTimestamp:   timestamp,
+			ecsevent.FieldMessage:     "hello world",
+			ecsevent.FieldServiceName: "checkout",
+		}, events[0].Data)
+	}
+}
+
+func TestEmitterSampleRate(t *testing.T) {
+	assert := assert.New(t)
+	client, sender := newMockClient(t)
+	rm := ecsevent.NewRootMonitor(ecsevent.Sampling(&ecsevent.RatioSampler{Rate: 4}))
+	rm.AppendEmitter(New(client))
+	for i := 0; i < 100; i++ {
+		rm.Record(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	}
+	New(client, DynamicSampling(1)).Emit(map[string]interface{}{
+		ecsevent.FieldMessage:         "presampled",
+		ecsevent.FieldEventSampleRate: 3,
+	})
+	New(client).Emit(map[string]interface{}{
+		ecsevent.FieldMessage: "unsampled",
+	})
+
+	events := sender.Events()
+	if assert.True(len(events) > 2) {
+		for _, ev := range events[:len(events)-2] {
+			assert.Equal(uint(4), ev.SampleRate)
+		}
+		assert.Equal(uint(3), events[len(events)-2].SampleRate)
+		assert.Equal(uint(1), events[len(events)-1].SampleRate)
+	}
+}
+
+func TestEmitterTrace(t *testing.T) {
+	assert := assert.New(t)
+	client, sender := newMockClient(t)
+	rm := ecsevent.NewRootMonitor()
+	rm.AppendEmitter(New(client))
+
+	span := ecsevent.NewSpanMonitorFromParent(rm)
+	span.UpdateFields(map[string]interface{}{
+		ecsevent.FieldTraceID:           "0af7651916cd43dd8448eb211c80319c",
+		ecsevent.FieldHTTPRequestMethod: "GET",
+		ecsevent.FieldURLPath:           "/checkout",
+	})
+	span.Record(map[string]interface{}{
+		ecsevent.FieldMessage: "cache miss",
+	})
+	child := ecsevent.NewSpanMonitorFromParent(span)
+	child.UpdateFields(map[string]interface{}{
+		ecsevent.FieldEventAction: "db.query",
+	})
+	child.Record(map[string]interface{}{
+		ecsevent.FieldMessage: "querying",
+	})
+	child.UpdateFields(map[string]interface{}{
+		ecsevent.FieldEventDuration: int(3 * time.Millisecond),
+	})
+	child.Finish()
+	span.UpdateFields(map[string]interface{}{
+		ecsevent.FieldEventDuration: int(12 * time.Millisecond),
+	})
+	span.Finish()
+
+	events := sender.Events()
+	if !assert.Len(events, 4) {
+		return
+	}
+	byName := map[string]map[string]interface{}{}
+	for _, ev := range events {
+		assert.Equal("0af7651916cd43dd8448eb211c80319c", ev.Data["trace.trace_id"])
+		assert.NotContains(ev.Data, ecsevent.FieldEventSubevents)
+		byName[ev.Data["name"].(string)] = ev.Data
+	}
+	root := byName["GET /checkout"]
+	miss := byName["cache miss"]
+	query := byName["db.query"]
+	querying := byName["querying"]
+	if assert.NotNil(root) && assert.NotNil(miss) && assert.NotNil(query) && assert.NotNil(querying) {
+		assert.NotContains(root, "trace.parent_id")
+		assert.Equal(12.0, root["duration_ms"])
+		assert.Equal(root["trace.span_id"], miss["trace.parent_id"])
+		assert.Equal("span_event", miss["meta.annotation_type"])
+		assert.Equal(root["trace.span_id"], query["trace.parent_id"])
+		assert.Equal(3.0, query["duration_ms"])
+		assert.NotContains(query, "meta.annotation_type")
+		assert.Equal(query["trace.span_id"], querying["trace.parent_id"])
+		assert.Equal("span_event", querying["meta.annotation_type"])
+		assert.Len(root["trace.span_id"], 16)
+	}
+}
+
+func TestEmitterSamplingAndRouting(t *testing.T) {
+	assert := assert.New(t)
+	client, sender := newMockClient(t)
+	now := time.Date(2019, 10, 28, 6, 15, 7, 0, time.UTC)
+	emitter := New(client,
+		DynamicSampling(10, ecsevent.FieldHTTPResponseStatusCode),
+		SampleInterval(time.Minute),
+		RouteDatasets(ecsevent.FieldEventDataset, ecsevent.FieldServiceName),
+	)
+	emitter.sampler.now = func() time.Time { return now }
+	emitter.sampler.intn = func(n int) int { return 0 }
+	assert.Equal(time.Minute, emitter.sampler.interval)
+
+	event := func(status int) map[string]interface{} {
+		return map[string]interface{}{
+			ecsevent.FieldServiceName:            "checkout",
+			ecsevent.FieldHTTPResponseStatusCode: status,
+			ecsevent.FieldEventDuration:          int(time.Millisecond),
+			ecsevent.FieldEventSubevents: []map[string]interface{}{
+				{ecsevent.FieldMessage: "subevent", ecsevent.FieldEventDataset: "ignored"},
+			},
+		}
+	}
+	for i := 0; i < 100; i++ {
+		emitter.Emit(event(200))
+	}
+	now = now.Add(time.Minute)
+	emitter.Emit(event(200))
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldEventDataset:           "audit",
+		ecsevent.FieldServiceName:            "checkout",
+		ecsevent.FieldHTTPResponseStatusCode: 500,
+	})
+
+	events := sender.Events()
+	if assert.Len(events, 203) {
+		for _, ev := range events[:200] {
+			assert.Equal(uint(1), ev.SampleRate)
+			assert.Equal("checkout", ev.Dataset)
+		}
+		// The whole trace shares its root span's rate and dataset.
+		for _, ev := range events[200:202] {
+			assert.Equal(uint(10), ev.SampleRate)
+			assert.Equal("checkout", ev.Dataset)
+		}
+		assert.Equal(uint(1), events[202].SampleRate)
+		assert.Equal("audit", events[202].Dataset)
+	}
+}
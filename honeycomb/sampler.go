@@ -0,0 +1,129 @@
+package honeycomb
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultSampleInterval is how often sample rates are recomputed.
+const defaultSampleInterval = 30 * time.Second
+
+// dynamicSampler assigns sample rates per key so that, on average, one in
+// goalRate events is kept while rare keys are kept more often than common
+// ones. Rates are recomputed from each interval's counts using the same
+// logarithmic allocation as Honeycomb's dynsampler-go AvgSampleRate. Keys
+// not seen in the previous interval are always kept.
+type dynamicSampler struct {
+	goalRate  uint
+	keyFields []string
+	interval  time.Duration
+
+	mu         sync.Mutex
+	counts     map[string]int
+	rates      map[string]uint
+	lastUpdate time.Time
+	now        func() time.Time
+	intn       func(int) int
+}
+
+func newDynamicSampler(goalRate uint, keyFields []string) *dynamicSampler {
+	if goalRate < 1 {
+		goalRate = 1
+	}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &dynamicSampler{
+		goalRate:  goalRate,
+		keyFields: keyFields,
+		interval:  defaultSampleInterval,
+		counts:    map[string]int{},
+		rates:     map[string]uint{},
+		now:       time.Now,
+		intn:      rng.Intn,
+	}
+}
+
+// key joins the values of the key fields in a flat event.
+func (s *dynamicSampler) key(flat map[string]interface{}) string {
+	values := make([]string, len(s.keyFields))
+	for i, field := range s.keyFields {
+		if value, ok := flat[field]; ok {
+			values[i] = fmt.Sprint(value)
+		}
+	}
+	return strings.Join(values, "\x1f")
+}
+
+// sample returns the event's sample rate and whether it should be kept.
+func (s *dynamicSampler) sample(flat map[string]interface{}) (uint, bool) {
+	key := s.key(flat)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if s.lastUpdate.IsZero() {
+		s.lastUpdate = now
+	} else if now.Sub(s.lastUpdate) >= s.interval {
+		s.updateRates()
+		s.lastUpdate = now
+	}
+	s.counts[key]++
+	rate, ok := s.rates[key]
+	if !ok || rate <= 1 {
+		return 1, true
+	}
+	return rate, s.intn(int(rate)) == 0
+}
+
+// updateRates computes the next interval's rates from the current counts.
+func (s *dynamicSampler) updateRates() {
+	counts := s.counts
+	s.counts = map[string]int{}
+	rates := make(map[string]uint, len(counts))
+	s.rates = rates
+	if len(counts) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(counts))
+	total := 0
+	logSum := 0.0
+	for key, count := range counts {
+		keys = append(keys, key)
+		total += count
+		logSum += math.Log10(float64(count))
+	}
+	// Handle the rarest keys first so that their unused share of the goal
+	// can be passed on to more common keys.
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] < counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	goalCount := float64(total) / float64(s.goalRate)
+	goalRatio := goalCount / logSum
+	extra := 0.0
+	remaining := len(keys)
+	for _, key := range keys {
+		count := float64(counts[key])
+		goalForKey := math.Max(1, math.Log10(count)*goalRatio)
+		extraForKey := extra / float64(remaining)
+		goalForKey += extraForKey
+		extra -= extraForKey
+		remaining--
+		if count <= goalForKey || math.IsInf(goalForKey, 0) || math.IsNaN(goalForKey) {
+			rates[key] = 1
+			if !math.IsInf(goalForKey, 0) && !math.IsNaN(goalForKey) {
+				extra += goalForKey - count
+			}
+			continue
+		}
+		rate := math.Ceil(count / goalForKey)
+		rates[key] = uint(rate)
+		extra += goalForKey - count/rate
+	}
+}
@@ -0,0 +1,65 @@
+package honeycomb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+func TestDynamicSampler(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2019, 10, 28, 6, 15, 7, 0, time.UTC)
+	s := newDynamicSampler(10, []string{ecsevent.FieldHTTPResponseStatusCode, ecsevent.FieldURLPath})
+	s.now = func() time.Time { return now }
+	s.intn = func(n int) int { return 0 }
+
+	ok := map[string]interface{}{ecsevent.FieldHTTPResponseStatusCode: 200, ecsevent.FieldURLPath: "/"}
+	failed := map[string]interface{}{ecsevent.FieldHTTPResponseStatusCode: 500, ecsevent.FieldURLPath: "/"}
+
+	// Everything is kept until rates are first computed.
+	for i := 0; i < 1000; i++ {
+		rate, keep := s.sample(ok)
+		assert.Equal(uint(1), rate)
+		assert.True(keep)
+	}
+	for i := 0; i < 5; i++ {
+		s.sample(failed)
+	}
+
+	now = now.Add(s.interval)
+	okRate, _ := s.sample(ok)
+	failedRate, _ := s.sample(failed)
+	assert.True(okRate > 10, "common key rate %d", okRate)
+	assert.Equal(uint(1), failedRate)
+	unseenRate, keep := s.sample(map[string]interface{}{ecsevent.FieldHTTPResponseStatusCode: 404})
+	assert.Equal(uint(1), unseenRate)
+	assert.True(keep)
+
+	// Dropped events are decided by the random source.
+	s.intn = func(n int) int { return 1 }
+	_, keep = s.sample(ok)
+	assert.False(keep)
+	_, keep = s.sample(failed)
+	assert.True(keep)
+}
+
+func TestDynamicSamplerSingleKey(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2019, 10, 28, 6, 15, 7, 0, time.UTC)
+	s := newDynamicSampler(4, []string{ecsevent.FieldURLPath})
+	s.now = func() time.Time { return now }
+	event := map[string]interface{}{ecsevent.FieldURLPath: "/"}
+	for i := 0; i < 100; i++ {
+		s.sample(event)
+	}
+	s.sample(map[string]interface{}{ecsevent.FieldURLPath: "/once"})
+	now = now.Add(s.interval)
+	s.intn = func(n int) int { return 0 }
+	rate, _ := s.sample(event)
+	assert.Equal(uint(4), rate)
+	rate, _ = s.sample(map[string]interface{}{ecsevent.FieldURLPath: "/once"})
+	assert.Equal(uint(1), rate)
+}
@@ -2,45 +2,331 @@ package humio
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
-	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/sporkmonger/ecsevent"
+	"github.com/sporkmonger/ecsevent/internal/batch"
 )
 
-// Emitter wraps an HTTP client allowing ECS formatted events to be
-// emitted to Humio.
+const (
+	defaultServer         = "https://cloud.humio.com"
+	defaultBatchSize      = 500
+	defaultBufferSize     = 10000
+	defaultFlushInterval  = 2 * time.Second
+	defaultMaxRetries     = 5
+	defaultRetryBackoff   = 250 * time.Millisecond
+	maxRetryBackoff       = 30 * time.Second
+	defaultTagCardinality = 100
+)
+
+// Endpoint selects which of Humio's ingest APIs events are sent to.
+type Endpoint int
+
+const (
+	// Structured sends events to the humio-structured endpoint with the ECS
+	// fields as attributes.
+	Structured Endpoint = iota
+	// Unstructured sends events to the humio-unstructured endpoint as JSON
+	// encoded messages, to be handled by the ingest token's parser.
+	Unstructured
+	// HEC sends events to Humio's Splunk HTTP Event Collector compatible
+	// endpoint.
+	HEC
+)
+
+func (ep Endpoint) path() string {
+	switch ep {
+	case Unstructured:
+		return "/api/v1/ingest/humio-unstructured"
+	case HEC:
+		return "/api/v1/ingest/hec"
+	default:
+		return "/api/v1/ingest/humio-structured"
+	}
+}
+
+// ErrBufferFull is reported when an event is dropped because the buffer
+// already holds the maximum number of events.
+var ErrBufferFull = errors.New("humio emitter buffer is full, event dropped")
+
+// highCardinalityFields are ECS fields that are unique or nearly unique per
+// event and must never be used as tags, since every distinct tag set
+// creates a new datasource in Humio.
+var highCardinalityFields = map[string]bool{
+	ecsevent.FieldTimestamp:             true,
+	ecsevent.FieldMessage:               true,
+	ecsevent.FieldClientIP:              true,
+	ecsevent.FieldClientPort:            true,
+	ecsevent.FieldDestinationIP:         true,
+	ecsevent.FieldErrorID:               true,
+	ecsevent.FieldErrorMessage:          true,
+	ecsevent.FieldErrorStackTrace:       true,
+	ecsevent.FieldEventCreated:          true,
+	ecsevent.FieldEventDuration:         true,
+	ecsevent.FieldEventEnd:              true,
+	ecsevent.FieldEventHash:             true,
+	ecsevent.FieldEventOriginal:         true,
+	ecsevent.FieldEventStart:            true,
+	ecsevent.FieldEventSubevents:        true,
+	ecsevent.FieldHTTPRequestBodyBytes:  true,
+	ecsevent.FieldHTTPResponseBodyBytes: true,
+	ecsevent.FieldLogOriginal:           true,
+	ecsevent.FieldProcessPID:            true,
+	ecsevent.FieldProcessThreadID:       true,
+	ecsevent.FieldRelatedIP:             true,
+	ecsevent.FieldSourceIP:              true,
+	ecsevent.FieldSourcePort:            true,
+	ecsevent.FieldURLFull:               true,
+	ecsevent.FieldURLOriginal:           true,
+	ecsevent.FieldURLPath:               true,
+	ecsevent.FieldURLQuery:              true,
+	ecsevent.FieldUserAgentOriginal:     true,
+	ecsevent.FieldUserEmail:             true,
+	ecsevent.FieldUserHash:              true,
+	ecsevent.FieldUserID:                true,
+	ecsevent.FieldUserName:              true,
+}
+
+// Emitter buffers ECS formatted events and ships them to Humio in batches.
+//
+// Events are sent when the batch size is reached, when the flush interval
+// elapses, or when Flush or Close is called.
+//
+// Emitters should be created with New. A zero value Emitter, optionally with
+// its deprecated fields set, still works: it is initialized with the default
+// options on its first Emit and must be closed like any other.
 type Emitter struct {
-	// Server is the origin to ship logs to. No trailing slash, typically.
-	// Defaults to 'https://cloud.humio.com'.
+	// Server is the origin to ship logs to.
+	//
+	// Deprecated: Use New with the Server option instead. Only read when a
+	// zero value Emitter is initialized.
 	Server string
 	// IngestToken is the required API key to send events to Humio.
+	//
+	// Deprecated: Use New with the IngestToken option instead. Only read
+	// when a zero value Emitter is initialized.
 	IngestToken string
 	// Tags are optional key-value pairs associated with events.
-	// They must be low-cardinality.
-	Tags     map[string]string
-	client   *http.Client
-	endpoint *url.URL
+	//
+	// Deprecated: Use New with the Tags option instead. Only read when a
+	// zero value Emitter is initialized.
+	Tags map[string]string
+
+	// init starts the background flush loop, once.
+	init sync.Once
+
+	server        string
+	ingestToken   string
+	tags          map[string]string
+	tagFields     map[string]string
+	maxTagValues  int
+	endpoint      Endpoint
+	compress      bool
+	client        *http.Client
+	batchSize     int
+	bufferSize    int
+	flushInterval time.Duration
+	maxRetries    int
+	retryBackoff  time.Duration
+	errorHandler  func(error)
+
+	// mu gates the tag fields and the values seen for them.
+	mu sync.Mutex
+	// tagValues tracks the distinct values seen for each tag field.
+	tagValues map[string]map[string]struct{}
+	batcher   *batch.Batcher
 }
 
-type ingestEvent struct {
-	Timestamp  time.Time              `json:"timestamp"`
-	Attributes map[string]interface{} `json:"attributes"`
+// Option configures an Emitter as it's being initialized.
+type Option func(*Emitter)
+
+// Server sets the origin to ship logs to. No trailing slash, typically.
+// Defaults to 'https://cloud.humio.com'.
+func Server(server string) Option {
+	return func(e *Emitter) {
+		e.server = strings.TrimSuffix(server, "/")
+	}
 }
 
-type ingestRequest struct {
-	Tags   map[string]string `json:"tags"`
-	Events []ingestEvent     `json:"events"`
+// IngestToken sets the required API key to send events to Humio.
+func IngestToken(token string) Option {
+	return func(e *Emitter) {
+		e.ingestToken = token
+	}
 }
 
-// Emit takes a map of ECS fields and values and emits the event into the
-// Beeline.
-func (e *Emitter) Emit(event map[string]interface{}) {
-	// TODO: maybe a constructor?
-	// Quick and dirty for now.
+// Tags sets optional key-value pairs associated with all events.
+// They must be low-cardinality.
+func Tags(tags map[string]string) Option {
+	return func(e *Emitter) {
+		e.tags = tags
+	}
+}
+
+// TagFields lifts ECS fields into per-event tags, e.g.
+// {"service.name": "service"}. Keys are ECS field names and values are tag
+// names; an empty tag name uses the field name. Events with identical tag
+// sets are grouped into the same ingest request. Dynamic tags take
+// precedence over static ones.
+//
+// Fields known to be high-cardinality, like url.path or user.id, are
+// refused and reported to the ErrorHandler.
+func TagFields(fields map[string]string) Option {
+	return func(e *Emitter) {
+		// Copied, since fields that turn out to be high-cardinality are
+		// removed as the emitter runs.
+		e.tagFields = make(map[string]string, len(fields))
+		for field, tag := range fields {
+			e.tagFields[field] = tag
+		}
+	}
+}
+
+// MaxTagCardinality sets how many distinct values a tag field may take before
+// the emitter stops using it as a tag. Defaults to 100.
+func MaxTagCardinality(max int) Option {
+	return func(e *Emitter) {
+		e.maxTagValues = max
+	}
+}
+
+// IngestEndpoint selects the ingest API to use. Defaults to Structured.
+func IngestEndpoint(endpoint Endpoint) Option {
+	return func(e *Emitter) {
+		e.endpoint = endpoint
+	}
+}
+
+// Compression controls whether request bodies are gzipped. Defaults to true.
+func Compression(compress bool) Option {
+	return func(e *Emitter) {
+		e.compress = compress
+	}
+}
+
+// HTTPClient sets the client used to send ingest requests.
+func HTTPClient(client *http.Client) Option {
+	return func(e *Emitter) {
+		e.client = client
+	}
+}
+
+// BatchSize sets the number of buffered events that triggers an ingest
+// request. Values below 1 use the default of 500.
+func BatchSize(size int) Option {
+	return func(e *Emitter) {
+		e.batchSize = size
+	}
+}
+
+// BufferSize sets the maximum number of events held in memory while waiting
+// to be sent. Events emitted while the buffer is full are dropped and
+// reported as ErrBufferFull.
+func BufferSize(size int) Option {
+	return func(e *Emitter) {
+		e.bufferSize = size
+	}
+}
+
+// FlushInterval sets the maximum time an event will remain buffered. Values
+// below 1 use the default of 2 seconds.
+func FlushInterval(interval time.Duration) Option {
+	return func(e *Emitter) {
+		e.flushInterval = interval
+	}
+}
+
+// MaxRetries sets how many times a request that failed with a 429 or 5xx
+// status or a network error will be retried before its events are dropped.
+func MaxRetries(retries int) Option {
+	return func(e *Emitter) {
+		e.maxRetries = retries
+	}
+}
+
+// RetryBackoff sets the base delay between retries. The delay doubles with
+// each attempt, up to 30 seconds, and has jitter applied.
+func RetryBackoff(backoff time.Duration) Option {
+	return func(e *Emitter) {
+		e.retryBackoff = backoff
+	}
+}
+
+// ErrorHandler sets a callback for errors encountered while shipping events,
+// since Emit has no way to return them.
+func ErrorHandler(handler func(error)) Option {
+	return func(e *Emitter) {
+		e.errorHandler = handler
+	}
+}
+
+// New creates a new Emitter with the given Option functions applied and
+// starts its background flush loop. Call Close to stop it.
+func New(opts ...Option) *Emitter {
+	e := &Emitter{}
+	e.setDefaults()
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.init.Do(e.start)
+	return e
+}
+
+// lazyInit initializes a zero value Emitter from its deprecated fields. It
+// does nothing for an Emitter created by New.
+func (e *Emitter) lazyInit() {
+	e.init.Do(func() {
+		e.setDefaults()
+		if e.Server != "" {
+			e.server = strings.TrimSuffix(e.Server, "/")
+		}
+		e.ingestToken = e.IngestToken
+		if e.Tags != nil {
+			e.tags = e.Tags
+		}
+		e.start()
+	})
+}
+
+func (e *Emitter) setDefaults() {
+	e.server = defaultServer
+	e.tags = make(map[string]string)
+	e.maxTagValues = defaultTagCardinality
+	e.tagValues = make(map[string]map[string]struct{})
+	e.compress = true
+	e.batchSize = defaultBatchSize
+	e.bufferSize = defaultBufferSize
+	e.flushInterval = defaultFlushInterval
+	e.maxRetries = defaultMaxRetries
+	e.retryBackoff = defaultRetryBackoff
+	e.errorHandler = func(error) {}
+}
+
+// start validates the configuration and starts the background flush loop.
+func (e *Emitter) start() {
+	if e.batchSize <= 0 {
+		e.batchSize = defaultBatchSize
+	}
+	if e.flushInterval <= 0 {
+		e.flushInterval = defaultFlushInterval
+	}
+	for field := range e.tagFields {
+		if highCardinalityFields[field] {
+			e.errorHandler(fmt.Errorf("refusing to tag on high-cardinality field '%s'", field))
+			delete(e.tagFields, field)
+		}
+	}
 	if e.client == nil {
 		e.client = &http.Client{
 			Transport: &http.Transport{
@@ -50,54 +336,283 @@ func (e *Emitter) Emit(event map[string]interface{}) {
 				}).DialContext,
 				TLSHandshakeTimeout:   10 * time.Second,
 				ExpectContinueTimeout: 4 * time.Second,
-				ResponseHeaderTimeout: 3 * time.Second,
+				ResponseHeaderTimeout: 10 * time.Second,
 			},
 			Timeout: 2 * time.Minute,
 		}
 	}
-	if e.Server == "" {
-		e.Server = "https://cloud.humio.com"
+	e.batcher = batch.New(batch.Config{
+		Send:          e.send,
+		BatchSize:     e.batchSize,
+		BufferSize:    e.bufferSize,
+		FlushInterval: e.flushInterval,
+	})
+}
+
+type ingestEvent struct {
+	Timestamp  time.Time       `json:"timestamp"`
+	Attributes json.RawMessage `json:"attributes"`
+	tags       map[string]string
+}
+
+type ingestRequest struct {
+	Tags   map[string]string `json:"tags"`
+	Events []*ingestEvent    `json:"events"`
+}
+
+type unstructuredRequest struct {
+	Fields   map[string]string `json:"fields"`
+	Messages []string          `json:"messages"`
+}
+
+type hecEvent struct {
+	Time   float64           `json:"time"`
+	Event  json.RawMessage   `json:"event"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// Emit takes a map of ECS fields and values and buffers the event for the
+// next ingest request.
+func (e *Emitter) Emit(event map[string]interface{}) {
+	e.lazyInit()
+	// Humio seems to recommend against nesting, but supports it. Events are
+	// sent in whatever shape the monitor produced.
+	attributes, err := json.Marshal(event)
+	if err != nil {
+		e.errorHandler(err)
+		return
+	}
+	timestamp := ecsevent.Timestamp(event)
+	if timestamp.IsZero() {
+		timestamp = time.Now()
 	}
-	if e.endpoint == nil {
-		e.endpoint, _ = url.Parse(e.Server + "/api/v1/ingest/humio-structured")
+	ie := &ingestEvent{
+		Timestamp:  timestamp,
+		Attributes: attributes,
 	}
-	if e.Tags == nil {
-		e.Tags = make(map[string]string)
+	e.mu.Lock()
+	ie.tags = e.eventTags(event)
+	e.mu.Unlock()
+	switch e.batcher.Add(ie) {
+	case batch.ErrClosed:
+		e.errorHandler(errors.New("humio emitter is closed"))
+	case batch.ErrBufferFull:
+		e.errorHandler(ErrBufferFull)
 	}
-	// Humio seems to recommend against nesting, but supports it. Nest for now,
-	// maybe make this configurable.
-	// unnested := ecsevent.Unnest(event)
-	timestamp, ok := event[ecsevent.FieldTimestamp].(time.Time)
-	if !ok {
-		timestampString, ok := event[ecsevent.FieldTimestamp].(string)
-		if ok {
-			timestamp, _ = time.Parse(time.RFC3339Nano, timestampString)
-		}
+}
+
+// Flush synchronously sends all buffered events.
+func (e *Emitter) Flush() {
+	e.lazyInit()
+	e.batcher.Flush()
+}
+
+// Close flushes any buffered events and stops the background flush loop.
+// Events emitted after Close are dropped.
+func (e *Emitter) Close() error {
+	e.lazyInit()
+	e.batcher.Close()
+	return nil
+}
+
+// send delivers a batch, retrying on network errors and retryable statuses.
+func (e *Emitter) send(items []interface{}) {
+	events := make([]*ingestEvent, len(items))
+	for i, item := range items {
+		events[i] = item.(*ingestEvent)
 	}
-	if timestamp.IsZero() {
-		timestamp = time.Now()
+	body, err := e.encode(events)
+	if err != nil {
+		e.errorHandler(err)
+		return
 	}
-	ir := []ingestRequest{
-		ingestRequest{
-			Tags: e.Tags,
-			Events: []ingestEvent{
-				ingestEvent{
-					Timestamp:  timestamp,
-					Attributes: event,
-				},
-			},
-		},
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(e.backoff(attempt))
+		}
+		retry, err := e.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= e.maxRetries {
+			e.errorHandler(fmt.Errorf("dropped %d events: %v", len(events), err))
+			return
+		}
+	}
+}
+
+// post sends a single ingest request. It reports whether a failed request
+// should be retried.
+func (e *Emitter) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, e.server+e.endpoint.path(), bytes.NewReader(body))
+	if err != nil {
+		return false, err
 	}
-	data, _ := json.Marshal(ir)
-	b := bytes.NewBuffer(data)
-	req, _ := http.NewRequest(http.MethodPost, e.endpoint.String(), b)
-	// TODO: Emit _really_ needs to return an error
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+e.IngestToken)
+	req.Header.Set("Authorization", "Bearer "+e.ingestToken)
+	if e.compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
 	resp, err := e.client.Do(req)
-	if err == nil && resp != nil {
-		defer resp.Body.Close()
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	err = fmt.Errorf("humio ingest request failed with status %d: %s",
+		resp.StatusCode, strings.TrimSpace(string(data)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// encode serializes a batch in the format expected by the configured
+// endpoint, compressing it if needed.
+func (e *Emitter) encode(batch []*ingestEvent) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	var w io.Writer = buf
+	var zw *gzip.Writer
+	if e.compress {
+		zw = gzip.NewWriter(buf)
+		w = zw
+	}
+	var err error
+	switch e.endpoint {
+	case Unstructured:
+		urs := []unstructuredRequest{}
+		for _, group := range groupByTags(batch) {
+			messages := make([]string, 0, len(group))
+			for _, ie := range group {
+				messages = append(messages, string(ie.Attributes))
+			}
+			urs = append(urs, unstructuredRequest{Fields: group[0].tags, Messages: messages})
+		}
+		err = json.NewEncoder(w).Encode(urs)
+	case HEC:
+		// HEC takes concatenated events rather than an array.
+		encoder := json.NewEncoder(w)
+		for _, ie := range batch {
+			he := &hecEvent{
+				Time:   float64(ie.Timestamp.UnixNano()) / float64(time.Second),
+				Event:  ie.Attributes,
+				Fields: ie.tags,
+			}
+			if err = encoder.Encode(he); err != nil {
+				break
+			}
+		}
+	default:
+		irs := []ingestRequest{}
+		for _, group := range groupByTags(batch) {
+			irs = append(irs, ingestRequest{Tags: group[0].tags, Events: group})
+		}
+		err = json.NewEncoder(w).Encode(irs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// backoff returns an exponential delay with up to 50% jitter.
+func (e *Emitter) backoff(attempt int) time.Duration {
+	return batch.Backoff(e.retryBackoff, maxRetryBackoff, attempt)
+}
+
+// eventTags computes the tag set for an event, merging the static tags with
+// any fields lifted from the event. Must be called with mu held.
+func (e *Emitter) eventTags(event map[string]interface{}) map[string]string {
+	if len(e.tagFields) == 0 {
+		return e.tags
+	}
+	var tags map[string]string
+	for field, tag := range e.tagFields {
+		value, ok := ecsevent.Lookup(event, field)
+		if !ok {
+			continue
+		}
+		var s string
+		switch v := value.(type) {
+		case string:
+			s = v
+		case bool, int, int32, int64, uint, uint32, uint64:
+			s = fmt.Sprint(v)
+		default:
+			// Lists, maps and the like don't make meaningful tags.
+			continue
+		}
+		values := e.tagValues[field]
+		if values == nil {
+			values = make(map[string]struct{})
+			e.tagValues[field] = values
+		}
+		if _, seen := values[s]; !seen {
+			if len(values) >= e.maxTagValues {
+				e.errorHandler(fmt.Errorf(
+					"field '%s' exceeded %d distinct tag values, no longer tagging on it",
+					field, e.maxTagValues))
+				delete(e.tagFields, field)
+				continue
+			}
+			values[s] = struct{}{}
+		}
+		if tags == nil {
+			tags = make(map[string]string, len(e.tags)+len(e.tagFields))
+			for k, v := range e.tags {
+				tags[k] = v
+			}
+		}
+		if tag == "" {
+			tag = field
+		}
+		tags[tag] = s
+	}
+	if tags == nil {
+		return e.tags
+	}
+	return tags
+}
+
+// groupByTags splits a batch into groups of events with identical tag sets,
+// preserving the order in which each tag set was first seen.
+func groupByTags(batch []*ingestEvent) [][]*ingestEvent {
+	groups := [][]*ingestEvent{}
+	index := map[string]int{}
+	for _, ie := range batch {
+		key := tagsKey(ie.tags)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], ie)
+	}
+	return groups
+}
+
+// tagsKey returns a canonical string for a tag set.
+func tagsKey(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(k)
+		sb.WriteByte(0)
+		sb.WriteString(tags[k])
+		sb.WriteByte(0)
 	}
+	return sb.String()
 }
 
 var (
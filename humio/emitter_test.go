@@ -1 +1,404 @@
 package humio
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type request struct {
+	path          string
+	authorization string
+	encoding      string
+	body          []byte
+}
+
+// fakeHumio records ingest requests, responding with the given statuses in
+// order and 200 once they run out.
+type fakeHumio struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []request
+}
+
+func (fh *fakeHumio) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+	data, _ := ioutil.ReadAll(body)
+	fh.requests = append(fh.requests, request{
+		path:          r.URL.Path,
+		authorization: r.Header.Get("Authorization"),
+		encoding:      r.Header.Get("Content-Encoding"),
+		body:          data,
+	})
+	if len(fh.statuses) > 0 {
+		status := fh.statuses[0]
+		fh.statuses = fh.statuses[1:]
+		w.WriteHeader(status)
+	}
+}
+
+func TestEmitterStructured(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeHumio{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	emitter := New(
+		Server(server.URL),
+		IngestToken("secret"),
+		Tags(map[string]string{"env": "test"}),
+	)
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldTimestamp: time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC),
+		ecsevent.FieldMessage:   "hello world",
+	})
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldMessage: "goodbye world",
+	})
+	assert.NoError(emitter.Close())
+
+	assert.Len(fake.requests, 1)
+	r := fake.requests[0]
+	assert.Equal("/api/v1/ingest/humio-structured", r.path)
+	assert.Equal("Bearer secret", r.authorization)
+	assert.Equal("gzip", r.encoding)
+
+	var irs []struct {
+		Tags   map[string]string `json:"tags"`
+		Events []struct {
+			Timestamp  time.Time              `json:"timestamp"`
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"events"`
+	}
+	assert.NoError(json.Unmarshal(r.body, &irs))
+	assert.Len(irs, 1)
+	assert.Equal(map[string]string{"env": "test"}, irs[0].Tags)
+	assert.Len(irs[0].Events, 2)
+	assert.True(time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC).Equal(irs[0].Events[0].Timestamp))
+	assert.Equal("hello world", irs[0].Events[0].Attributes[ecsevent.FieldMessage])
+	assert.False(irs[0].Events[1].Timestamp.IsZero())
+	assert.Equal("goodbye world", irs[0].Events[1].Attributes[ecsevent.FieldMessage])
+}
+
+func TestEmitterUnstructured(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeHumio{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	emitter := New(
+		Server(server.URL),
+		IngestEndpoint(Unstructured),
+		Compression(false),
+	)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+
+	assert.Len(fake.requests, 1)
+	r := fake.requests[0]
+	assert.Equal("/api/v1/ingest/humio-unstructured", r.path)
+	assert.Equal("", r.encoding)
+	assert.JSONEq(`[{"fields":{},"messages":["{\"message\":\"hello world\"}"]}]`, string(r.body))
+}
+
+func TestEmitterHEC(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeHumio{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	emitter := New(Server(server.URL), IngestEndpoint(HEC))
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldTimestamp: time.Date(2020, 4, 1, 12, 0, 0, 500000000, time.UTC),
+		ecsevent.FieldMessage:   "hello world",
+	})
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "goodbye world"})
+	assert.NoError(emitter.Close())
+
+	assert.Len(fake.requests, 1)
+	r := fake.requests[0]
+	assert.Equal("/api/v1/ingest/hec", r.path)
+	lines := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(r.body))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	assert.Len(lines, 2)
+	assert.JSONEq(
+		`{"time":1585742400.5,"event":{"@timestamp":"2020-04-01T12:00:00.5Z","message":"hello world"}}`,
+		lines[0],
+	)
+}
+
+func TestEmitterRetry(t *testing.T) {
+	tcs := []struct {
+		name             string
+		statuses         []int
+		expectedRequests int
+		expectedErrors   int
+	}{
+		{
+			"success",
+			nil,
+			1,
+			0,
+		},
+		{
+			"server error then success",
+			[]int{http.StatusServiceUnavailable, http.StatusTooManyRequests},
+			3,
+			0,
+		},
+		{
+			"retries exhausted",
+			[]int{500, 500, 500, 500},
+			3,
+			1,
+		},
+		{
+			"not retryable",
+			[]int{http.StatusUnauthorized},
+			1,
+			1,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			fake := &fakeHumio{statuses: tc.statuses}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+
+			var errs []error
+			emitter := New(
+				Server(server.URL),
+				MaxRetries(2),
+				RetryBackoff(time.Millisecond),
+				ErrorHandler(func(err error) {
+					errs = append(errs, err)
+				}),
+			)
+			emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+			assert.NoError(emitter.Close())
+			assert.Len(fake.requests, tc.expectedRequests)
+			assert.Len(errs, tc.expectedErrors)
+		})
+	}
+}
+
+func TestEmitterBuffer(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeHumio{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	var errs []error
+	emitter := New(
+		Server(server.URL),
+		BufferSize(2),
+		FlushInterval(time.Hour),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	for i := 0; i < 3; i++ {
+		emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	}
+	assert.Equal([]error{ErrBufferFull}, errs)
+	emitter.Flush()
+	assert.Len(fake.requests, 1)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+	assert.Len(fake.requests, 2)
+	assert.Len(errs, 1)
+}
+
+func TestEmitterBackoff(t *testing.T) {
+	assert := assert.New(t)
+	emitter := New(RetryBackoff(time.Second))
+	defer emitter.Close()
+	for attempt := 1; attempt < 10; attempt++ {
+		delay := emitter.backoff(attempt)
+		expected := time.Second << uint(attempt-1)
+		if expected > maxRetryBackoff {
+			expected = maxRetryBackoff
+		}
+		assert.True(delay >= expected/2, "attempt %d", attempt)
+		assert.True(delay <= expected, "attempt %d", attempt)
+	}
+}
+
+func TestEmitterTagFields(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeHumio{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	var errs []error
+	emitter := New(
+		Server(server.URL),
+		Compression(false),
+		Tags(map[string]string{"env": "test"}),
+		TagFields(map[string]string{
+			ecsevent.FieldServiceName:  "service",
+			ecsevent.FieldEventDataset: "",
+			ecsevent.FieldURLPath:      "path",
+		}),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldServiceName:  "checkout",
+		ecsevent.FieldEventDataset: "checkout.access",
+		ecsevent.FieldURLPath:      "/cart",
+	})
+	emitter.Emit(map[string]interface{}{
+		"service": map[string]interface{}{
+			"name": "inventory",
+		},
+	})
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldServiceName:  "checkout",
+		ecsevent.FieldEventDataset: "checkout.access",
+		ecsevent.FieldURLPath:      "/cart/items",
+	})
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldMessage: "untagged",
+	})
+	assert.NoError(emitter.Close())
+
+	// url.path is refused up front
+	assert.Len(errs, 1)
+
+	assert.Len(fake.requests, 1)
+	var irs []struct {
+		Tags   map[string]string `json:"tags"`
+		Events []interface{}     `json:"events"`
+	}
+	assert.NoError(json.Unmarshal(fake.requests[0].body, &irs))
+	assert.Len(irs, 3)
+	if len(irs) == 3 {
+		assert.Equal(map[string]string{
+			"env":           "test",
+			"service":       "checkout",
+			"event.dataset": "checkout.access",
+		}, irs[0].Tags)
+		assert.Len(irs[0].Events, 2)
+		assert.Equal(map[string]string{
+			"env":     "test",
+			"service": "inventory",
+		}, irs[1].Tags)
+		assert.Len(irs[1].Events, 1)
+		assert.Equal(map[string]string{"env": "test"}, irs[2].Tags)
+		assert.Len(irs[2].Events, 1)
+	}
+}
+
+func TestEmitterTagCardinality(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeHumio{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	var errs []error
+	emitter := New(
+		Server(server.URL),
+		Compression(false),
+		TagFields(map[string]string{ecsevent.FieldServiceName: "service"}),
+		MaxTagCardinality(2),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	for _, name := range []string{"a", "b", "a", "c", "d"} {
+		emitter.Emit(map[string]interface{}{ecsevent.FieldServiceName: name})
+	}
+	assert.NoError(emitter.Close())
+
+	assert.Len(errs, 1)
+	var irs []struct {
+		Tags   map[string]string `json:"tags"`
+		Events []interface{}     `json:"events"`
+	}
+	assert.NoError(json.Unmarshal(fake.requests[0].body, &irs))
+	assert.Len(irs, 3)
+	if len(irs) == 3 {
+		assert.Equal(map[string]string{"service": "a"}, irs[0].Tags)
+		assert.Len(irs[0].Events, 2)
+		assert.Equal(map[string]string{"service": "b"}, irs[1].Tags)
+		assert.Equal(map[string]string{}, irs[2].Tags)
+		assert.Len(irs[2].Events, 2)
+	}
+}
+
+func TestEmitterZeroValue(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeHumio{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	emitter := &Emitter{
+		Server:      server.URL + "/",
+		IngestToken: "secret",
+		Tags:        map[string]string{"env": "test"},
+	}
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+
+	assert.Len(fake.requests, 1)
+	r := fake.requests[0]
+	assert.Equal("/api/v1/ingest/humio-structured", r.path)
+	assert.Equal("Bearer secret", r.authorization)
+	var irs []struct {
+		Tags   map[string]string `json:"tags"`
+		Events []interface{}     `json:"events"`
+	}
+	assert.NoError(json.Unmarshal(r.body, &irs))
+	assert.Len(irs, 1)
+	assert.Equal(map[string]string{"env": "test"}, irs[0].Tags)
+	assert.Len(irs[0].Events, 1)
+
+	assert.NoError((&Emitter{}).Close())
+}
+
+func TestEmitterInvalidBatching(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeHumio{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	emitter := New(Server(server.URL), BatchSize(0), FlushInterval(0))
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+	zero := &Emitter{}
+	zero.Flush()
+	assert.NoError(zero.Close())
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Len(fake.requests, 1)
+}
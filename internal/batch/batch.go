@@ -0,0 +1,182 @@
+// Package batch buffers items for the batching emitters and hands them to a
+// send function in batches, from a background loop or on demand.
+package batch
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+)
+
+var (
+	// ErrBufferFull is returned by Add when the buffer already holds the
+	// maximum number of items.
+	ErrBufferFull = errors.New("buffer is full")
+	// ErrClosed is returned by Add after Close has been called.
+	ErrClosed = errors.New("closed")
+)
+
+// Config configures a Batcher.
+type Config struct {
+	// Send delivers a batch. Calls are serialized so that items stay in
+	// order.
+	Send func(batch []interface{})
+	// Limit optionally returns how many of the leading items fit in a
+	// single batch, e.g. to respect a payload size limit. It's given at most
+	// BatchSize items and should return at least 1 if there are any.
+	Limit func(items []interface{}) int
+	// Flushed is optionally called at the end of each Flush, while sends
+	// are still serialized, e.g. to wait for acknowledgments.
+	Flushed func()
+	// BatchSize is the number of buffered items that triggers a send, and
+	// the maximum size of a batch. Values below 1 use the default.
+	BatchSize int
+	// BufferSize is the maximum number of items held while waiting to be
+	// sent. Zero or less means unlimited.
+	BufferSize int
+	// FlushInterval is the maximum time an item will remain buffered.
+	// Values below 1 use the default.
+	FlushInterval time.Duration
+}
+
+// Batcher buffers items and sends them when the batch size is reached, when
+// the flush interval elapses, or when Flush or Close is called.
+type Batcher struct {
+	config Config
+
+	// mu gates the pending buffer and the closed flag.
+	mu      sync.Mutex
+	pending []interface{}
+	closed  bool
+	// sendMu serializes sends so that items stay in order.
+	sendMu  sync.Mutex
+	trigger chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// New creates a Batcher and starts its background flush loop. Call Close to
+// stop it.
+func New(config Config) *Batcher {
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultFlushInterval
+	}
+	b := &Batcher{
+		config:  config,
+		trigger: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.loop()
+	return b
+}
+
+// Add buffers an item for the next batch. It returns ErrClosed after Close
+// and ErrBufferFull if the buffer is full; the item is dropped either way.
+func (b *Batcher) Add(item interface{}) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	if b.config.BufferSize > 0 && len(b.pending) >= b.config.BufferSize {
+		b.mu.Unlock()
+		return ErrBufferFull
+	}
+	b.pending = append(b.pending, item)
+	full := len(b.pending) >= b.config.BatchSize
+	b.mu.Unlock()
+	if full {
+		select {
+		case b.trigger <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush synchronously sends all buffered items.
+func (b *Batcher) Flush() {
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+	for {
+		b.mu.Lock()
+		n := len(b.pending)
+		if n > b.config.BatchSize {
+			n = b.config.BatchSize
+		}
+		if n > 0 && b.config.Limit != nil {
+			if limit := b.config.Limit(b.pending[:n]); limit < n {
+				n = limit
+			}
+			if n < 1 {
+				n = 1
+			}
+		}
+		batch := b.pending[:n:n]
+		b.pending = b.pending[n:]
+		b.mu.Unlock()
+		if len(batch) == 0 {
+			break
+		}
+		b.config.Send(batch)
+	}
+	if b.config.Flushed != nil {
+		b.config.Flushed()
+	}
+}
+
+// Close flushes any buffered items and stops the background flush loop. It
+// reports whether this call closed the Batcher.
+func (b *Batcher) Close() bool {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return false
+	}
+	b.closed = true
+	b.mu.Unlock()
+	close(b.done)
+	b.wg.Wait()
+	b.Flush()
+	return true
+}
+
+func (b *Batcher) loop() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(b.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.Flush()
+		case <-b.trigger:
+			b.Flush()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// Backoff returns an exponential delay for a retry attempt, starting at base
+// and doubling each attempt up to max, with up to 50% jitter. A max of zero
+// means uncapped.
+func Backoff(base, max time.Duration, attempt int) time.Duration {
+	delay := base << uint(attempt-1)
+	if max > 0 && (delay > max || delay < 0) {
+		delay = max
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -0,0 +1,101 @@
+package batch
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatcher(t *testing.T) {
+	assert := assert.New(t)
+	var mu sync.Mutex
+	var batches [][]interface{}
+	b := New(Config{
+		Send: func(batch []interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			batches = append(batches, batch)
+		},
+		BatchSize:     2,
+		BufferSize:    3,
+		FlushInterval: time.Hour,
+	})
+	assert.NoError(b.Add(1))
+	assert.NoError(b.Add(2))
+	assert.Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(batches) == 1
+	}, time.Second, time.Millisecond)
+	assert.NoError(b.Add(3))
+	assert.NoError(b.Add(4))
+	b.Flush()
+	assert.True(b.Close())
+	assert.False(b.Close())
+	assert.Equal(ErrClosed, b.Add(5))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal([][]interface{}{{1, 2}, {3, 4}}, batches)
+}
+
+func TestBatcherBufferFull(t *testing.T) {
+	assert := assert.New(t)
+	release := make(chan struct{})
+	b := New(Config{
+		Send: func(batch []interface{}) {
+			<-release
+		},
+		BatchSize:     10,
+		BufferSize:    2,
+		FlushInterval: time.Hour,
+	})
+	assert.NoError(b.Add(1))
+	assert.NoError(b.Add(2))
+	assert.Equal(ErrBufferFull, b.Add(3))
+	close(release)
+	b.Close()
+}
+
+func TestBatcherLimit(t *testing.T) {
+	assert := assert.New(t)
+	var sizes []int
+	flushed := 0
+	b := New(Config{
+		Send: func(batch []interface{}) {
+			sizes = append(sizes, len(batch))
+		},
+		Limit: func(items []interface{}) int {
+			return 0
+		},
+		Flushed: func() {
+			flushed++
+		},
+		FlushInterval: time.Hour,
+	})
+	b.Add(1)
+	b.Add(2)
+	b.Close()
+	// A limit below 1 still sends one item at a time.
+	assert.Equal([]int{1, 1}, sizes)
+	assert.Equal(1, flushed)
+}
+
+func TestBatcherDefaults(t *testing.T) {
+	assert := assert.New(t)
+	b := New(Config{Send: func([]interface{}) {}})
+	defer b.Close()
+	assert.Equal(defaultBatchSize, b.config.BatchSize)
+	assert.Equal(defaultFlushInterval, b.config.FlushInterval)
+}
+
+func TestBackoff(t *testing.T) {
+	assert := assert.New(t)
+	for attempt := 1; attempt < 70; attempt++ {
+		delay := Backoff(time.Second, 30*time.Second, attempt)
+		assert.True(delay >= 0 && delay <= 30*time.Second, "attempt %d: %v", attempt, delay)
+	}
+	assert.Equal(time.Duration(0), Backoff(0, 0, 1))
+}
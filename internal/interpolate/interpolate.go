@@ -0,0 +1,47 @@
+// Package interpolate renders the name templates used by the emitters, e.g.
+// Elasticsearch index names and Fluent tags.
+package interpolate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+// Event renders a template for an event. Field values are interpolated with
+// `%{field.name}` and the event timestamp with `%{+layout}`, where layout is a
+// Go time layout. Fields missing from the event interpolate as empty strings
+// and events without a timestamp use the current time.
+func Event(template string, event map[string]interface{}) string {
+	if !strings.Contains(template, "%{") {
+		return template
+	}
+	var sb strings.Builder
+	for {
+		start := strings.Index(template, "%{")
+		if start == -1 {
+			sb.WriteString(template)
+			break
+		}
+		end := strings.IndexByte(template[start:], '}')
+		if end == -1 {
+			sb.WriteString(template)
+			break
+		}
+		sb.WriteString(template[:start])
+		key := template[start+2 : start+end]
+		if strings.HasPrefix(key, "+") {
+			timestamp := ecsevent.Timestamp(event)
+			if timestamp.IsZero() {
+				timestamp = time.Now()
+			}
+			sb.WriteString(timestamp.UTC().Format(key[1:]))
+		} else if value, ok := ecsevent.Lookup(event, key); ok {
+			fmt.Fprint(&sb, value)
+		}
+		template = template[start+end+1:]
+	}
+	return sb.String()
+}
@@ -0,0 +1,36 @@
+package interpolate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvent(t *testing.T) {
+	event := map[string]interface{}{
+		ecsevent.FieldTimestamp:   time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC),
+		ecsevent.FieldServiceName: "checkout",
+		"http": map[string]interface{}{
+			"response": map[string]interface{}{
+				"status_code": 200,
+			},
+		},
+	}
+	testCases := []struct {
+		template string
+		expected string
+	}{
+		{"ecs", "ecs"},
+		{"app.%{service.name}", "app.checkout"},
+		{"ecs-%{+2006.01.02}", "ecs-2020.04.01"},
+		{"%{http.response.status_code}", "200"},
+		{"app.%{missing}", "app."},
+		{"app.%{service.name", "app.%{service.name"},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, Event(tc.template, event), tc.template)
+	}
+}
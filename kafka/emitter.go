@@ -0,0 +1,200 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+	"github.com/sporkmonger/ecsevent/internal/batch"
+)
+
+const (
+	defaultTopic         = "ecs"
+	defaultBatchSize     = 100
+	defaultBufferSize    = 10000
+	defaultFlushInterval = time.Second
+)
+
+// ErrBufferFull is reported when an event is dropped because the buffer
+// already holds the maximum number of events.
+var ErrBufferFull = errors.New("kafka emitter buffer is full, event dropped")
+
+// Emitter buffers ECS formatted events, serializes them to JSON and hands
+// them to a Producer in batches.
+//
+// Events are sent when the batch size is reached, when the flush interval
+// elapses, or when Flush or Close is called.
+type Emitter struct {
+	producer      Producer
+	topic         string
+	keyField      string
+	batchSize     int
+	bufferSize    int
+	flushInterval time.Duration
+	errorHandler  func(error)
+
+	batcher *batch.Batcher
+}
+
+// Option configures an Emitter as it's being initialized.
+type Option func(*Emitter)
+
+// Topic sets the topic events are published to. Defaults to 'ecs'.
+func Topic(topic string) Option {
+	return func(e *Emitter) {
+		e.topic = topic
+	}
+}
+
+// KeyField sets the ECS field used as the message key, e.g. trace.id or
+// service.name, so that related events land in the same partition. Events
+// without the field are published without a key.
+func KeyField(field string) Option {
+	return func(e *Emitter) {
+		e.keyField = field
+	}
+}
+
+// BatchSize sets the number of buffered events that triggers a batch. Values
+// below 1 use the default of 100.
+func BatchSize(size int) Option {
+	return func(e *Emitter) {
+		e.batchSize = size
+	}
+}
+
+// BufferSize sets the maximum number of events held in memory while waiting
+// to be published. Events emitted while the buffer is full are dropped and
+// reported as ErrBufferFull. Defaults to 10000.
+func BufferSize(size int) Option {
+	return func(e *Emitter) {
+		e.bufferSize = size
+	}
+}
+
+// FlushInterval sets the maximum time an event will remain buffered. Values
+// below 1 use the default of one second.
+func FlushInterval(interval time.Duration) Option {
+	return func(e *Emitter) {
+		e.flushInterval = interval
+	}
+}
+
+// ErrorHandler sets a callback for errors encountered while publishing
+// events, since Emit has no way to return them. Each DeliveryError is
+// reported separately.
+func ErrorHandler(handler func(error)) Option {
+	return func(e *Emitter) {
+		e.errorHandler = handler
+	}
+}
+
+// New creates a new Emitter publishing through the given Producer with the
+// given Option functions applied and starts its background flush loop. Call
+// Close to stop it. Closing the Producer remains the caller's responsibility.
+func New(producer Producer, opts ...Option) *Emitter {
+	e := &Emitter{
+		producer:      producer,
+		topic:         defaultTopic,
+		batchSize:     defaultBatchSize,
+		bufferSize:    defaultBufferSize,
+		flushInterval: defaultFlushInterval,
+		errorHandler:  func(error) {},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.batchSize <= 0 {
+		e.batchSize = defaultBatchSize
+	}
+	if e.flushInterval <= 0 {
+		e.flushInterval = defaultFlushInterval
+	}
+	e.batcher = batch.New(batch.Config{
+		Send:          e.send,
+		BatchSize:     e.batchSize,
+		BufferSize:    e.bufferSize,
+		FlushInterval: e.flushInterval,
+	})
+	return e
+}
+
+// Emit takes a map of ECS fields and values and buffers the event for the
+// next batch.
+func (e *Emitter) Emit(event map[string]interface{}) {
+	value, err := json.Marshal(event)
+	if err != nil {
+		e.errorHandler(err)
+		return
+	}
+	timestamp := ecsevent.Timestamp(event)
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	message := &Message{
+		Topic: e.topic,
+		Key:   e.key(event),
+		Value: value,
+		Time:  timestamp,
+	}
+	switch e.batcher.Add(message) {
+	case batch.ErrClosed:
+		e.errorHandler(errors.New("kafka emitter is closed"))
+	case batch.ErrBufferFull:
+		e.errorHandler(ErrBufferFull)
+	}
+}
+
+// key derives the message key from the configured key field.
+func (e *Emitter) key(event map[string]interface{}) []byte {
+	if e.keyField == "" {
+		return nil
+	}
+	value, ok := ecsevent.Lookup(event, e.keyField)
+	if !ok || value == nil {
+		return nil
+	}
+	if s, ok := value.(string); ok {
+		return []byte(s)
+	}
+	return []byte(fmt.Sprint(value))
+}
+
+// Flush synchronously publishes all buffered events.
+func (e *Emitter) Flush() {
+	e.batcher.Flush()
+}
+
+// Close flushes any buffered events and stops the background flush loop.
+// Events emitted after Close are dropped.
+func (e *Emitter) Close() error {
+	e.batcher.Close()
+	return nil
+}
+
+func (e *Emitter) send(items []interface{}) {
+	messages := make([]*Message, len(items))
+	for i, item := range items {
+		messages[i] = item.(*Message)
+	}
+	err := e.producer.Produce(messages)
+	if err == nil {
+		return
+	}
+	if errs, ok := err.(DeliveryErrors); ok {
+		for _, de := range errs {
+			e.errorHandler(de)
+		}
+		return
+	}
+	e.errorHandler(fmt.Errorf("dropped %d events: %v", len(messages), err))
+}
+
+var (
+	// This is a compile-time check to make sure our types correctly
+	// implement the interface:
+	// https://medium.com/@matryer/c167afed3aae
+	_ ecsevent.Emitter = &Emitter{}
+)
@@ -0,0 +1,131 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmitter(t *testing.T) {
+	assert := assert.New(t)
+	broker := NewMemoryBroker(4)
+	emitter := New(broker, Topic("logs"), KeyField(ecsevent.FieldTraceID))
+	traces := []string{"4bf92f3577b34da6a3ce929d0e0e4736", "0af7651916cd43dd8448eb211c80319c"}
+	for i := 0; i < 10; i++ {
+		emitter.Emit(map[string]interface{}{
+			ecsevent.FieldTimestamp: "2019-10-28T06:15:07.226113003Z",
+			"trace": map[string]interface{}{
+				"id": traces[i%2],
+			},
+			ecsevent.FieldMessage: "hello world",
+		})
+	}
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "no trace"})
+	assert.NoError(emitter.Close())
+
+	messages := broker.Messages("logs")
+	assert.Len(messages, 11)
+	for _, trace := range traces {
+		partition := broker.PartitionMessages("logs", broker.Partition([]byte(trace)))
+		count := 0
+		for _, message := range partition {
+			if string(message.Key) == trace {
+				count++
+			}
+		}
+		assert.Equal(5, count)
+	}
+	for _, message := range messages {
+		assert.Equal("logs", message.Topic)
+		if message.Key == nil {
+			event := map[string]interface{}{}
+			assert.NoError(json.Unmarshal(message.Value, &event))
+			assert.Equal(map[string]interface{}{ecsevent.FieldMessage: "no trace"}, event)
+			continue
+		}
+		assert.True(time.Date(2019, 10, 28, 6, 15, 7, 226113003, time.UTC).Equal(message.Time))
+	}
+}
+
+func TestEmitterBatching(t *testing.T) {
+	assert := assert.New(t)
+	broker := NewMemoryBroker(1)
+	counter := &countingProducer{producer: broker}
+	emitter := New(counter, BatchSize(3), FlushInterval(time.Hour))
+	for i := 0; i < 7; i++ {
+		emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	}
+	assert.NoError(emitter.Close())
+	assert.Equal([]int{3, 3, 1}, counter.batches)
+	assert.Len(broker.Messages(defaultTopic), 7)
+}
+
+func TestEmitterBuffer(t *testing.T) {
+	assert := assert.New(t)
+	broker := NewMemoryBroker(1)
+	var errs []error
+	emitter := New(broker, BufferSize(2), FlushInterval(time.Hour), ErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}))
+	for i := 0; i < 3; i++ {
+		emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	}
+	assert.Equal([]error{ErrBufferFull}, errs)
+	assert.NoError(emitter.Close())
+	assert.Len(broker.Messages(defaultTopic), 2)
+}
+
+func TestEmitterInvalidBatching(t *testing.T) {
+	assert := assert.New(t)
+	broker := NewMemoryBroker(1)
+	counter := &countingProducer{producer: broker}
+	emitter := New(counter, BatchSize(0), FlushInterval(0))
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+	assert.Equal([]int{1}, counter.batches)
+}
+
+func TestEmitterDeliveryErrors(t *testing.T) {
+	assert := assert.New(t)
+	broker := NewMemoryBroker(1)
+	broker.FailNext(errors.New("broker unavailable"))
+	var errs []error
+	emitter := New(broker, ErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}))
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	emitter.Flush()
+	assert.Len(errs, 1)
+	assert.Empty(broker.Messages(defaultTopic))
+
+	message := &Message{Topic: defaultTopic}
+	broker.FailNext(DeliveryErrors{
+		{Message: message, Err: errors.New("message too large")},
+		{Message: message, Err: errors.New("message too large")},
+	})
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+	assert.Len(errs, 3)
+	if assert.IsType(&DeliveryError{}, errs[1]) {
+		assert.Equal("failed to deliver message to ecs: message too large", errs[1].Error())
+	}
+
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "after close"})
+	assert.Len(errs, 4)
+}
+
+// countingProducer records batch sizes before delegating to a Producer.
+type countingProducer struct {
+	producer Producer
+	batches  []int
+}
+
+func (cp *countingProducer) Produce(messages []*Message) error {
+	cp.batches = append(cp.batches, len(messages))
+	return cp.producer.Produce(messages)
+}
@@ -0,0 +1,30 @@
+package kafka
+
+import (
+	"context"
+
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+type kafkaGoProducer struct {
+	writer *kafkago.Writer
+}
+
+// KafkaGo adapts a kafka-go Writer. A Writer is bound to a single topic, so
+// the emitter's Topic option is ignored in favor of the Writer's. Use the
+// kafkago.Hash balancer for keys to determine partitions.
+func KafkaGo(writer *kafkago.Writer) Producer {
+	return &kafkaGoProducer{writer: writer}
+}
+
+func (kp *kafkaGoProducer) Produce(messages []*Message) error {
+	msgs := make([]kafkago.Message, len(messages))
+	for i, message := range messages {
+		msgs[i] = kafkago.Message{
+			Key:   message.Key,
+			Value: message.Value,
+			Time:  message.Time,
+		}
+	}
+	return kp.writer.WriteMessages(context.Background(), msgs...)
+}
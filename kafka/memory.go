@@ -0,0 +1,100 @@
+package kafka
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+)
+
+// MemoryBroker is an in-memory Producer for tests. Messages are assigned to
+// partitions by an FNV-1a hash of their key, as the Sarama and kafka-go hash
+// partitioners do, or randomly if they have no key.
+type MemoryBroker struct {
+	partitions int
+
+	mu       sync.Mutex
+	topics   map[string][][]*Message
+	failures []error
+}
+
+// NewMemoryBroker creates a MemoryBroker whose topics have the given number
+// of partitions.
+func NewMemoryBroker(partitions int) *MemoryBroker {
+	if partitions < 1 {
+		partitions = 1
+	}
+	return &MemoryBroker{
+		partitions: partitions,
+		topics:     map[string][][]*Message{},
+	}
+}
+
+// Produce appends the messages to their topic partitions. If failures were
+// queued with FailNext, the next one is returned for the batch instead.
+func (mb *MemoryBroker) Produce(messages []*Message) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if len(mb.failures) > 0 {
+		err := mb.failures[0]
+		mb.failures = mb.failures[1:]
+		if err != nil {
+			return err
+		}
+	}
+	for _, message := range messages {
+		partitions, ok := mb.topics[message.Topic]
+		if !ok {
+			partitions = make([][]*Message, mb.partitions)
+			mb.topics[message.Topic] = partitions
+		}
+		partition := mb.Partition(message.Key)
+		partitions[partition] = append(partitions[partition], message)
+	}
+	return nil
+}
+
+// Partition returns the partition a key is assigned to.
+func (mb *MemoryBroker) Partition(key []byte) int {
+	if key == nil {
+		return rand.Intn(mb.partitions)
+	}
+	h := fnv.New32a()
+	h.Write(key)
+	partition := int32(h.Sum32()) % int32(mb.partitions)
+	if partition < 0 {
+		partition = -partition
+	}
+	return int(partition)
+}
+
+// FailNext queues an error to be returned by the next call to Produce. A nil
+// error lets that call succeed, which allows failures to be interleaved.
+func (mb *MemoryBroker) FailNext(err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.failures = append(mb.failures, err)
+}
+
+// Messages returns all messages published to a topic, ordered by partition
+// and then offset.
+func (mb *MemoryBroker) Messages(topic string) []*Message {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	var messages []*Message
+	for _, partition := range mb.topics[topic] {
+		messages = append(messages, partition...)
+	}
+	return messages
+}
+
+// PartitionMessages returns the messages published to a single partition of
+// a topic, in offset order.
+func (mb *MemoryBroker) PartitionMessages(topic string, partition int) []*Message {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	partitions := mb.topics[topic]
+	if partition < 0 || partition >= len(partitions) {
+		return nil
+	}
+	return append([]*Message(nil), partitions[partition]...)
+}
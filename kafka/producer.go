@@ -0,0 +1,41 @@
+package kafka
+
+import (
+	"fmt"
+	"time"
+)
+
+// Message is a single record to be published to Kafka.
+type Message struct {
+	Topic string
+	Key   []byte
+	Value []byte
+	Time  time.Time
+}
+
+// Producer publishes batches of messages. Implementations should block until
+// the batch has been delivered or has failed.
+//
+// Adapters are provided for the Sarama and kafka-go clients, and
+// MemoryBroker may be used in tests.
+type Producer interface {
+	Produce(messages []*Message) error
+}
+
+// DeliveryError describes a message that could not be delivered.
+type DeliveryError struct {
+	Message *Message
+	Err     error
+}
+
+func (de *DeliveryError) Error() string {
+	return fmt.Sprintf("failed to deliver message to %s: %v", de.Message.Topic, de.Err)
+}
+
+// DeliveryErrors may be returned by a Producer when only some messages in a
+// batch failed, allowing the others to be considered delivered.
+type DeliveryErrors []*DeliveryError
+
+func (de DeliveryErrors) Error() string {
+	return fmt.Sprintf("failed to deliver %d messages", len(de))
+}
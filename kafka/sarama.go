@@ -0,0 +1,46 @@
+package kafka
+
+import (
+	"github.com/Shopify/sarama"
+)
+
+type saramaProducer struct {
+	producer sarama.SyncProducer
+}
+
+// Sarama adapts a Sarama SyncProducer. Per-message failures are returned as
+// DeliveryErrors. Use sarama.NewHashPartitioner in the producer's config for
+// keys to determine partitions.
+func Sarama(producer sarama.SyncProducer) Producer {
+	return &saramaProducer{producer: producer}
+}
+
+func (sp *saramaProducer) Produce(messages []*Message) error {
+	msgs := make([]*sarama.ProducerMessage, len(messages))
+	sources := make(map[*sarama.ProducerMessage]*Message, len(messages))
+	for i, message := range messages {
+		msg := &sarama.ProducerMessage{
+			Topic:     message.Topic,
+			Value:     sarama.ByteEncoder(message.Value),
+			Timestamp: message.Time,
+		}
+		if message.Key != nil {
+			msg.Key = sarama.ByteEncoder(message.Key)
+		}
+		msgs[i] = msg
+		sources[msg] = message
+	}
+	err := sp.producer.SendMessages(msgs)
+	if errs, ok := err.(sarama.ProducerErrors); ok {
+		deliveryErrors := make(DeliveryErrors, 0, len(errs))
+		for _, pe := range errs {
+			message, ok := sources[pe.Msg]
+			if !ok {
+				continue
+			}
+			deliveryErrors = append(deliveryErrors, &DeliveryError{Message: message, Err: pe.Err})
+		}
+		return deliveryErrors
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSyncProducer fails every message whose key matches failKey.
+type fakeSyncProducer struct {
+	failKey string
+	sent    []*sarama.ProducerMessage
+}
+
+func (fp *fakeSyncProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	return 0, 0, fp.SendMessages([]*sarama.ProducerMessage{msg})
+}
+
+func (fp *fakeSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var errs sarama.ProducerErrors
+	for _, msg := range msgs {
+		if msg.Key != nil {
+			key, _ := msg.Key.Encode()
+			if string(key) == fp.failKey {
+				errs = append(errs, &sarama.ProducerError{Msg: msg, Err: errors.New("not leader for partition")})
+				continue
+			}
+		}
+		fp.sent = append(fp.sent, msg)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (fp *fakeSyncProducer) Close() error {
+	return nil
+}
+
+func TestSarama(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeSyncProducer{failKey: "bad"}
+	producer := Sarama(fake)
+	good := &Message{Topic: "logs", Key: []byte("good"), Value: []byte(`{}`)}
+	bad := &Message{Topic: "logs", Key: []byte("bad"), Value: []byte(`{}`)}
+	unkeyed := &Message{Topic: "logs", Value: []byte(`{}`)}
+
+	err := producer.Produce([]*Message{good, bad, unkeyed})
+	errs, ok := err.(DeliveryErrors)
+	if assert.True(ok) && assert.Len(errs, 1) {
+		assert.Equal(bad, errs[0].Message)
+	}
+	if assert.Len(fake.sent, 2) {
+		assert.Equal("logs", fake.sent[0].Topic)
+		assert.Equal(sarama.ByteEncoder("good"), fake.sent[0].Key)
+		assert.Nil(fake.sent[1].Key)
+	}
+
+	assert.NoError(producer.Produce([]*Message{good}))
+}
@@ -0,0 +1,131 @@
+package logrus
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+// Hook is a logrus Hook that translates entries into ECS events and records
+// them on a Monitor. Entries logged with WithContext are recorded on the
+// Monitor in their context, if any, so that they become subevents of the
+// current request's SpanMonitor.
+//
+// The logger still writes its own output; set its Out to ioutil.Discard to
+// send entries only to the Monitor.
+type Hook struct {
+	monitor ecsevent.Monitor
+	levels  []logrus.Level
+	mappers []func(string) string
+}
+
+// Option configures a Hook as it's being initialized.
+type Option func(*Hook)
+
+// Levels sets the levels the hook fires for. Defaults to all levels.
+func Levels(levels ...logrus.Level) Option {
+	return func(h *Hook) {
+		h.levels = levels
+	}
+}
+
+// RenameFields remaps field names onto ECS field names, e.g.
+// {"status": ecsevent.FieldHTTPResponseStatusCode}. Renaming a field to ""
+// drops it.
+func RenameFields(renames map[string]string) Option {
+	copied := make(map[string]string, len(renames))
+	for name, ecsName := range renames {
+		copied[name] = ecsName
+	}
+	return MapFieldNames(func(name string) string {
+		if ecsName, ok := copied[name]; ok {
+			return ecsName
+		}
+		return name
+	})
+}
+
+// MapFieldNames adds a function that remaps every field name, returning ""
+// to drop the field. Mappers are applied in the order they were given, after
+// the default mapping of logrus.ErrorKey to error.message.
+func MapFieldNames(mapper func(name string) string) Option {
+	return func(h *Hook) {
+		h.mappers = append(h.mappers, mapper)
+	}
+}
+
+// NewHook creates a Hook recording entries on the Monitor with the given
+// Option functions applied. Add it with logger.AddHook.
+func NewHook(monitor ecsevent.Monitor, opts ...Option) *Hook {
+	h := &Hook{
+		monitor: monitor,
+		levels:  logrus.AllLevels,
+	}
+	h.mappers = append(h.mappers, func(name string) string {
+		if name == logrus.ErrorKey {
+			return ecsevent.FieldErrorMessage
+		}
+		return name
+	})
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Levels returns the levels the hook fires for.
+func (h *Hook) Levels() []logrus.Level {
+	return h.levels
+}
+
+// Fire translates the entry into an ECS event and records it.
+func (h *Hook) Fire(entry *logrus.Entry) error {
+	event := make(map[string]interface{}, len(entry.Data)+6)
+	for key, value := range entry.Data {
+		for _, mapper := range h.mappers {
+			key = mapper(key)
+			if key == "" {
+				break
+			}
+		}
+		if key == "" {
+			continue
+		}
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		event[key] = value
+	}
+	event[ecsevent.FieldLogLevel] = entry.Level.String()
+	if !entry.Time.IsZero() {
+		event[ecsevent.FieldTimestamp] = entry.Time
+	}
+	if entry.Message != "" {
+		event[ecsevent.FieldMessage] = entry.Message
+	}
+	if entry.Caller != nil {
+		event[ecsevent.FieldLogOriginFileName] = entry.Caller.File
+		event[ecsevent.FieldLogOriginFileLine] = entry.Caller.Line
+		event[ecsevent.FieldLogOriginFunction] = entry.Caller.Function
+	}
+
+	monitor := h.monitor
+	if entry.Context != nil {
+		if m := ecsevent.MonitorFromContext(entry.Context); m != nil {
+			if _, ok := m.(*ecsevent.NopMonitor); !ok {
+				monitor = m
+			}
+		}
+	}
+	if monitor != nil {
+		monitor.Record(event)
+	}
+	return nil
+}
+
+var (
+	// This is a compile-time check to make sure our types correctly
+	// implement the interface:
+	// https://medium.com/@matryer/c167afed3aae
+	_ logrus.Hook = &Hook{}
+)
@@ -0,0 +1,133 @@
+package logrus
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+type captureEmitter struct {
+	events []map[string]interface{}
+}
+
+func (ce *captureEmitter) Emit(event map[string]interface{}) {
+	ce.events = append(ce.events, event)
+}
+
+func newLogger(hook *Hook) *logrus.Logger {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Level = logrus.TraceLevel
+	logger.AddHook(hook)
+	return logger
+}
+
+func TestHook(t *testing.T) {
+	tcs := []struct {
+		name           string
+		opts           []Option
+		log            func(*logrus.Logger)
+		expectedOutput map[string]interface{}
+	}{
+		{
+			"standard fields",
+			nil,
+			func(logger *logrus.Logger) {
+				logger.WithError(errors.New("boom")).Warn("slow query")
+			},
+			map[string]interface{}{
+				ecsevent.FieldLogLevel:     "warning",
+				ecsevent.FieldMessage:      "slow query",
+				ecsevent.FieldErrorMessage: "boom",
+			},
+		},
+		{
+			"renamed and dropped fields",
+			[]Option{
+				RenameFields(map[string]string{"status": ecsevent.FieldHTTPResponseStatusCode}),
+				MapFieldNames(func(name string) string {
+					if name == "secret" {
+						return ""
+					}
+					return name
+				}),
+			},
+			func(logger *logrus.Logger) {
+				logger.WithFields(logrus.Fields{
+					"status":                  200,
+					"secret":                  "hunter2",
+					ecsevent.FieldServiceName: "checkout",
+				}).Info("done")
+			},
+			map[string]interface{}{
+				ecsevent.FieldLogLevel:               "info",
+				ecsevent.FieldMessage:                "done",
+				ecsevent.FieldServiceName:            "checkout",
+				ecsevent.FieldHTTPResponseStatusCode: 200,
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			emitter := &captureEmitter{}
+			rm := ecsevent.NewRootMonitor(ecsevent.NestEvents(false))
+			rm.AppendEmitter(emitter)
+			tc.log(newLogger(NewHook(rm, tc.opts...)))
+			if assert.Len(emitter.events, 1) {
+				event := emitter.events[0]
+				assert.IsType(time.Time{}, event[ecsevent.FieldTimestamp])
+				delete(event, ecsevent.FieldTimestamp)
+				assert.Equal(tc.expectedOutput, event)
+			}
+		})
+	}
+}
+
+func TestHookLevelsAndCaller(t *testing.T) {
+	assert := assert.New(t)
+	emitter := &captureEmitter{}
+	rm := ecsevent.NewRootMonitor(ecsevent.NestEvents(false))
+	rm.AppendEmitter(emitter)
+	logger := newLogger(NewHook(rm, Levels(logrus.ErrorLevel, logrus.WarnLevel)))
+	logger.SetReportCaller(true)
+	logger.Info("ignored")
+	logger.Error("recorded")
+	if assert.Len(emitter.events, 1) {
+		event := emitter.events[0]
+		assert.Equal("error", event[ecsevent.FieldLogLevel])
+		assert.Contains(event[ecsevent.FieldLogOriginFileName], "hook_test.go")
+		assert.IsType(0, event[ecsevent.FieldLogOriginFileLine])
+		assert.Contains(event[ecsevent.FieldLogOriginFunction], "TestHookLevelsAndCaller")
+	}
+}
+
+func TestHookContext(t *testing.T) {
+	assert := assert.New(t)
+	emitter := &captureEmitter{}
+	rm := ecsevent.NewRootMonitor(ecsevent.NestEvents(false))
+	rm.AppendEmitter(emitter)
+	span := ecsevent.NewSpanMonitorFromParent(rm)
+	ctx := span.WithContext(context.Background())
+
+	logger := newLogger(NewHook(rm))
+	logger.WithContext(ctx).Info("querying")
+	logger.WithContext(context.Background()).Info("outside")
+	span.Finish()
+
+	if assert.Len(emitter.events, 2) {
+		assert.Equal("outside", emitter.events[0][ecsevent.FieldMessage])
+		subevents, ok := emitter.events[1][ecsevent.FieldEventSubevents].([]map[string]interface{})
+		if assert.True(ok) && assert.Len(subevents, 1) {
+			assert.Equal("querying", subevents[0][ecsevent.FieldMessage])
+		}
+	}
+}
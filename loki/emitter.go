@@ -0,0 +1,463 @@
+package loki
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+
+	"github.com/sporkmonger/ecsevent"
+	"github.com/sporkmonger/ecsevent/internal/batch"
+)
+
+const (
+	defaultServer        = "http://localhost:3100"
+	defaultBatchSize     = 500
+	defaultBufferSize    = 10000
+	defaultFlushInterval = 2 * time.Second
+	defaultMaxRetries    = 5
+	defaultRetryBackoff  = 250 * time.Millisecond
+	maxRetryBackoff      = 30 * time.Second
+
+	// Loki rejects streams without labels, events that have none get this
+	// one, the same Loki uses for OTLP logs lacking service.name.
+	fallbackLabelName  = "service_name"
+	fallbackLabelValue = "unknown_service"
+)
+
+// ErrBufferFull is reported when an event is dropped because the buffer
+// already holds the maximum number of events.
+var ErrBufferFull = errors.New("loki emitter buffer is full, event dropped")
+
+// Encoding selects the format of push request bodies.
+type Encoding int
+
+const (
+	// Protobuf sends snappy compressed protobuf push requests, Loki's native
+	// and most efficient format.
+	Protobuf Encoding = iota
+	// JSON sends push requests as JSON.
+	JSON
+)
+
+// Emitter buffers ECS formatted events and pushes them to Grafana Loki.
+//
+// Events are grouped into streams by the values of their label fields. Label
+// fields are removed from the event and the remainder is serialized as the
+// JSON log line.
+type Emitter struct {
+	server        string
+	labelFields   []string
+	staticLabels  map[string]string
+	encoding      Encoding
+	tenantID      string
+	username      string
+	password      string
+	client        *http.Client
+	batchSize     int
+	bufferSize    int
+	flushInterval time.Duration
+	maxRetries    int
+	retryBackoff  time.Duration
+	errorHandler  func(error)
+
+	batcher *batch.Batcher
+}
+
+// Option configures an Emitter as it's being initialized.
+type Option func(*Emitter)
+
+// Server sets the Loki origin to push events to. No trailing slash,
+// typically. Defaults to 'http://localhost:3100'.
+func Server(server string) Option {
+	return func(e *Emitter) {
+		e.server = strings.TrimSuffix(server, "/")
+	}
+}
+
+// Labels sets the ECS fields used as stream labels. Dots in field names
+// become underscores in label names, e.g. service.name becomes service_name.
+// Only low-cardinality fields should be used. Defaults to service.name,
+// log.level and event.dataset. Events ending up without any label are sent
+// with service_name="unknown_service", since Loki rejects empty label sets.
+func Labels(fields ...string) Option {
+	return func(e *Emitter) {
+		e.labelFields = fields
+	}
+}
+
+// StaticLabels sets labels applied to every stream, e.g. {"env": "prod"}.
+func StaticLabels(labels map[string]string) Option {
+	return func(e *Emitter) {
+		e.staticLabels = labels
+	}
+}
+
+// PushEncoding selects the push request format. Defaults to Protobuf.
+func PushEncoding(encoding Encoding) Option {
+	return func(e *Emitter) {
+		e.encoding = encoding
+	}
+}
+
+// TenantID sets the X-Scope-OrgID header for multi-tenant Loki deployments.
+func TenantID(tenantID string) Option {
+	return func(e *Emitter) {
+		e.tenantID = tenantID
+	}
+}
+
+// BasicAuth authenticates requests with a username and password.
+func BasicAuth(username, password string) Option {
+	return func(e *Emitter) {
+		e.username = username
+		e.password = password
+	}
+}
+
+// HTTPClient sets the client used to send push requests.
+func HTTPClient(client *http.Client) Option {
+	return func(e *Emitter) {
+		e.client = client
+	}
+}
+
+// BatchSize sets the number of buffered events that triggers a push request.
+// Values below 1 use the default of 500.
+func BatchSize(size int) Option {
+	return func(e *Emitter) {
+		e.batchSize = size
+	}
+}
+
+// BufferSize sets the maximum number of events held in memory while waiting
+// to be sent. Events emitted while the buffer is full are dropped and
+// reported as ErrBufferFull. Defaults to 10000.
+func BufferSize(size int) Option {
+	return func(e *Emitter) {
+		e.bufferSize = size
+	}
+}
+
+// FlushInterval sets the maximum time an event will remain buffered. Values
+// below 1 use the default of 2 seconds.
+func FlushInterval(interval time.Duration) Option {
+	return func(e *Emitter) {
+		e.flushInterval = interval
+	}
+}
+
+// MaxRetries sets how many times a request that failed with a 429 or 5xx
+// status or a network error will be retried before its events are dropped.
+func MaxRetries(retries int) Option {
+	return func(e *Emitter) {
+		e.maxRetries = retries
+	}
+}
+
+// RetryBackoff sets the base delay between retries. The delay doubles with
+// each attempt, up to 30 seconds, and has jitter applied.
+func RetryBackoff(backoff time.Duration) Option {
+	return func(e *Emitter) {
+		e.retryBackoff = backoff
+	}
+}
+
+// ErrorHandler sets a callback for errors encountered while shipping events,
+// since Emit has no way to return them.
+func ErrorHandler(handler func(error)) Option {
+	return func(e *Emitter) {
+		e.errorHandler = handler
+	}
+}
+
+// New creates a new Emitter with the given Option functions applied and
+// starts its background flush loop. Call Close to stop it.
+func New(opts ...Option) *Emitter {
+	e := &Emitter{
+		server: defaultServer,
+		labelFields: []string{
+			ecsevent.FieldServiceName,
+			ecsevent.FieldLogLevel,
+			ecsevent.FieldEventDataset,
+		},
+		batchSize:     defaultBatchSize,
+		bufferSize:    defaultBufferSize,
+		flushInterval: defaultFlushInterval,
+		maxRetries:    defaultMaxRetries,
+		retryBackoff:  defaultRetryBackoff,
+		errorHandler:  func(error) {},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.batchSize <= 0 {
+		e.batchSize = defaultBatchSize
+	}
+	if e.flushInterval <= 0 {
+		e.flushInterval = defaultFlushInterval
+	}
+	if e.client == nil {
+		e.client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: (&net.Dialer{
+					Timeout:   10 * time.Second,
+					KeepAlive: 10 * time.Second,
+				}).DialContext,
+				TLSHandshakeTimeout:   10 * time.Second,
+				ExpectContinueTimeout: 4 * time.Second,
+				ResponseHeaderTimeout: 10 * time.Second,
+			},
+			Timeout: 2 * time.Minute,
+		}
+	}
+	e.batcher = batch.New(batch.Config{
+		Send:          e.send,
+		BatchSize:     e.batchSize,
+		BufferSize:    e.bufferSize,
+		FlushInterval: e.flushInterval,
+	})
+	return e
+}
+
+// entry is a single log line along with the labels of its stream.
+type entry struct {
+	labels    string
+	labelSet  map[string]string
+	timestamp time.Time
+	line      string
+}
+
+// stream is a group of entries sharing a label set.
+type stream struct {
+	labels   string
+	labelSet map[string]string
+	entries  []*entry
+}
+
+// Emit takes a map of ECS fields and values and buffers the event for the
+// next push request.
+func (e *Emitter) Emit(event map[string]interface{}) {
+	timestamp := ecsevent.Timestamp(event)
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	labels := map[string]string{}
+	for k, v := range e.staticLabels {
+		if v != "" {
+			labels[labelName(k)] = v
+		}
+	}
+	line := event
+	for _, field := range e.labelFields {
+		value, ok := ecsevent.Lookup(event, field)
+		if !ok {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			if v == "" {
+				// Loki drops empty labels, keep the field in the line.
+				continue
+			}
+			labels[labelName(field)] = v
+		case bool, int, int32, int64, uint, uint32, uint64:
+			labels[labelName(field)] = fmt.Sprint(v)
+		default:
+			// Lists, maps and the like don't make meaningful labels, leave
+			// them in the line.
+			continue
+		}
+		line = without(line, field)
+	}
+	if len(labels) == 0 {
+		labels[fallbackLabelName] = fallbackLabelValue
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		e.errorHandler(err)
+		return
+	}
+	en := &entry{
+		labels:    formatLabels(labels),
+		labelSet:  labels,
+		timestamp: timestamp,
+		line:      string(data),
+	}
+	switch e.batcher.Add(en) {
+	case batch.ErrClosed:
+		e.errorHandler(errors.New("loki emitter is closed"))
+	case batch.ErrBufferFull:
+		e.errorHandler(ErrBufferFull)
+	}
+}
+
+// Flush synchronously sends all buffered events.
+func (e *Emitter) Flush() {
+	e.batcher.Flush()
+}
+
+// Close flushes any buffered events and stops the background flush loop.
+// Events emitted after Close are dropped.
+func (e *Emitter) Close() error {
+	e.batcher.Close()
+	return nil
+}
+
+// send delivers a batch, retrying on network errors and retryable statuses.
+func (e *Emitter) send(items []interface{}) {
+	entries := make([]*entry, len(items))
+	for i, item := range items {
+		entries[i] = item.(*entry)
+	}
+	streams := groupStreams(entries)
+	var body []byte
+	var contentType string
+	if e.encoding == JSON {
+		body, _ = encodeJSON(streams)
+		contentType = "application/json"
+	} else {
+		body = snappy.Encode(nil, encodeProtobuf(streams))
+		contentType = "application/x-protobuf"
+	}
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(batch.Backoff(e.retryBackoff, maxRetryBackoff, attempt))
+		}
+		retry, err := e.post(body, contentType)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= e.maxRetries {
+			e.errorHandler(fmt.Errorf("dropped %d events: %v", len(entries), err))
+			return
+		}
+	}
+}
+
+// post sends a single push request. It reports whether a failed request
+// should be retried.
+func (e *Emitter) post(body []byte, contentType string) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, e.server+"/loki/api/v1/push", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if e.tenantID != "" {
+		req.Header.Set("X-Scope-OrgID", e.tenantID)
+	}
+	if e.username != "" {
+		req.SetBasicAuth(e.username, e.password)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	err = fmt.Errorf("loki push request failed with status %d: %s",
+		resp.StatusCode, strings.TrimSpace(string(data)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// groupStreams groups entries by label set. Loki rejects out of order
+// entries within a stream, so each stream's entries are sorted by time.
+func groupStreams(batch []*entry) []*stream {
+	streams := []*stream{}
+	index := map[string]*stream{}
+	for _, en := range batch {
+		s, ok := index[en.labels]
+		if !ok {
+			s = &stream{labels: en.labels, labelSet: en.labelSet}
+			index[en.labels] = s
+			streams = append(streams, s)
+		}
+		s.entries = append(s.entries, en)
+	}
+	for _, s := range streams {
+		sort.SliceStable(s.entries, func(i, j int) bool {
+			return s.entries[i].timestamp.Before(s.entries[j].timestamp)
+		})
+	}
+	return streams
+}
+
+// labelName converts an ECS field name into a valid Loki label name.
+func labelName(field string) string {
+	b := []byte(field)
+	for i, c := range b {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// formatLabels renders a label set in Prometheus text format, sorted by
+// label name, e.g. `{log_level="info", service_name="checkout"}`.
+func formatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(labels[name]))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// without returns a copy of the event with the field removed, whether the
+// event is flat or nested. Nested maps along the path are copied rather than
+// modified.
+func without(event map[string]interface{}, field string) map[string]interface{} {
+	copied := make(map[string]interface{}, len(event))
+	for k, v := range event {
+		copied[k] = v
+	}
+	if _, ok := copied[field]; ok {
+		delete(copied, field)
+		return copied
+	}
+	for i := strings.IndexByte(field, '.'); i != -1; {
+		if child, ok := copied[field[:i]].(map[string]interface{}); ok {
+			if _, found := ecsevent.Lookup(child, field[i+1:]); found {
+				child = without(child, field[i+1:])
+				if len(child) == 0 {
+					delete(copied, field[:i])
+				} else {
+					copied[field[:i]] = child
+				}
+				return copied
+			}
+		}
+		next := strings.IndexByte(field[i+1:], '.')
+		if next == -1 {
+			break
+		}
+		i += next + 1
+	}
+	return copied
+}
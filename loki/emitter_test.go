@@ -0,0 +1,390 @@
+package loki
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/sporkmonger/ecsevent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type pushedEntry struct {
+	timestamp time.Time
+	line      string
+}
+
+// fakeLoki decodes push requests in either encoding into streams keyed by
+// their label string.
+type fakeLoki struct {
+	mu       sync.Mutex
+	statuses []int
+	requests int
+	tenants  []string
+	streams  map[string][]pushedEntry
+	order    []string
+}
+
+func (fl *fakeLoki) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	fl.requests++
+	fl.tenants = append(fl.tenants, r.Header.Get("X-Scope-OrgID"))
+	if r.URL.Path != "/loki/api/v1/push" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if len(fl.statuses) > 0 {
+		status := fl.statuses[0]
+		fl.statuses = fl.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+	if fl.streams == nil {
+		fl.streams = map[string][]pushedEntry{}
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	switch r.Header.Get("Content-Type") {
+	case "application/json":
+		pr := jsonPushRequest{}
+		if err := json.Unmarshal(body, &pr); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, s := range pr.Streams {
+			if len(s.Stream) == 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			labels := formatLabels(s.Stream)
+			fl.order = append(fl.order, labels)
+			for _, value := range s.Values {
+				ns, _ := time.ParseDuration(value[0] + "ns")
+				fl.streams[labels] = append(fl.streams[labels], pushedEntry{
+					timestamp: time.Unix(0, int64(ns)),
+					line:      value[1],
+				})
+			}
+		}
+	case "application/x-protobuf":
+		data, err := snappy.Decode(nil, body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, sb := range readFields(data)[1] {
+			sf := readFields(sb)
+			labels := string(sf[1][0])
+			if labels == "{}" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fl.order = append(fl.order, labels)
+			for _, eb := range sf[2] {
+				ef := readFields(eb)
+				var seconds, nanos uint64
+				if ts := ef[1]; len(ts) > 0 {
+					tf := readVarints(ts[0])
+					seconds, nanos = tf[1], tf[2]
+				}
+				fl.streams[labels] = append(fl.streams[labels], pushedEntry{
+					timestamp: time.Unix(int64(seconds), int64(nanos)),
+					line:      string(ef[2][0]),
+				})
+			}
+		}
+	default:
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func readVarint(data []byte) (uint64, int) {
+	var v uint64
+	for i, b := range data {
+		v |= uint64(b&0x7f) << (7 * uint(i))
+		if b < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, len(data)
+}
+
+// readFields decodes length-delimited fields of a protobuf message.
+func readFields(data []byte) map[int][][]byte {
+	fields := map[int][][]byte{}
+	for len(data) > 0 {
+		key, n := readVarint(data)
+		data = data[n:]
+		length, n := readVarint(data)
+		data = data[n:]
+		fields[int(key>>3)] = append(fields[int(key>>3)], data[:length])
+		data = data[length:]
+	}
+	return fields
+}
+
+// readVarints decodes varint fields of a protobuf message.
+func readVarints(data []byte) map[int]uint64 {
+	fields := map[int]uint64{}
+	for len(data) > 0 {
+		key, n := readVarint(data)
+		data = data[n:]
+		value, n := readVarint(data)
+		data = data[n:]
+		fields[int(key>>3)] = value
+	}
+	return fields
+}
+
+func TestEmitter(t *testing.T) {
+	for _, encoding := range []Encoding{Protobuf, JSON} {
+		assert := assert.New(t)
+		fake := &fakeLoki{}
+		server := httptest.NewServer(fake)
+
+		base := time.Date(2020, 4, 1, 12, 0, 0, 500, time.UTC)
+		emitter := New(
+			Server(server.URL),
+			PushEncoding(encoding),
+			TenantID("tenant-1"),
+			StaticLabels(map[string]string{"env": "test"}),
+		)
+		emitter.Emit(map[string]interface{}{
+			ecsevent.FieldTimestamp:   base.Add(time.Second),
+			ecsevent.FieldServiceName: "checkout",
+			ecsevent.FieldLogLevel:    "info",
+			ecsevent.FieldMessage:     "second",
+		})
+		emitter.Emit(map[string]interface{}{
+			ecsevent.FieldTimestamp: base,
+			"service": map[string]interface{}{
+				"name":    "checkout",
+				"version": "1.0.0",
+			},
+			"log": map[string]interface{}{
+				"level": "info",
+			},
+			ecsevent.FieldMessage: "first",
+		})
+		emitter.Emit(map[string]interface{}{
+			ecsevent.FieldTimestamp:   base,
+			ecsevent.FieldServiceName: "checkout",
+			ecsevent.FieldLogLevel:    "error",
+			ecsevent.FieldMessage:     "failure",
+		})
+		assert.NoError(emitter.Close())
+		server.Close()
+
+		assert.Equal(1, fake.requests)
+		assert.Equal([]string{"tenant-1"}, fake.tenants)
+		infoLabels := `{env="test", log_level="info", service_name="checkout"}`
+		errorLabels := `{env="test", log_level="error", service_name="checkout"}`
+		assert.Equal([]string{infoLabels, errorLabels}, fake.order)
+		assert.Equal([]pushedEntry{
+			{base, `{"@timestamp":"2020-04-01T12:00:00.0000005Z","message":"first","service":{"version":"1.0.0"}}`},
+			{base.Add(time.Second), `{"@timestamp":"2020-04-01T12:00:01.0000005Z","message":"second"}`},
+		}, normalize(fake.streams[infoLabels]))
+		assert.Len(fake.streams[errorLabels], 1)
+	}
+}
+
+// normalize strips monotonic and location data so entries compare equal.
+func normalize(entries []pushedEntry) []pushedEntry {
+	for i := range entries {
+		entries[i].timestamp = entries[i].timestamp.UTC()
+	}
+	return entries
+}
+
+func TestEmitterRetry(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeLoki{statuses: []int{http.StatusTooManyRequests, http.StatusBadGateway}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	var errs []error
+	emitter := New(
+		Server(server.URL),
+		RetryBackoff(time.Millisecond),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+	assert.Equal(3, fake.requests)
+	assert.Len(fake.streams[`{service_name="unknown_service"}`], 1)
+	assert.Empty(errs)
+
+	fake.statuses = []int{http.StatusBadRequest}
+	emitter = New(
+		Server(server.URL),
+		RetryBackoff(time.Millisecond),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+	assert.Equal(4, fake.requests)
+	assert.Len(errs, 1)
+}
+
+func TestEmitterFallbackLabel(t *testing.T) {
+	for _, encoding := range []Encoding{Protobuf, JSON} {
+		assert := assert.New(t)
+		fake := &fakeLoki{}
+		server := httptest.NewServer(fake)
+
+		var errs []error
+		emitter := New(
+			Server(server.URL),
+			PushEncoding(encoding),
+			ErrorHandler(func(err error) {
+				errs = append(errs, err)
+			}),
+		)
+		emitter.Emit(map[string]interface{}{
+			ecsevent.FieldTimestamp:   time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC),
+			ecsevent.FieldServiceName: "",
+			ecsevent.FieldMessage:     "hello world",
+		})
+		assert.NoError(emitter.Close())
+		server.Close()
+
+		assert.Empty(errs)
+		assert.Equal([]pushedEntry{
+			{
+				time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC),
+				`{"@timestamp":"2020-04-01T12:00:00Z","message":"hello world","service.name":""}`,
+			},
+		}, normalize(fake.streams[`{service_name="unknown_service"}`]))
+	}
+}
+
+func TestEmitterBuffer(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeLoki{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	var errs []error
+	emitter := New(
+		Server(server.URL),
+		BufferSize(2),
+		FlushInterval(time.Hour),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	for i := 0; i < 3; i++ {
+		emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	}
+	assert.Equal([]error{ErrBufferFull}, errs)
+	assert.NoError(emitter.Close())
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Equal(1, fake.requests)
+}
+
+func TestEmitterInvalidBatching(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeLoki{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	emitter := New(Server(server.URL), BatchSize(0), FlushInterval(0))
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Equal(1, fake.requests)
+}
+
+func TestLabelName(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("service_name", labelName(ecsevent.FieldServiceName))
+	assert.Equal("_timestamp", labelName(ecsevent.FieldTimestamp))
+	assert.Equal("_abc", labelName("1abc"))
+	assert.Equal("a1_b", labelName("a1-b"))
+}
+
+func TestWithout(t *testing.T) {
+	tcs := []struct {
+		name           string
+		input          map[string]interface{}
+		field          string
+		expectedOutput map[string]interface{}
+	}{
+		{
+			"flat",
+			map[string]interface{}{
+				ecsevent.FieldServiceName: "checkout",
+				ecsevent.FieldMessage:     "hello",
+			},
+			ecsevent.FieldServiceName,
+			map[string]interface{}{
+				ecsevent.FieldMessage: "hello",
+			},
+		},
+		{
+			"nested",
+			map[string]interface{}{
+				"service": map[string]interface{}{
+					"name":    "checkout",
+					"version": "1.0.0",
+				},
+			},
+			ecsevent.FieldServiceName,
+			map[string]interface{}{
+				"service": map[string]interface{}{
+					"version": "1.0.0",
+				},
+			},
+		},
+		{
+			"nested, last field",
+			map[string]interface{}{
+				"service": map[string]interface{}{
+					"name": "checkout",
+				},
+				ecsevent.FieldMessage: "hello",
+			},
+			ecsevent.FieldServiceName,
+			map[string]interface{}{
+				ecsevent.FieldMessage: "hello",
+			},
+		},
+		{
+			"missing",
+			map[string]interface{}{
+				ecsevent.FieldMessage: "hello",
+			},
+			ecsevent.FieldServiceName,
+			map[string]interface{}{
+				ecsevent.FieldMessage: "hello",
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			before, _ := json.Marshal(tc.input)
+			assert.Equal(tc.expectedOutput, without(tc.input, tc.field))
+			after, _ := json.Marshal(tc.input)
+			// The input must not be modified.
+			assert.Equal(string(before), string(after))
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package loki
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+type jsonPushRequest struct {
+	Streams []jsonStream `json:"streams"`
+}
+
+type jsonStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// encodeJSON renders streams as a JSON push request, with timestamps as
+// nanosecond epoch strings.
+func encodeJSON(streams []*stream) ([]byte, error) {
+	pr := jsonPushRequest{Streams: make([]jsonStream, 0, len(streams))}
+	for _, s := range streams {
+		js := jsonStream{
+			Stream: s.labelSet,
+			Values: make([][2]string, 0, len(s.entries)),
+		}
+		for _, en := range s.entries {
+			js.Values = append(js.Values, [2]string{
+				strconv.FormatInt(en.timestamp.UnixNano(), 10),
+				en.line,
+			})
+		}
+		pr.Streams = append(pr.Streams, js)
+	}
+	return json.Marshal(pr)
+}
+
+// encodeProtobuf renders streams as a logproto.PushRequest message. The
+// schema is small enough that it's encoded by hand rather than pulling in
+// Loki's generated code:
+//
+//	message PushRequest { repeated StreamAdapter streams = 1; }
+//	message StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	message EntryAdapter { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+//	message Timestamp { int64 seconds = 1; int32 nanos = 2; }
+func encodeProtobuf(streams []*stream) []byte {
+	var buf []byte
+	for _, s := range streams {
+		var sb []byte
+		sb = appendBytesField(sb, 1, []byte(s.labels))
+		for _, en := range s.entries {
+			var ts []byte
+			if seconds := en.timestamp.Unix(); seconds != 0 {
+				ts = appendVarintField(ts, 1, uint64(seconds))
+			}
+			if nanos := en.timestamp.Nanosecond(); nanos != 0 {
+				ts = appendVarintField(ts, 2, uint64(nanos))
+			}
+			var eb []byte
+			eb = appendBytesField(eb, 1, ts)
+			eb = appendBytesField(eb, 2, []byte(en.line))
+			sb = appendBytesField(sb, 2, eb)
+		}
+		buf = appendBytesField(buf, 1, sb)
+	}
+	return buf
+}
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func appendVarint(buf []byte, v uint64) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+func appendVarintField(buf []byte, field int, v uint64) []byte {
+	buf = appendVarint(buf, uint64(field)<<3|wireVarint)
+	return appendVarint(buf, v)
+}
+
+func appendBytesField(buf []byte, field int, v []byte) []byte {
+	buf = appendVarint(buf, uint64(field)<<3|wireBytes)
+	buf = appendVarint(buf, uint64(len(v)))
+	return append(buf, v...)
+}
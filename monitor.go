@@ -15,13 +15,18 @@ type Monitor interface {
 
 type syncEmitter struct {
 	emitter Emitter
+	config  EmitterConfig
+	// chain is every processor run for the emitter: its processors and
+	// filters, then those enabled by Stackdriver and NestEvents. The
+	// RootMonitor rebuilds it, under its mu, whenever they change.
+	chain []Processor
 	// mu gates events emitted since we don't expect emitters to be thread-safe
 	mu sync.Mutex
 }
 
 // Emit takes a flat map of ECS fields and values, converts it to a nested
 // map, and emits the event on the underlying logger implementation.
-func (se syncEmitter) Emit(event map[string]interface{}) {
+func (se *syncEmitter) Emit(event map[string]interface{}) {
 	se.emitter.Emit(event)
 }
 
@@ -31,6 +36,8 @@ type RootMonitor struct {
 	fields      map[string]interface{}
 	emitters    []*syncEmitter
 	tracer      opentracing.Tracer
+	sampler     Sampler
+	processors  []Processor
 	nested      bool
 	stackdriver bool
 	// mu gates everything in this struct, including changes to the emitter
@@ -43,17 +50,18 @@ var (
 	// implement the interface:
 	// https://medium.com/@matryer/c167afed3aae
 	_ Monitor = &RootMonitor{}
-	_ Emitter = syncEmitter{}
+	_ Emitter = &syncEmitter{}
 )
 
 // MonitorOption configure a RootMonitor as it's being initialized.
 type MonitorOption func(*RootMonitor)
 
 // NestEvents controls whether event fields should be nested or left
-// in dot-notated format.
+// in dot-notated format. EmitNested overrides it for a single emitter.
 func NestEvents(nested bool) MonitorOption {
 	return func(rm *RootMonitor) {
 		rm.nested = nested
+		rm.rebuildChains()
 	}
 }
 
@@ -66,6 +74,7 @@ func NestEvents(nested bool) MonitorOption {
 func Stackdriver(stackdriver bool) MonitorOption {
 	return func(rm *RootMonitor) {
 		rm.stackdriver = stackdriver
+		rm.rebuildChains()
 	}
 }
 
@@ -76,6 +85,13 @@ func Tracer(tracer opentracing.Tracer) MonitorOption {
 	}
 }
 
+// Sampling sets the Sampler deciding which events the RootMonitor emits.
+func Sampling(sampler Sampler) MonitorOption {
+	return func(rm *RootMonitor) {
+		rm.SetSampler(sampler)
+	}
+}
+
 // New creates a new RootMonitor with the given MonitorOption functions
 // applied.
 func New(opts ...MonitorOption) Monitor {
@@ -103,14 +119,49 @@ func (rm *RootMonitor) Root() *RootMonitor {
 	return rm
 }
 
-// AppendEmitter adds an emitter to the RootMonitor's emitter list.
+// AppendEmitter adds an emitter to the RootMonitor's emitter list with the
+// given EmitterOption functions applied.
 //
 // This function is intended to be used inside of a MonitorOption function
 // and generally should not be used outside of initialization.
-func (rm *RootMonitor) AppendEmitter(emitter Emitter) {
+func (rm *RootMonitor) AppendEmitter(emitter Emitter, opts ...EmitterOption) {
+	se := &syncEmitter{emitter: emitter}
+	for _, opt := range opts {
+		opt(&se.config)
+	}
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
-	rm.emitters = append(rm.emitters, &syncEmitter{emitter: emitter})
+	se.chain = rm.chain(se)
+	rm.emitters = append(rm.emitters, se)
+}
+
+// chain builds the processor chain of an emitter. Stackdriver fields are
+// derived from flat events, so its processor runs before nesting. The caller
+// must hold mu, unless the RootMonitor is still being initialized.
+func (rm *RootMonitor) chain(se *syncEmitter) []Processor {
+	chain := make([]Processor, 0, len(se.config.Processors)+len(se.config.Filters)+2)
+	chain = append(chain, se.config.Processors...)
+	chain = append(chain, se.config.Filters...)
+	if rm.stackdriver {
+		chain = append(chain, StackdriverProcessor)
+	}
+	nested := rm.nested
+	if se.config.Nested != nil {
+		nested = *se.config.Nested
+	}
+	if nested {
+		chain = append(chain, NestProcessor)
+	}
+	return chain
+}
+
+// rebuildChains rebuilds the processor chains of all emitters after a
+// change to Stackdriver or NestEvents. The caller must hold mu, unless the
+// RootMonitor is still being initialized.
+func (rm *RootMonitor) rebuildChains() {
+	for _, se := range rm.emitters {
+		se.chain = rm.chain(se)
+	}
 }
 
 // SetTracer sets the tracer for the RootMonitor. Unlike emitters, there
@@ -132,12 +183,22 @@ func (rm *RootMonitor) Tracer() opentracing.Tracer {
 	return rm.tracer
 }
 
+// SetSampler sets the Sampler deciding which events the RootMonitor emits.
+// Kept events sampled at a rate above 1 record it in event.sample_rate,
+// multiplied by any rate already there. A nil Sampler keeps every event.
+func (rm *RootMonitor) SetSampler(sampler Sampler) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.sampler = sampler
+}
+
 // SetStackdriverLogging enables or disables translation of ECS events into
 // the fields needed by Stackdriver.
 func (rm *RootMonitor) SetStackdriverLogging(enabled bool) {
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
 	rm.stackdriver = enabled
+	rm.rebuildChains()
 }
 
 // Fields returns the fields currently set on the monitor.
@@ -161,16 +222,44 @@ func (rm *RootMonitor) UpdateFields(fields map[string]interface{}) {
 
 // Record takes a series of fields and records an event.
 func (rm *RootMonitor) Record(event map[string]interface{}) {
-	for _, se := range rm.emitters {
-		se.mu.Lock()
-		// TODO: use fields
-		if rm.stackdriver {
-			event = appendStackdriver(event)
+	rm.mu.Lock()
+	sampler := rm.sampler
+	processors := rm.processors
+	emitters := rm.emitters
+	chains := make([][]Processor, len(emitters))
+	for i, se := range emitters {
+		chains[i] = se.chain
+	}
+	rm.mu.Unlock()
+	if sampler != nil {
+		keep, rate := sampler.Sample(event)
+		if !keep {
+			return
 		}
-		if rm.nested {
-			event = Nest(event)
+		if rate > 1 {
+			sampled := make(map[string]interface{}, len(event)+1)
+			for k, v := range event {
+				sampled[k] = v
+			}
+			if previous, ok := event[FieldEventSampleRate].(int); ok && previous > 1 {
+				rate *= previous
+			}
+			sampled[FieldEventSampleRate] = rate
+			event = sampled
 		}
-		se.emitter.Emit(event)
+	}
+	event, ok := process(event, processors)
+	if !ok {
+		return
+	}
+	for i, se := range emitters {
+		// TODO: use fields
+		emitted, ok := process(event, chains[i])
+		if !ok {
+			continue
+		}
+		se.mu.Lock()
+		se.emitter.Emit(emitted)
 		se.mu.Unlock()
 	}
 }
@@ -0,0 +1,78 @@
+package ndjson
+
+import (
+	"io"
+	"sync"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+// Emitter writes ECS formatted events to an io.Writer as newline delimited
+// JSON, one event per line. Keys are written in sorted order and timestamps
+// in RFC3339Nano format.
+type Emitter struct {
+	writer       io.Writer
+	flat         bool
+	errorHandler func(error)
+
+	// mu serializes writes so that lines are never interleaved.
+	mu  sync.Mutex
+	buf []byte
+}
+
+// Option configures an Emitter as it's being initialized.
+type Option func(*Emitter)
+
+// Flat writes events with dotted keys as emitted, rather than nesting them.
+func Flat() Option {
+	return func(e *Emitter) {
+		e.flat = true
+	}
+}
+
+// ErrorHandler sets a callback for errors returned by the writer, since Emit
+// has no way to return them.
+func ErrorHandler(handler func(error)) Option {
+	return func(e *Emitter) {
+		e.errorHandler = handler
+	}
+}
+
+// New creates a new Emitter writing to w with the given Option functions
+// applied.
+func New(w io.Writer, opts ...Option) *Emitter {
+	e := &Emitter{
+		writer:       w,
+		errorHandler: func(error) {},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Emit takes a map of ECS fields and values and writes it as a single line
+// of JSON, nested unless the Flat option was given.
+func (e *Emitter) Emit(event map[string]interface{}) {
+	if !e.flat {
+		event = ecsevent.Nest(event)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.buf = appendMap(e.buf[:0], event)
+	e.buf = append(e.buf, '\n')
+	if _, err := e.writer.Write(e.buf); err != nil {
+		e.errorHandler(err)
+	}
+	if cap(e.buf) > 64*1024 {
+		// Don't hold on to buffers grown by unusually large events.
+		e.buf = nil
+	}
+}
+
+var (
+	// This is a compile-time check to make sure our types correctly
+	// implement the interface:
+	// https://medium.com/@matryer/c167afed3aae
+	_ ecsevent.Emitter = &Emitter{}
+)
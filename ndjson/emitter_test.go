@@ -0,0 +1,118 @@
+package ndjson
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sporkmonger/ecsevent"
+	ecszerolog "github.com/sporkmonger/ecsevent/zerolog"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmitter(t *testing.T) {
+	tcs := []struct {
+		name           string
+		opts           []Option
+		event          map[string]interface{}
+		expectedOutput string
+	}{
+		{
+			"empty event",
+			nil,
+			map[string]interface{}{},
+			"{}\n",
+		},
+		{
+			"http event",
+			nil,
+			map[string]interface{}{
+				ecsevent.FieldTimestamp:              time.Date(2019, 10, 28, 6, 15, 7, 226113003, time.UTC),
+				ecsevent.FieldHTTPRequestMethod:      "GET",
+				ecsevent.FieldHTTPResponseStatusCode: 200,
+				ecsevent.FieldHTTPVersion:            "1.1",
+				ecsevent.FieldURLFull:                "http://example.com/hello",
+			},
+			`{"@timestamp":"2019-10-28T06:15:07.226113003Z","http":{"request":{"method":"GET"},"response":{"status_code":200},"version":"1.1"},"url":{"full":"http://example.com/hello"}}` + "\n",
+		},
+		{
+			"flat event",
+			[]Option{Flat()},
+			map[string]interface{}{
+				ecsevent.FieldMessage:  "hello world",
+				ecsevent.FieldLogLevel: "info",
+				ecsevent.FieldTags:     []string{"a", "b"},
+			},
+			`{"log.level":"info","message":"hello world","tags":["a","b"]}` + "\n",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			buffer := &bytes.Buffer{}
+			emitter := New(buffer, tc.opts...)
+			emitter.Emit(tc.event)
+			assert.Equal(tc.expectedOutput, buffer.String())
+		})
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestEmitterError(t *testing.T) {
+	assert := assert.New(t)
+	var errs []error
+	emitter := New(failingWriter{}, ErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}))
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.Len(errs, 1)
+}
+
+func benchmarkEvent() map[string]interface{} {
+	return map[string]interface{}{
+		ecsevent.FieldTimestamp:              time.Date(2019, 10, 28, 6, 15, 7, 226113003, time.UTC),
+		ecsevent.FieldEventDuration:          int64(1234567),
+		ecsevent.FieldHTTPRequestMethod:      "GET",
+		ecsevent.FieldHTTPRequestReferrer:    "http://example.com/",
+		ecsevent.FieldHTTPResponseStatusCode: 200,
+		ecsevent.FieldHTTPResponseBodyBytes:  int64(4096),
+		ecsevent.FieldHTTPVersion:            "1.1",
+		ecsevent.FieldSourceIP:               "127.0.0.1",
+		ecsevent.FieldURLDomain:              "example.com",
+		ecsevent.FieldURLFull:                "http://example.com/hello?q=1",
+		ecsevent.FieldURLPath:                "/hello",
+		ecsevent.FieldUserAgentOriginal:      "Mozilla/5.0 (X11; Linux x86_64)",
+		ecsevent.FieldTags:                   []string{"canary", "edge"},
+		ecsevent.FieldMessage:                "request completed",
+	}
+}
+
+func BenchmarkEmitter(b *testing.B) {
+	event := benchmarkEvent()
+	emitter := New(ioutil.Discard)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		emitter.Emit(event)
+	}
+}
+
+func BenchmarkZerologEmitter(b *testing.B) {
+	event := benchmarkEvent()
+	emitter := &ecszerolog.Emitter{Logger: zerolog.New(ioutil.Discard)}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		emitter.Emit(event)
+	}
+}
@@ -0,0 +1,210 @@
+package ndjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+const hex = "0123456789abcdef"
+
+// appendValue appends the JSON encoding of a value. The common ECS value
+// types are encoded directly; anything else falls back to encoding/json.
+func appendValue(buf []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case string:
+		return appendString(buf, v)
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(buf, v, 10)
+	case int32:
+		return strconv.AppendInt(buf, int64(v), 10)
+	case int16:
+		return strconv.AppendInt(buf, int64(v), 10)
+	case int8:
+		return strconv.AppendInt(buf, int64(v), 10)
+	case uint:
+		return strconv.AppendUint(buf, uint64(v), 10)
+	case uint64:
+		return strconv.AppendUint(buf, v, 10)
+	case uint32:
+		return strconv.AppendUint(buf, uint64(v), 10)
+	case uint16:
+		return strconv.AppendUint(buf, uint64(v), 10)
+	case uint8:
+		return strconv.AppendUint(buf, uint64(v), 10)
+	case float64:
+		return appendFloat(buf, v, 64)
+	case float32:
+		return appendFloat(buf, float64(v), 32)
+	case bool:
+		return strconv.AppendBool(buf, v)
+	case nil:
+		return append(buf, "null"...)
+	case time.Time:
+		buf = append(buf, '"')
+		buf = v.AppendFormat(buf, time.RFC3339Nano)
+		return append(buf, '"')
+	case time.Duration:
+		// ECS durations are nanoseconds.
+		return strconv.AppendInt(buf, int64(v), 10)
+	case []string:
+		buf = append(buf, '[')
+		for i, s := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendString(buf, s)
+		}
+		return append(buf, ']')
+	case []interface{}:
+		buf = append(buf, '[')
+		for i, elem := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendValue(buf, elem)
+		}
+		return append(buf, ']')
+	case map[string]interface{}:
+		return appendMap(buf, v)
+	case []map[string]interface{}:
+		// Subevents.
+		buf = append(buf, '[')
+		for i, m := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendMap(buf, m)
+		}
+		return append(buf, ']')
+	case map[string]string:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf = append(buf, '{')
+		for i, key := range keys {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendString(buf, key)
+			buf = append(buf, ':')
+			buf = appendString(buf, v[key])
+		}
+		return append(buf, '}')
+	case error:
+		return appendString(buf, v.Error())
+	default:
+		out := bytes.NewBuffer(buf)
+		enc := json.NewEncoder(out)
+		// Match appendString, which doesn't escape HTML characters either.
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(v); err != nil {
+			return appendString(buf, err.Error())
+		}
+		return bytes.TrimSuffix(out.Bytes(), []byte{'\n'})
+	}
+}
+
+// appendMap appends a JSON object with its keys in sorted order, so that
+// output is deterministic.
+func appendMap(buf []byte, m map[string]interface{}) []byte {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	buf = append(buf, '{')
+	for i, key := range keys {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendString(buf, key)
+		buf = append(buf, ':')
+		buf = appendValue(buf, m[key])
+	}
+	return append(buf, '}')
+}
+
+// appendFloat appends a float the way encoding/json does. NaN and infinities
+// aren't valid JSON, so they're written as strings.
+func appendFloat(buf []byte, f float64, bits int) []byte {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		buf = append(buf, '"')
+		buf = strconv.AppendFloat(buf, f, 'f', -1, bits)
+		return append(buf, '"')
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	buf = strconv.AppendFloat(buf, f, format, -1, bits)
+	if format == 'e' {
+		// Clean up e-09 to e-9, as encoding/json does.
+		n := len(buf)
+		if n >= 4 && buf[n-4] == 'e' && buf[n-3] == '-' && buf[n-2] == '0' {
+			buf[n-2] = buf[n-1]
+			buf = buf[:n-1]
+		}
+	}
+	return buf
+}
+
+// appendString appends a quoted JSON string. Unlike encoding/json, HTML
+// characters aren't escaped. Invalid UTF-8 is replaced with U+FFFD.
+func appendString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				buf = append(buf, '\\', b)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 are valid JSON but break JavaScript parsers.
+		if r == '\u2028' || r == '\u2029' {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, '\\', 'u', '2', '0', '2', hex[r&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
@@ -0,0 +1,84 @@
+package ndjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendValue(t *testing.T) {
+	tcs := []struct {
+		name           string
+		value          interface{}
+		expectedOutput string
+	}{
+		{"string", "hello world", `"hello world"`},
+		{"escaped string", "a\"b\\c\nd\te\x01", `"a\"b\\c\nd\te\u0001"`},
+		{"html", "<a href=\"x\">&</a>", `"<a href=\"x\">&</a>"`},
+		{"invalid utf-8", "a\xffb", `"a\ufffdb"`},
+		{"line separator", "a\u2028b\u2029", `"a\u2028b\u2029"`},
+		{"unicode", "héllo 世界", `"héllo 世界"`},
+		{"int", 200, `200`},
+		{"int64", int64(-42), `-42`},
+		{"uint8", uint8(7), `7`},
+		{"float", 1.5, `1.5`},
+		{"small float", 0.0000001, `1e-7`},
+		{"large float", 1e21, `1e+21`},
+		{"float32", float32(0.1), `0.1`},
+		{"nan", math.NaN(), `"NaN"`},
+		{"bool", true, `true`},
+		{"nil", nil, `null`},
+		{"time", time.Date(2019, 10, 28, 6, 15, 7, 226113003, time.UTC), `"2019-10-28T06:15:07.226113003Z"`},
+		{"duration", 50 * time.Millisecond, `50000000`},
+		{"strings", []string{"a", "b"}, `["a","b"]`},
+		{"empty strings", []string{}, `[]`},
+		{"interfaces", []interface{}{"a", 1, nil}, `["a",1,null]`},
+		{"map", map[string]interface{}{"b": 1, "a": map[string]interface{}{"d": "x", "c": true}}, `{"a":{"c":true,"d":"x"},"b":1}`},
+		{"string map", map[string]string{"b": "2", "a": "1"}, `{"a":"1","b":"2"}`},
+		{"subevents", []map[string]interface{}{{"b": "<&>", "a": 1}, {}}, `[{"a":1,"b":"<&>"},{}]`},
+		{"error", errors.New("boom"), `"boom"`},
+		{"fallback", struct {
+			Name string `json:"name"`
+		}{"x"}, `{"name":"x"}`},
+		{"html fallback", struct {
+			Name string `json:"name"`
+		}{"<&>"}, `{"name":"<&>"}`},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			output := string(appendValue(nil, tc.value))
+			assert.Equal(tc.expectedOutput, output)
+			var decoded interface{}
+			assert.NoError(json.Unmarshal([]byte(output), &decoded))
+		})
+	}
+}
+
+func TestAppendValueMatchesEncodingJSON(t *testing.T) {
+	assert := assert.New(t)
+	values := []interface{}{
+		"hello\x00world\u2028",
+		"\x7f\x1f",
+		3.14159,
+		-0.000001,
+		1e20,
+		123456789.0,
+		float32(3.4e38),
+		map[string]interface{}{"z": []interface{}{1.0, "x"}, "a": nil},
+	}
+	for _, value := range values {
+		buf := &bytes.Buffer{}
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(false)
+		assert.NoError(enc.Encode(value))
+		assert.Equal(strings.TrimSuffix(buf.String(), "\n"), string(appendValue(nil, value)))
+	}
+}
@@ -3,6 +3,7 @@ package ecsevent
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 // Nest converts a map from dotted notation to a fully nested representation.
@@ -48,3 +49,40 @@ func Unnest(entry map[string]interface{}) map[string]interface{} {
 	}
 	return newEntry
 }
+
+// Lookup retrieves a field from an event using its dotted ECS name. The event
+// may be either flat, nested, or a mix of the two.
+func Lookup(entry map[string]interface{}, key string) (interface{}, bool) {
+	if value, ok := entry[key]; ok {
+		return value, true
+	}
+	// Try every split point so that partially nested events resolve too,
+	// e.g. {"http.request": {"method": "GET"}}.
+	for i := strings.IndexByte(key, '.'); i != -1; {
+		if child, ok := entry[key[:i]].(map[string]interface{}); ok {
+			if value, ok := Lookup(child, key[i+1:]); ok {
+				return value, true
+			}
+		}
+		next := strings.IndexByte(key[i+1:], '.')
+		if next == -1 {
+			break
+		}
+		i += next + 1
+	}
+	return nil, false
+}
+
+// Timestamp returns the event's @timestamp, accepting either a time.Time or
+// an RFC 3339 string. If the event has no usable timestamp, the zero time is
+// returned.
+func Timestamp(entry map[string]interface{}) time.Time {
+	switch value := entry[FieldTimestamp].(type) {
+	case time.Time:
+		return value
+	case string:
+		timestamp, _ := time.Parse(time.RFC3339Nano, value)
+		return timestamp
+	}
+	return time.Time{}
+}
@@ -2,6 +2,7 @@ package ecsevent
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -205,3 +206,78 @@ func BenchmarkUnnest(b *testing.B) {
 		Unnest(input)
 	}
 }
+
+func TestLookup(t *testing.T) {
+	tcs := []struct {
+		name          string
+		input         map[string]interface{}
+		key           string
+		expectedValue interface{}
+		expectedOK    bool
+	}{
+		{
+			"flat",
+			map[string]interface{}{
+				FieldHTTPRequestMethod: "GET",
+			},
+			FieldHTTPRequestMethod,
+			"GET",
+			true,
+		},
+		{
+			"nested",
+			map[string]interface{}{
+				"http": map[string]interface{}{
+					"request": map[string]interface{}{
+						"method": "GET",
+					},
+				},
+			},
+			FieldHTTPRequestMethod,
+			"GET",
+			true,
+		},
+		{
+			"partially nested",
+			map[string]interface{}{
+				"http.request": map[string]interface{}{
+					"method": "GET",
+				},
+			},
+			FieldHTTPRequestMethod,
+			"GET",
+			true,
+		},
+		{
+			"missing",
+			map[string]interface{}{
+				"http": map[string]interface{}{
+					"version": "1.1",
+				},
+			},
+			FieldHTTPRequestMethod,
+			nil,
+			false,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			value, ok := Lookup(tc.input, tc.key)
+			assert.Equal(tc.expectedOK, ok)
+			assert.Equal(tc.expectedValue, value)
+		})
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	assert.Equal(now, Timestamp(map[string]interface{}{FieldTimestamp: now}))
+	assert.Equal(
+		time.Date(2019, 10, 28, 6, 15, 7, 226113003, time.UTC),
+		Timestamp(map[string]interface{}{FieldTimestamp: "2019-10-28T06:15:07.226113003Z"}),
+	)
+	assert.True(Timestamp(map[string]interface{}{}).IsZero())
+}
@@ -0,0 +1,53 @@
+package ecsevent
+
+// Processor transforms an event on its way from a RootMonitor to its
+// emitters, e.g. to enrich, filter or redact it. It returns the event to pass
+// on, or false to drop it. Processors are given flat events, unless an
+// earlier processor nested them, and must not modify the event they're given;
+// they return a new map instead, since the same event is passed to every
+// emitter.
+type Processor func(event map[string]interface{}) (map[string]interface{}, bool)
+
+// NestProcessor converts an event to its nested representation. It's what
+// NestEvents enables, as the last processor run for each emitter.
+func NestProcessor(event map[string]interface{}) (map[string]interface{}, bool) {
+	return Nest(event), true
+}
+
+// StackdriverProcessor adds the special fields Stackdriver expects to a
+// flat event, leaving the original ECS fields in place. It's what
+// Stackdriver enables, run for each emitter before nesting.
+func StackdriverProcessor(event map[string]interface{}) (map[string]interface{}, bool) {
+	return appendStackdriver(event), true
+}
+
+// process runs an event through processors in order.
+func process(event map[string]interface{}, processors []Processor) (map[string]interface{}, bool) {
+	for _, processor := range processors {
+		var ok bool
+		event, ok = processor(event)
+		if !ok {
+			return nil, false
+		}
+	}
+	return event, true
+}
+
+// Processors appends processors run on every event the RootMonitor records,
+// before each emitter's own processors.
+func Processors(processors ...Processor) MonitorOption {
+	return func(rm *RootMonitor) {
+		rm.AppendProcessors(processors...)
+	}
+}
+
+// AppendProcessors appends processors run on every event the RootMonitor
+// records.
+//
+// This function is intended to be used inside of a MonitorOption function
+// and generally should not be used outside of initialization.
+func (rm *RootMonitor) AppendProcessors(processors ...Processor) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.processors = append(rm.processors, processors...)
+}
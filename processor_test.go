@@ -0,0 +1,105 @@
+package ecsevent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessors(t *testing.T) {
+	assert := assert.New(t)
+	addService := func(event map[string]interface{}) (map[string]interface{}, bool) {
+		newEvent := map[string]interface{}{FieldServiceName: "checkout"}
+		for k, v := range event {
+			newEvent[k] = v
+		}
+		return newEvent, true
+	}
+	dropDebug := func(event map[string]interface{}) (map[string]interface{}, bool) {
+		return event, event[FieldLogLevel] != "debug"
+	}
+	var seen []map[string]interface{}
+	spy := func(event map[string]interface{}) (map[string]interface{}, bool) {
+		seen = append(seen, event)
+		return event, true
+	}
+
+	all := &mockEmitter{}
+	filtered := &mockEmitter{}
+	rm := NewRootMonitor(
+		Processors(addService),
+		Stackdriver(true),
+	)
+	rm.AppendEmitter(all, EmitterProcessors(spy))
+	rm.AppendEmitter(filtered, EmitterProcessors(dropDebug))
+
+	rm.Record(map[string]interface{}{FieldLogLevel: "debug", FieldMessage: "verbose"})
+	rm.Record(map[string]interface{}{FieldLogLevel: "error", FieldMessage: "boom"})
+
+	// Emitter processors see flat events; Stackdriver and nesting run last.
+	if assert.Len(seen, 2) {
+		assert.Equal(map[string]interface{}{
+			FieldServiceName: "checkout",
+			FieldLogLevel:    "debug",
+			FieldMessage:     "verbose",
+		}, seen[0])
+	}
+	assert.Len(all.events, 2)
+	if assert.Len(filtered.events, 1) {
+		assert.Equal(map[string]interface{}{
+			"service":  map[string]interface{}{"name": "checkout"},
+			"log":      map[string]interface{}{"level": "error"},
+			"message":  "boom",
+			"severity": "ERROR",
+		}, filtered.events[0])
+	}
+}
+
+func TestProcessorsOutputChain(t *testing.T) {
+	assert := assert.New(t)
+	nested := &mockEmitter{}
+	flat := &mockEmitter{}
+	// Emitters appended before NestEvents still get its processor.
+	rm := NewRootMonitor(
+		EmitToMock(nested),
+		NestEvents(false),
+		NestEvents(true),
+	)
+	rm.AppendEmitter(flat, EmitNested(false))
+
+	rm.Record(map[string]interface{}{FieldLogLevel: "error"})
+	rm.SetStackdriverLogging(true)
+	rm.Record(map[string]interface{}{FieldLogLevel: "error"})
+
+	assert.Equal([]map[string]interface{}{
+		{"log": map[string]interface{}{"level": "error"}},
+		{"log": map[string]interface{}{"level": "error"}, "severity": "ERROR"},
+	}, nested.events)
+	assert.Equal([]map[string]interface{}{
+		{FieldLogLevel: "error"},
+		{FieldLogLevel: "error", "severity": "ERROR"},
+	}, flat.events)
+}
+
+func TestProcessorsDrop(t *testing.T) {
+	assert := assert.New(t)
+	mock := &mockEmitter{}
+	rm := NewRootMonitor(EmitToMock(mock))
+	rm.AppendProcessors(func(event map[string]interface{}) (map[string]interface{}, bool) {
+		return nil, false
+	})
+	rm.Record(map[string]interface{}{FieldMessage: "dropped"})
+	assert.Len(mock.events, 0)
+}
+
+func TestBuiltinProcessors(t *testing.T) {
+	assert := assert.New(t)
+	event := map[string]interface{}{FieldLogLevel: "warn"}
+	nested, ok := NestProcessor(event)
+	assert.True(ok)
+	assert.Equal(map[string]interface{}{"log": map[string]interface{}{"level": "warn"}}, nested)
+	withStackdriver, ok := StackdriverProcessor(event)
+	assert.True(ok)
+	assert.Equal("WARNING", withStackdriver["severity"])
+	assert.Equal(map[string]interface{}{FieldLogLevel: "warn"}, event)
+}
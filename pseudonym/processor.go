@@ -0,0 +1,310 @@
+package pseudonym
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+// DefaultKeyIDField is the field recording the ID of the key digests were
+// computed with.
+const DefaultKeyIDField = "labels.pseudonym_key_id"
+
+const (
+	defaultIPv4Prefix = 24
+	defaultIPv6Prefix = 48
+)
+
+var defaultFields = []string{
+	ecsevent.FieldUserID,
+	ecsevent.FieldUserEmail,
+}
+
+var defaultTruncatedIPs = []string{
+	ecsevent.FieldClientIP,
+	ecsevent.FieldSourceIP,
+}
+
+// ErrEmptySecret is returned when a key has no secret, which would make
+// digests trivially reversible by anyone guessing the values.
+var ErrEmptySecret = errors.New("pseudonym key secret is empty")
+
+// ErrInvalidPrefix is returned when an IPPrefixes length is out of range for
+// its address family.
+var ErrInvalidPrefix = errors.New("pseudonym IP prefix length is out of range")
+
+// hashFields are the user.hash fields ECS defines, one for each place a
+// user can be nested.
+var hashFields = map[string]bool{
+	ecsevent.FieldUserHash:            true,
+	ecsevent.FieldClientUserHash:      true,
+	ecsevent.FieldDestinationUserHash: true,
+	ecsevent.FieldHostUserHash:        true,
+	ecsevent.FieldServerUserHash:      true,
+	ecsevent.FieldSourceUserHash:      true,
+}
+
+// Key is a secret HMAC key and the ID identifying it.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Pseudonymizer replaces identifying fields with HMAC-SHA256 digests, so
+// that they stay stable for correlation but can't be reversed without the
+// key. Other fields are replaced by their digest in place. The ID of the key
+// used is recorded with the event, so that digests remain comparable across
+// key rotations.
+//
+// User fields, e.g. user.id or client.user.email, are removed and their
+// digest is written to the matching ECS user.hash field instead. There's
+// only one user.hash per user, so if several fields of the same user are
+// present, the first configured one gets user.hash and the others are
+// replaced by their digest in place: by default, an event with both user.id
+// and user.email gets the digest of user.id in user.hash and the digest of
+// user.email in user.email.
+//
+// Fields are matched on their full dotted name, whether the event is flat or
+// has nested maps, e.g. {"user": {"email": ...}}.
+//
+// IP address fields, client.ip and source.ip by default, are truncated to
+// their network prefix rather than replaced with digests, which keeps them
+// valid IP addresses for backends that index them as such.
+type Pseudonymizer struct {
+	fields     []string
+	truncated  map[string]bool
+	keyIDField string
+	ipv4Prefix int
+	ipv6Prefix int
+	ipv4Mask   net.IPMask
+	ipv6Mask   net.IPMask
+
+	mu  sync.RWMutex
+	key Key
+}
+
+// Option configures a Pseudonymizer as it's being initialized.
+type Option func(*Pseudonymizer)
+
+// Fields sets the fields to replace with digests. Defaults to user.id and
+// user.email, which share user.hash, see Pseudonymizer.
+func Fields(fields ...string) Option {
+	return func(p *Pseudonymizer) {
+		p.fields = fields
+	}
+}
+
+// TruncateIPs sets the IP address fields truncated to their network prefix
+// instead of being replaced with digests. Defaults to client.ip and
+// source.ip; with no fields, IP addresses are only replaced if listed in
+// Fields. IPv4 addresses are truncated to /24 and IPv6 addresses to /48
+// unless IPPrefixes says otherwise.
+func TruncateIPs(fields ...string) Option {
+	return func(p *Pseudonymizer) {
+		p.truncated = make(map[string]bool, len(fields))
+		for _, field := range fields {
+			p.truncated[field] = true
+		}
+	}
+}
+
+// IPPrefixes sets the prefix lengths TruncateIPs keeps, from 0 to 32 for
+// IPv4 and from 0 to 128 for IPv6. Defaults to 24 for IPv4 and 48 for IPv6.
+func IPPrefixes(ipv4, ipv6 int) Option {
+	return func(p *Pseudonymizer) {
+		p.ipv4Prefix = ipv4
+		p.ipv6Prefix = ipv6
+	}
+}
+
+// KeyIDField sets the field recording the key ID. Defaults to
+// labels.pseudonym_key_id.
+func KeyIDField(field string) Option {
+	return func(p *Pseudonymizer) {
+		p.keyIDField = field
+	}
+}
+
+// New creates a new Pseudonymizer computing digests with the key with the
+// given Option functions applied. Add its Process method to a RootMonitor
+// with ecsevent.Processors. It returns ErrEmptySecret if the key has no
+// secret and ErrInvalidPrefix if IPPrefixes is out of range.
+func New(key Key, opts ...Option) (*Pseudonymizer, error) {
+	if len(key.Secret) == 0 {
+		return nil, ErrEmptySecret
+	}
+	truncated := make(map[string]bool, len(defaultTruncatedIPs))
+	for _, field := range defaultTruncatedIPs {
+		truncated[field] = true
+	}
+	p := &Pseudonymizer{
+		fields:     defaultFields,
+		truncated:  truncated,
+		keyIDField: DefaultKeyIDField,
+		ipv4Prefix: defaultIPv4Prefix,
+		ipv6Prefix: defaultIPv6Prefix,
+		key:        key,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.ipv4Prefix < 0 || p.ipv4Prefix > 8*net.IPv4len ||
+		p.ipv6Prefix < 0 || p.ipv6Prefix > 8*net.IPv6len {
+		return nil, ErrInvalidPrefix
+	}
+	p.ipv4Mask = net.CIDRMask(p.ipv4Prefix, 8*net.IPv4len)
+	p.ipv6Mask = net.CIDRMask(p.ipv6Prefix, 8*net.IPv6len)
+	return p, nil
+}
+
+// Rotate replaces the key digests are computed with. It returns
+// ErrEmptySecret, keeping the current key, if the new key has no secret.
+func (p *Pseudonymizer) Rotate(key Key) error {
+	if len(key.Secret) == 0 {
+		return ErrEmptySecret
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.key = key
+	return nil
+}
+
+// Process is an ecsevent.Processor pseudonymizing an event and its
+// subevents.
+func (p *Pseudonymizer) Process(event map[string]interface{}) (map[string]interface{}, bool) {
+	p.mu.RLock()
+	key := p.key
+	p.mu.RUnlock()
+	return p.pseudonymize(event, key), true
+}
+
+func (p *Pseudonymizer) pseudonymize(event map[string]interface{}, key Key) map[string]interface{} {
+	present := map[string]bool{}
+	collect(event, "", present)
+	// owners maps each user.hash field to the first configured field of that
+	// user present in the event, the one whose digest it gets.
+	owners := map[string]string{}
+	for _, field := range p.fields {
+		if !present[field] || p.truncated[field] {
+			continue
+		}
+		if hashField, ok := userHashField(field); ok && owners[hashField] == "" {
+			owners[hashField] = field
+		}
+	}
+
+	hashed := false
+	result := p.walk(event, "", key, owners, &hashed)
+	if subevents, ok := event[ecsevent.FieldEventSubevents].([]map[string]interface{}); ok {
+		pseudonymized := make([]map[string]interface{}, len(subevents))
+		for i, subevent := range subevents {
+			pseudonymized[i] = p.pseudonymize(subevent, key)
+		}
+		result[ecsevent.FieldEventSubevents] = pseudonymized
+	}
+	if hashed && p.keyIDField != "" {
+		result[p.keyIDField] = key.ID
+	}
+	return result
+}
+
+// walk returns a pseudonymized copy of a map whose keys are prefixed by
+// prefix in the event, descending into nested maps.
+func (p *Pseudonymizer) walk(m map[string]interface{}, prefix string, key Key, owners map[string]string, hashed *bool) map[string]interface{} {
+	result := make(map[string]interface{}, len(m)+1)
+	for k, v := range m {
+		if _, ok := result[k]; ok {
+			// Already written as a user.hash field.
+			continue
+		}
+		field := prefix + k
+		if nested, ok := v.(map[string]interface{}); ok {
+			result[k] = p.walk(nested, field+".", key, owners, hashed)
+			continue
+		}
+		if p.truncated[field] {
+			result[k] = p.truncate(v)
+			continue
+		}
+		if !p.isField(field) {
+			result[k] = v
+			continue
+		}
+		*hashed = true
+		digest := digest(key.Secret, v)
+		if hashField, ok := userHashField(field); ok && owners[hashField] == field {
+			// The hash field shares the prefix of the user field.
+			result[hashField[len(prefix):]] = digest
+			continue
+		}
+		result[k] = digest
+	}
+	return result
+}
+
+// isField reports whether a field is replaced with its digest.
+func (p *Pseudonymizer) isField(field string) bool {
+	for _, f := range p.fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// collect records the full dotted names of the fields of a map, descending
+// into nested maps.
+func collect(m map[string]interface{}, prefix string, present map[string]bool) {
+	for k, v := range m {
+		if nested, ok := v.(map[string]interface{}); ok {
+			collect(nested, prefix+k+".", present)
+			continue
+		}
+		present[prefix+k] = true
+	}
+}
+
+// truncate masks an IP address to its network prefix. Values that aren't IP
+// addresses are left untouched.
+func (p *Pseudonymizer) truncate(value interface{}) interface{} {
+	s, ok := value.(string)
+	if !ok {
+		return value
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return value
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(p.ipv4Mask).String()
+	}
+	return ip.Mask(p.ipv6Mask).String()
+}
+
+// userHashField returns the ECS user.hash field for a user field, e.g.
+// client.user.hash for client.user.email.
+func userHashField(field string) (string, bool) {
+	i := strings.LastIndex(field, "user.")
+	if i == -1 || (i > 0 && field[i-1] != '.') {
+		return "", false
+	}
+	hashField := field[:i] + "user.hash"
+	if hashField == field || !hashFields[hashField] {
+		return "", false
+	}
+	return hashField, true
+}
+
+// digest returns the hex HMAC-SHA256 of the value's string form.
+func digest(secret []byte, value interface{}) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(fmt.Sprint(value)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
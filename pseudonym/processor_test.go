@@ -0,0 +1,190 @@
+package pseudonym
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+func hmacHex(secret, value string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestPseudonymizer(t *testing.T) {
+	key := Key{ID: "2019-10", Secret: []byte("s3cret")}
+	tcs := []struct {
+		name           string
+		opts           []Option
+		event          map[string]interface{}
+		expectedOutput map[string]interface{}
+	}{
+		{
+			"defaults",
+			nil,
+			map[string]interface{}{
+				ecsevent.FieldUserID:    42,
+				ecsevent.FieldUserEmail: "alice@example.com",
+				ecsevent.FieldClientIP:  "192.0.2.10",
+				ecsevent.FieldMessage:   "logged in",
+			},
+			map[string]interface{}{
+				ecsevent.FieldUserHash:  hmacHex("s3cret", "42"),
+				ecsevent.FieldUserEmail: hmacHex("s3cret", "alice@example.com"),
+				ecsevent.FieldClientIP:  "192.0.2.0",
+				ecsevent.FieldMessage:   "logged in",
+				DefaultKeyIDField:       "2019-10",
+			},
+		},
+		{
+			"nested maps",
+			nil,
+			map[string]interface{}{
+				"user": map[string]interface{}{
+					"email": "alice@example.com",
+					"name":  "alice",
+				},
+				"client": map[string]interface{}{
+					"ip": "192.0.2.10",
+				},
+			},
+			map[string]interface{}{
+				"user": map[string]interface{}{
+					"hash": hmacHex("s3cret", "alice@example.com"),
+					"name": "alice",
+				},
+				"client": map[string]interface{}{
+					"ip": "192.0.2.0",
+				},
+				DefaultKeyIDField: "2019-10",
+			},
+		},
+		{
+			"nested users",
+			[]Option{Fields(ecsevent.FieldClientUserEmail, ecsevent.FieldUserName), KeyIDField("")},
+			map[string]interface{}{
+				ecsevent.FieldClientUserEmail: "alice@example.com",
+				ecsevent.FieldUserName:        "bob",
+			},
+			map[string]interface{}{
+				ecsevent.FieldClientUserHash: hmacHex("s3cret", "alice@example.com"),
+				ecsevent.FieldUserHash:       hmacHex("s3cret", "bob"),
+			},
+		},
+		{
+			"truncated ips",
+			[]Option{TruncateIPs(ecsevent.FieldClientIP, ecsevent.FieldSourceIP)},
+			map[string]interface{}{
+				ecsevent.FieldClientIP: "192.0.2.10",
+				ecsevent.FieldSourceIP: "2001:db8:1234:5678::1",
+			},
+			map[string]interface{}{
+				ecsevent.FieldClientIP: "192.0.2.0",
+				ecsevent.FieldSourceIP: "2001:db8:1234::",
+			},
+		},
+		{
+			"hashed ips",
+			[]Option{TruncateIPs(), Fields(ecsevent.FieldClientIP)},
+			map[string]interface{}{
+				ecsevent.FieldClientIP: "192.0.2.10",
+				ecsevent.FieldSourceIP: "192.0.2.10",
+			},
+			map[string]interface{}{
+				ecsevent.FieldClientIP: hmacHex("s3cret", "192.0.2.10"),
+				ecsevent.FieldSourceIP: "192.0.2.10",
+				DefaultKeyIDField:      "2019-10",
+			},
+		},
+		{
+			"custom prefixes",
+			[]Option{TruncateIPs(ecsevent.FieldClientIP), IPPrefixes(16, 32), Fields()},
+			map[string]interface{}{
+				ecsevent.FieldClientIP: "192.0.2.10",
+			},
+			map[string]interface{}{
+				ecsevent.FieldClientIP: "192.0.0.0",
+			},
+		},
+		{
+			"subevents",
+			[]Option{Fields(ecsevent.FieldUserID)},
+			map[string]interface{}{
+				ecsevent.FieldEventSubevents: []map[string]interface{}{
+					{ecsevent.FieldUserID: "u1"},
+				},
+			},
+			map[string]interface{}{
+				ecsevent.FieldEventSubevents: []map[string]interface{}{
+					{ecsevent.FieldUserHash: hmacHex("s3cret", "u1"), DefaultKeyIDField: "2019-10"},
+				},
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			p, err := New(key, tc.opts...)
+			if !assert.NoError(err) {
+				return
+			}
+			output, ok := p.Process(tc.event)
+			assert.True(ok)
+			assert.Equal(tc.expectedOutput, output)
+		})
+	}
+}
+
+func TestPseudonymizerRotate(t *testing.T) {
+	assert := assert.New(t)
+	var emitted []map[string]interface{}
+	p, err := New(Key{ID: "1", Secret: []byte("one")})
+	assert.NoError(err)
+	rm := ecsevent.NewRootMonitor(ecsevent.NestEvents(false), ecsevent.Processors(p.Process))
+	rm.AppendEmitter(emitterFunc(func(event map[string]interface{}) {
+		emitted = append(emitted, event)
+	}))
+	event := map[string]interface{}{ecsevent.FieldUserID: "u1"}
+	rm.Record(event)
+	assert.Equal(ErrEmptySecret, p.Rotate(Key{ID: "3"}))
+	assert.NoError(p.Rotate(Key{ID: "2", Secret: []byte("two")}))
+	rm.Record(event)
+	if assert.Len(emitted, 2) {
+		assert.Equal(hmacHex("one", "u1"), emitted[0][ecsevent.FieldUserHash])
+		assert.Equal("1", emitted[0][DefaultKeyIDField])
+		assert.Equal(hmacHex("two", "u1"), emitted[1][ecsevent.FieldUserHash])
+		assert.Equal("2", emitted[1][DefaultKeyIDField])
+	}
+	assert.Equal(map[string]interface{}{ecsevent.FieldUserID: "u1"}, event)
+}
+
+func TestNewInvalidPrefix(t *testing.T) {
+	key := Key{ID: "1", Secret: []byte("one")}
+	for _, prefixes := range [][2]int{{-1, 48}, {33, 48}, {24, -1}, {24, 129}} {
+		p, err := New(key, IPPrefixes(prefixes[0], prefixes[1]))
+		assert.Nil(t, p, "%v", prefixes)
+		assert.Equal(t, ErrInvalidPrefix, err, "%v", prefixes)
+	}
+	_, err := New(key, IPPrefixes(32, 128))
+	assert.NoError(t, err)
+}
+
+func TestNewEmptySecret(t *testing.T) {
+	assert := assert.New(t)
+	p, err := New(Key{ID: "1"})
+	assert.Nil(p)
+	assert.Equal(ErrEmptySecret, err)
+}
+
+type emitterFunc func(map[string]interface{})
+
+func (f emitterFunc) Emit(event map[string]interface{}) {
+	f(event)
+}
@@ -0,0 +1,386 @@
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+// Mode is what happens to sensitive data.
+type Mode int
+
+const (
+	// Mask replaces sensitive data with Placeholder.
+	Mask Mode = iota
+	// Hash replaces sensitive data with the hex SHA-256 digest of its
+	// string form, so that equal values can still be correlated. Use a keyed
+	// hash instead if the values could be guessed.
+	Hash
+	// Drop removes the field, or query parameter, holding sensitive data.
+	Drop
+)
+
+// Placeholder replaces masked data.
+const Placeholder = "[REDACTED]"
+
+// queryPlaceholder replaces masked query parameter values, which must not
+// need escaping.
+const queryPlaceholder = "REDACTED"
+
+// Patterns for common sensitive values, for use with Values.
+var (
+	// CreditCards matches payment card numbers of 13 to 19 digits,
+	// optionally separated by spaces or dashes, that pass the Luhn check.
+	// It's not applied by default, since about 1 in 10 long numbers, e.g.
+	// IDs, pass the check.
+	CreditCards = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+	// GroupedCreditCards matches payment card numbers written in groups
+	// separated by spaces or dashes, e.g. 4111 1111 1111 1111, that pass the
+	// Luhn check.
+	GroupedCreditCards = regexp.MustCompile(`\b(?:\d{4}[ -]\d{6}[ -]\d{5}|\d{4}(?:[ -]\d{4}){2}[ -]\d{1,7})\b`)
+	// JWTs matches JSON Web Tokens.
+	JWTs = regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	// BearerTokens matches bearer tokens, e.g. in an Authorization header.
+	BearerTokens = regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`)
+	// Emails matches email addresses.
+	Emails = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+)
+
+// defaultQueryParams are the query parameters masked by default.
+var defaultQueryParams = []string{
+	"access_token", "api_key", "apikey", "auth", "code", "key", "password",
+	"secret", "sig", "signature", "token",
+}
+
+// urlFields are the fields query parameter rules apply to.
+var urlFields = []string{
+	ecsevent.FieldURLQuery,
+	ecsevent.FieldURLFull,
+	ecsevent.FieldURLOriginal,
+}
+
+type fieldRule struct {
+	mode     Mode
+	patterns []string
+}
+
+type valueRule struct {
+	mode    Mode
+	pattern *regexp.Regexp
+}
+
+type queryRule struct {
+	mode  Mode
+	names []string
+}
+
+type processor struct {
+	fields  []fieldRule
+	values  []valueRule
+	queries []queryRule
+}
+
+// Option configures the redaction processor as it's being initialized.
+type Option func(*processor)
+
+// Fields redacts the fields with names matching any of the glob patterns,
+// e.g. "url.password" or "*.token". Patterns use path.Match syntax, where
+// '*' also matches dots.
+func Fields(mode Mode, patterns ...string) Option {
+	return func(p *processor) {
+		p.fields = append(p.fields, fieldRule{mode: mode, patterns: patterns})
+	}
+}
+
+// Values redacts the parts of string values matching any of the patterns,
+// including those in lists and maps. With Drop, the whole field, or the list
+// element or map entry, is removed.
+func Values(mode Mode, patterns ...*regexp.Regexp) Option {
+	return func(p *processor) {
+		for _, pattern := range patterns {
+			p.values = append(p.values, valueRule{mode: mode, pattern: pattern})
+		}
+	}
+}
+
+// QueryParams redacts the values of the named query parameters, compared
+// case-insensitively, in url.query, url.full and url.original. With Drop,
+// the parameters are removed.
+func QueryParams(mode Mode, names ...string) Option {
+	lower := make([]string, len(names))
+	for i, name := range names {
+		lower[i] = strings.ToLower(name)
+	}
+	return func(p *processor) {
+		p.queries = append(p.queries, queryRule{mode: mode, names: lower})
+	}
+}
+
+// New creates a processor redacting sensitive data from events with the
+// given Option functions applied. Rules on field names apply first, then
+// query parameter rules, then rules on values; subevents are redacted too.
+//
+// Without options, url.password, http.request.body.content, user.email and
+// any field ending in password, secret or token are masked, as are grouped
+// credit card numbers, JWTs, bearer tokens and email addresses in any value
+// and common credential query parameters such as token or api_key.
+func New(opts ...Option) ecsevent.Processor {
+	p := &processor{}
+	if len(opts) == 0 {
+		opts = []Option{
+			Fields(Mask,
+				ecsevent.FieldURLPassword,
+				ecsevent.FieldHTTPRequestBodyContent,
+				ecsevent.FieldUserEmail,
+				"*password", "*secret", "*token",
+			),
+			Values(Mask, GroupedCreditCards, JWTs, BearerTokens, Emails),
+			QueryParams(Mask, defaultQueryParams...),
+		}
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p.process
+}
+
+func (p *processor) process(event map[string]interface{}) (map[string]interface{}, bool) {
+	return p.redact(event), true
+}
+
+// redact returns a redacted copy of a flat event.
+func (p *processor) redact(event map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(event))
+	for key, value := range event {
+		if key == ecsevent.FieldEventSubevents {
+			if subevents, ok := value.([]map[string]interface{}); ok {
+				redactedSubevents := make([]map[string]interface{}, len(subevents))
+				for i, subevent := range subevents {
+					redactedSubevents[i] = p.redact(subevent)
+				}
+				redacted[key] = redactedSubevents
+				continue
+			}
+		}
+		if value, ok := p.redactField(key, value); ok {
+			redacted[key] = value
+		}
+	}
+	return redacted
+}
+
+// redactField returns the redacted value of a field, or false if the field
+// is dropped. Lists and maps are redacted element by element, the keys of
+// maps extending the field name, e.g. http.request.headers.authorization.
+func (p *processor) redactField(key string, value interface{}) (interface{}, bool) {
+	for _, rule := range p.fields {
+		for _, pattern := range rule.patterns {
+			if matched, _ := path.Match(pattern, key); matched {
+				return apply(rule.mode, value, Placeholder)
+			}
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		return p.redactString(key, v)
+	case []string:
+		redacted := make([]string, 0, len(v))
+		for _, elem := range v {
+			if elem, ok := p.redactString(key, elem); ok {
+				redacted = append(redacted, elem.(string))
+			}
+		}
+		return redacted, true
+	case []interface{}:
+		redacted := make([]interface{}, 0, len(v))
+		for _, elem := range v {
+			if elem, ok := p.redactField(key, elem); ok {
+				redacted = append(redacted, elem)
+			}
+		}
+		return redacted, true
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			if elem, ok := p.redactField(key+"."+k, elem); ok {
+				redacted[k] = elem
+			}
+		}
+		return redacted, true
+	case map[string]string:
+		redacted := make(map[string]string, len(v))
+		for k, elem := range v {
+			if elem, ok := p.redactField(key+"."+k, elem); ok {
+				redacted[k] = elem.(string)
+			}
+		}
+		return redacted, true
+	case map[string][]string:
+		redacted := make(map[string][]string, len(v))
+		for k, elem := range v {
+			if elem, ok := p.redactField(key+"."+k, elem); ok {
+				if values, ok := elem.([]string); ok {
+					redacted[k] = values
+				} else {
+					// Masked or hashed as a whole by a field rule.
+					redacted[k] = []string{elem.(string)}
+				}
+			}
+		}
+		return redacted, true
+	default:
+		return value, true
+	}
+}
+
+// redactString applies the query parameter and value rules to a string, or
+// returns false if it's dropped.
+func (p *processor) redactString(key string, s string) (interface{}, bool) {
+	for _, field := range urlFields {
+		if key != field {
+			continue
+		}
+		for _, rule := range p.queries {
+			if key == ecsevent.FieldURLQuery {
+				s = redactQuery(s, rule)
+			} else {
+				s = redactURL(s, rule)
+			}
+		}
+	}
+	for _, rule := range p.values {
+		if !rule.pattern.MatchString(s) {
+			continue
+		}
+		if rule.mode == Drop {
+			if !isCardPattern(rule.pattern) || containsCard(rule.pattern, s) {
+				return nil, false
+			}
+			continue
+		}
+		s = rule.pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if isCardPattern(rule.pattern) && !luhn(match) {
+				return match
+			}
+			redacted, _ := apply(rule.mode, match, Placeholder)
+			return redacted.(string)
+		})
+	}
+	return s, true
+}
+
+// apply redacts a value according to the mode.
+func apply(mode Mode, value interface{}, placeholder string) (interface{}, bool) {
+	switch mode {
+	case Hash:
+		digest := sha256.Sum256([]byte(fmt.Sprint(value)))
+		return hex.EncodeToString(digest[:]), true
+	case Drop:
+		return nil, false
+	default:
+		return placeholder, true
+	}
+}
+
+// redactURL redacts query parameters in a URL, leaving the rest untouched.
+func redactURL(rawURL string, rule queryRule) string {
+	start := strings.IndexByte(rawURL, '?')
+	if start == -1 {
+		return rawURL
+	}
+	end := len(rawURL)
+	if i := strings.IndexByte(rawURL[start:], '#'); i != -1 {
+		end = start + i
+	}
+	query := redactQuery(rawURL[start+1:end], rule)
+	if query == "" {
+		return rawURL[:start] + rawURL[end:]
+	}
+	return rawURL[:start+1] + query + rawURL[end:]
+}
+
+// redactQuery redacts parameters in a raw query string, preserving their
+// order and encoding.
+func redactQuery(query string, rule queryRule) string {
+	params := strings.Split(query, "&")
+	kept := params[:0]
+	for _, param := range params {
+		name, value := param, ""
+		hasValue := false
+		if i := strings.IndexByte(param, '='); i != -1 {
+			name, value, hasValue = param[:i], param[i+1:], true
+		}
+		if !matchName(name, rule.names) {
+			kept = append(kept, param)
+			continue
+		}
+		redacted, ok := apply(rule.mode, value, queryPlaceholder)
+		if !ok {
+			continue
+		}
+		if !hasValue && rule.mode == Mask {
+			kept = append(kept, param)
+			continue
+		}
+		kept = append(kept, name+"="+redacted.(string))
+	}
+	return strings.Join(kept, "&")
+}
+
+// matchName reports whether a raw parameter name, once unescaped, is one of
+// the names, e.g. so that %74oken matches token.
+func matchName(name string, names []string) bool {
+	if unescaped, err := url.QueryUnescape(name); err == nil {
+		name = unescaped
+	}
+	name = strings.ToLower(name)
+	for _, n := range names {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// isCardPattern reports whether matches of a pattern must also pass the Luhn
+// check.
+func isCardPattern(pattern *regexp.Regexp) bool {
+	return pattern == CreditCards || pattern == GroupedCreditCards
+}
+
+func containsCard(pattern *regexp.Regexp, s string) bool {
+	for _, match := range pattern.FindAllString(s, -1) {
+		if luhn(match) {
+			return true
+		}
+	}
+	return false
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum.
+func luhn(s string) bool {
+	sum := 0
+	double := false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
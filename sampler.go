@@ -0,0 +1,201 @@
+package ecsevent
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FieldEventSampleRate records the rate an event was sampled at, i.e. that it
+// stands for that many events, so that backends can re-weight counts. It's
+// not part of ECS and is only set on sampled events with a rate above 1.
+const FieldEventSampleRate = "event.sample_rate"
+
+// Sampler decides which events a RootMonitor emits.
+type Sampler interface {
+	// Sample returns whether the flat event should be kept and the rate it
+	// was sampled at, i.e. 1 in rate events like it are kept.
+	Sample(event map[string]interface{}) (keep bool, rate int)
+}
+
+// RatioSampler keeps a random 1 in Rate events.
+type RatioSampler struct {
+	Rate int
+}
+
+// Sample keeps a random 1 in Rate events.
+func (s *RatioSampler) Sample(event map[string]interface{}) (bool, int) {
+	if s.Rate <= 1 {
+		return true, 1
+	}
+	return rand.Intn(s.Rate) == 0, s.Rate
+}
+
+// tokenBucketWindow is the period over which a TokenBucketSampler measures
+// the rate it samples each key at.
+const tokenBucketWindow = time.Second
+
+// tokenBucketIdle is how long a key's bucket is kept without events.
+const tokenBucketIdle = time.Minute
+
+// TokenBucketSampler rate limits events per key, keeping up to a number of
+// events per second for each combination of the values of its key fields,
+// e.g. url.path, with bursts up to a maximum. The sample rate recorded on
+// kept events is the ratio of seen to kept events for the key over the
+// previous second.
+type TokenBucketSampler struct {
+	perSecond float64
+	burst     float64
+	keyFields []string
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens      float64
+	last        time.Time
+	windowStart time.Time
+	seen, kept  int
+	rate        int
+}
+
+// NewTokenBucketSampler creates a TokenBucketSampler keeping up to perSecond
+// events per second, with bursts of up to burst events, for each key.
+func NewTokenBucketSampler(perSecond float64, burst int, keyFields ...string) *TokenBucketSampler {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucketSampler{
+		perSecond: perSecond,
+		burst:     float64(burst),
+		keyFields: keyFields,
+		buckets:   make(map[string]*tokenBucket),
+		now:       time.Now,
+	}
+}
+
+// Sample keeps the event if its key's bucket has a token left.
+func (s *TokenBucketSampler) Sample(event map[string]interface{}) (bool, int) {
+	values := make([]string, len(s.keyFields))
+	for i, field := range s.keyFields {
+		if value, ok := Lookup(event, field); ok {
+			values[i] = fmt.Sprint(value)
+		}
+	}
+	key := strings.Join(values, "\x1f")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.lastPrune) >= tokenBucketIdle {
+		for k, b := range s.buckets {
+			if now.Sub(b.last) >= tokenBucketIdle {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastPrune = now
+	}
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: s.burst, last: now, windowStart: now, rate: 1}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(s.burst, b.tokens+now.Sub(b.last).Seconds()*s.perSecond)
+	b.last = now
+	if now.Sub(b.windowStart) >= tokenBucketWindow {
+		b.rate = 1
+		if b.kept > 0 {
+			b.rate = int(math.Ceil(float64(b.seen) / float64(b.kept)))
+		}
+		b.seen, b.kept = 0, 0
+		b.windowStart = now
+	}
+	b.seen++
+	if b.tokens < 1 {
+		return false, b.rate
+	}
+	b.tokens--
+	b.kept++
+	return true, b.rate
+}
+
+// TailSampler keeps every event describing an error or a slow operation and
+// samples the rest with another Sampler. Since a SpanMonitor records its
+// event when it's finished, the whole span, including its subevents, is
+// inspected.
+//
+// An event describes an error if it or one of its subevents has an
+// error.message or error.code, an event.outcome of failure, a log.level of
+// error or more severe, or an HTTP response status code of 500 or above.
+type TailSampler struct {
+	// Slow is the event.duration at or above which events are kept. Zero
+	// disables the check.
+	Slow time.Duration
+	// Sampler samples the remaining events. If nil, they're dropped.
+	Sampler Sampler
+}
+
+// Sample keeps errors and slow events, deferring to the Sampler otherwise.
+func (s *TailSampler) Sample(event map[string]interface{}) (bool, int) {
+	if isError(event) || (s.Slow > 0 && durationOf(event) >= s.Slow) {
+		return true, 1
+	}
+	if s.Sampler == nil {
+		return false, 1
+	}
+	return s.Sampler.Sample(event)
+}
+
+func isError(event map[string]interface{}) bool {
+	if _, ok := Lookup(event, FieldErrorMessage); ok {
+		return true
+	}
+	if _, ok := Lookup(event, FieldErrorCode); ok {
+		return true
+	}
+	if outcome, ok := Lookup(event, FieldEventOutcome); ok && outcome == "failure" {
+		return true
+	}
+	if level, ok := Lookup(event, FieldLogLevel); ok {
+		if s, ok := level.(string); ok {
+			if severity, ok := ParseSeverity(s); ok && severity <= SeverityError {
+				return true
+			}
+		}
+	}
+	if status, ok := Lookup(event, FieldHTTPResponseStatusCode); ok {
+		if code, ok := status.(int); ok && code >= 500 {
+			return true
+		}
+	}
+	if subevents, ok := Lookup(event, FieldEventSubevents); ok {
+		if subevents, ok := subevents.([]map[string]interface{}); ok {
+			for _, subevent := range subevents {
+				if isError(subevent) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func durationOf(event map[string]interface{}) time.Duration {
+	value, _ := Lookup(event, FieldEventDuration)
+	switch v := value.(type) {
+	case int:
+		return time.Duration(v)
+	case int64:
+		return time.Duration(v)
+	case time.Duration:
+		return v
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,137 @@
+package ecsevent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRatioSampler(t *testing.T) {
+	assert := assert.New(t)
+	keep, rate := (&RatioSampler{Rate: 1}).Sample(map[string]interface{}{})
+	assert.True(keep)
+	assert.Equal(1, rate)
+
+	s := &RatioSampler{Rate: 4}
+	kept := 0
+	for i := 0; i < 4000; i++ {
+		keep, rate := s.Sample(map[string]interface{}{})
+		assert.Equal(4, rate)
+		if keep {
+			kept++
+		}
+	}
+	assert.InDelta(1000, kept, 200)
+}
+
+func TestTokenBucketSampler(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2019, 10, 28, 6, 15, 7, 0, time.UTC)
+	s := NewTokenBucketSampler(2, 2, FieldURLPath)
+	s.now = func() time.Time { return now }
+	health := map[string]interface{}{FieldURLPath: "/healthz"}
+	checkout := map[string]interface{}{FieldURLPath: "/checkout"}
+
+	var kept []bool
+	for i := 0; i < 4; i++ {
+		keep, rate := s.Sample(health)
+		assert.Equal(1, rate)
+		kept = append(kept, keep)
+	}
+	assert.Equal([]bool{true, true, false, false}, kept)
+	keep, _ := s.Sample(checkout)
+	assert.True(keep, "keys have separate buckets")
+
+	// Tokens refill over time, and the previous window's ratio of seen to
+	// kept events becomes the rate.
+	now = now.Add(time.Second)
+	keep, rate := s.Sample(health)
+	assert.True(keep)
+	assert.Equal(2, rate)
+
+	// Idle buckets are pruned.
+	now = now.Add(2 * time.Minute)
+	s.Sample(checkout)
+	assert.Len(s.buckets, 1)
+}
+
+func TestTailSampler(t *testing.T) {
+	tcs := []struct {
+		name         string
+		event        map[string]interface{}
+		expectedKeep bool
+	}{
+		{"plain", map[string]interface{}{FieldMessage: "ok"}, false},
+		{"error message", map[string]interface{}{FieldErrorMessage: "boom"}, true},
+		{"nested error", map[string]interface{}{"error": map[string]interface{}{"code": "E1"}}, true},
+		{"failure outcome", map[string]interface{}{FieldEventOutcome: "failure"}, true},
+		{"error level", map[string]interface{}{FieldLogLevel: "ERROR"}, true},
+		{"warning level", map[string]interface{}{FieldLogLevel: "warn"}, false},
+		{"server error", map[string]interface{}{FieldHTTPResponseStatusCode: 503}, true},
+		{"client error", map[string]interface{}{FieldHTTPResponseStatusCode: 404}, false},
+		{"slow", map[string]interface{}{FieldEventDuration: int(2 * time.Second)}, true},
+		{"fast", map[string]interface{}{FieldEventDuration: int(time.Millisecond)}, false},
+		{
+			"error subevent",
+			map[string]interface{}{
+				FieldEventSubevents: []map[string]interface{}{
+					{FieldMessage: "ok"},
+					{FieldLogLevel: "error"},
+				},
+			},
+			true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			s := &TailSampler{Slow: time.Second}
+			keep, rate := s.Sample(tc.event)
+			assert.Equal(tc.expectedKeep, keep)
+			assert.Equal(1, rate)
+		})
+	}
+}
+
+func TestRootMonitorSampling(t *testing.T) {
+	assert := assert.New(t)
+	mock := &mockEmitter{}
+	rm := NewRootMonitor(
+		NestEvents(false),
+		EmitToMock(mock),
+		Sampling(&TailSampler{Sampler: &RatioSampler{Rate: 1}}),
+	)
+	rm.Record(map[string]interface{}{FieldMessage: "kept"})
+	assert.NotContains(mock.events[0], FieldEventSampleRate)
+
+	rm.SetSampler(&TailSampler{})
+	for i := 0; i < 10; i++ {
+		span := NewSpanMonitorFromParent(rm)
+		span.Record(map[string]interface{}{FieldMessage: "fine"})
+		span.Finish()
+	}
+	span := NewSpanMonitorFromParent(rm)
+	span.Record(map[string]interface{}{FieldErrorMessage: "boom"})
+	span.Finish()
+	if assert.Len(mock.events, 2) {
+		assert.NotContains(mock.events[1], FieldEventSampleRate)
+	}
+
+	event := map[string]interface{}{FieldMessage: "sampled", FieldEventSampleRate: 2}
+	rm.SetSampler(&fixedSampler{rate: 5})
+	rm.Record(event)
+	if assert.Len(mock.events, 3) {
+		assert.Equal(10, mock.events[2][FieldEventSampleRate])
+		assert.Equal(2, event[FieldEventSampleRate], "recorded event is not modified")
+	}
+}
+
+type fixedSampler struct {
+	rate int
+}
+
+func (s *fixedSampler) Sample(event map[string]interface{}) (bool, int) {
+	return true, s.rate
+}
@@ -0,0 +1,49 @@
+package ecsevent
+
+import (
+	"strings"
+)
+
+// Severity is a syslog severity level as defined by RFC 5424. Lower values
+// are more severe.
+type Severity int
+
+// Syslog severity levels.
+const (
+	SeverityEmergency Severity = iota
+	SeverityAlert
+	SeverityCritical
+	SeverityError
+	SeverityWarning
+	SeverityNotice
+	SeverityInformational
+	SeverityDebug
+)
+
+// ParseSeverity converts a log.level value into a syslog severity. ECS
+// doesn't specify accepted values for log.level, so the common spellings and
+// abbreviations used by logging libraries are accepted. Trace levels map to
+// SeverityDebug and panic levels to SeverityCritical. The second return value
+// is false if the level is not recognized.
+func ParseSeverity(level string) (Severity, bool) {
+	switch strings.ToLower(level) {
+	case "t", "trc", "trace", "d", "dbg", "debug":
+		return SeverityDebug, true
+	case "i", "inf", "informational", "info":
+		return SeverityInformational, true
+	case "n", "not", "ntc", "notice":
+		return SeverityNotice, true
+	case "w", "wrn", "warn", "warning":
+		return SeverityWarning, true
+	case "e", "err", "error":
+		return SeverityError, true
+	case "c", "crt", "crit", "critical", "panic", "dpanic":
+		return SeverityCritical, true
+	case "a", "alr", "alrt", "alrm", "alarm", "alert":
+		return SeverityAlert, true
+	case "f", "ftl", "fat", "fatal", "emg", "emrg", "emerg", "emergency":
+		return SeverityEmergency, true
+	default:
+		return SeverityDebug, false
+	}
+}
@@ -0,0 +1,38 @@
+package ecsevent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSeverity(t *testing.T) {
+	tcs := []struct {
+		level            string
+		expectedSeverity Severity
+		expectedOK       bool
+	}{
+		{"trace", SeverityDebug, true},
+		{"DEBUG", SeverityDebug, true},
+		{"inf", SeverityInformational, true},
+		{"notice", SeverityNotice, true},
+		{"Warn", SeverityWarning, true},
+		{"error", SeverityError, true},
+		{"crit", SeverityCritical, true},
+		{"dpanic", SeverityCritical, true},
+		{"alert", SeverityAlert, true},
+		{"fatal", SeverityEmergency, true},
+		{"emerg", SeverityEmergency, true},
+		{"verbose", SeverityDebug, false},
+		{"", SeverityDebug, false},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.level, func(t *testing.T) {
+			assert := assert.New(t)
+			severity, ok := ParseSeverity(tc.level)
+			assert.Equal(tc.expectedOK, ok)
+			assert.Equal(tc.expectedSeverity, severity)
+		})
+	}
+}
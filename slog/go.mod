@@ -0,0 +1,19 @@
+module github.com/sporkmonger/ecsevent/slog
+
+go 1.21
+
+require (
+	github.com/sporkmonger/ecsevent v0.0.0-00010101000000-000000000000
+	github.com/stretchr/testify v1.5.1
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/opentracing/opentracing-go v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v2 v2.2.8 // indirect
+)
+
+// slog needs Go 1.21 while ecsevent itself supports older releases, so the
+// handler lives in its own module. Develop against the local copy.
+replace github.com/sporkmonger/ecsevent => ../
@@ -0,0 +1,218 @@
+package slog
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"runtime"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+// Handler is a slog.Handler that converts log records into ECS events and
+// records them on the Monitor found in the context, so that records logged
+// while handling a request become subevents of that request's SpanMonitor.
+//
+// Groups are mapped to dotted field prefixes, e.g. an attribute 'method' in
+// a group 'http.request' becomes http.request.method.
+type Handler struct {
+	monitor   ecsevent.Monitor
+	level     slog.Leveler
+	addSource bool
+	prefix    string
+	attrs     map[string]interface{}
+}
+
+// Option configures a Handler as it's being initialized.
+type Option func(*Handler)
+
+// Monitor sets the Monitor records are sent to when the context passed to
+// the logger holds none, e.g. for calls made without a context.
+func Monitor(monitor ecsevent.Monitor) Option {
+	return func(h *Handler) {
+		h.monitor = monitor
+	}
+}
+
+// Level sets the minimum level of records that are handled. Defaults to
+// slog.LevelInfo.
+func Level(level slog.Leveler) Option {
+	return func(h *Handler) {
+		h.level = level
+	}
+}
+
+// AddSource selects whether log.origin.file.name, log.origin.file.line and
+// log.origin.function are recorded from the caller. Defaults to true.
+func AddSource(enabled bool) Option {
+	return func(h *Handler) {
+		h.addSource = enabled
+	}
+}
+
+// NewHandler creates a new Handler with the given Option functions applied.
+func NewHandler(opts ...Option) *Handler {
+	h := &Handler{
+		level:     slog.LevelInfo,
+		addSource: true,
+		attrs:     map[string]interface{}{},
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Enabled reports whether records at the given level are handled.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle converts a record into an ECS event and records it.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	event := make(map[string]interface{}, len(h.attrs)+r.NumAttrs()+6)
+	for key, value := range h.attrs {
+		event[key] = value
+	}
+	if !r.Time.IsZero() {
+		event[ecsevent.FieldTimestamp] = r.Time
+	}
+	event[ecsevent.FieldLogLevel] = levelName(r.Level)
+	if r.Message != "" {
+		event[ecsevent.FieldMessage] = r.Message
+	}
+	if h.addSource && r.PC != 0 {
+		frames := runtime.CallersFrames([]uintptr{r.PC})
+		frame, _ := frames.Next()
+		if frame.File != "" {
+			event[ecsevent.FieldLogOriginFileName] = frame.File
+			event[ecsevent.FieldLogOriginFileLine] = frame.Line
+		}
+		if frame.Function != "" {
+			event[ecsevent.FieldLogOriginFunction] = frame.Function
+		}
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(event, h.prefix, a)
+		return true
+	})
+
+	var monitor ecsevent.Monitor = ecsevent.Nop()
+	if ctx != nil {
+		monitor = ecsevent.MonitorFromContext(ctx)
+	}
+	if _, ok := monitor.(*ecsevent.NopMonitor); ok && h.monitor != nil {
+		monitor = h.monitor
+	}
+	monitor.Record(event)
+	return nil
+}
+
+// WithAttrs returns a Handler that adds the attributes to every record.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	h2 := h.clone()
+	for _, a := range attrs {
+		addAttr(h2.attrs, h2.prefix, a)
+	}
+	return h2
+}
+
+// WithGroup returns a Handler that prefixes subsequent attributes with the
+// group name.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := h.clone()
+	h2.prefix += name + "."
+	return h2
+}
+
+func (h *Handler) clone() *Handler {
+	h2 := *h
+	h2.attrs = make(map[string]interface{}, len(h.attrs))
+	for key, value := range h.attrs {
+		h2.attrs[key] = value
+	}
+	return &h2
+}
+
+// addAttr adds an attribute to the event under its dotted name, flattening
+// groups.
+func addAttr(event map[string]interface{}, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		if len(attrs) == 0 {
+			return
+		}
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range attrs {
+			addAttr(event, prefix, ga)
+		}
+		return
+	}
+	event[prefix+a.Key] = attrValue(a.Value)
+}
+
+// attrValue converts a slog value into the types ECS fields expect, e.g. int
+// rather than int64 and durations in nanoseconds.
+func attrValue(v slog.Value) interface{} {
+	switch v.Kind() {
+	case slog.KindString:
+		return v.String()
+	case slog.KindInt64:
+		n := v.Int64()
+		if n >= math.MinInt && n <= math.MaxInt {
+			return int(n)
+		}
+		return n
+	case slog.KindUint64:
+		return v.Uint64()
+	case slog.KindFloat64:
+		return v.Float64()
+	case slog.KindBool:
+		return v.Bool()
+	case slog.KindDuration:
+		return int(v.Duration() / time.Nanosecond)
+	case slog.KindTime:
+		return v.Time()
+	default:
+		value := v.Any()
+		if err, ok := value.(error); ok {
+			return err.Error()
+		}
+		return value
+	}
+}
+
+// levelName maps slog levels, including those between the named levels, to
+// ECS log.level values.
+func levelName(level slog.Level) string {
+	switch {
+	case level < slog.LevelInfo:
+		return "debug"
+	case level < slog.LevelWarn:
+		return "info"
+	case level < slog.LevelError:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+var (
+	// This is a compile-time check to make sure our types correctly
+	// implement the interface:
+	// https://medium.com/@matryer/c167afed3aae
+	_ slog.Handler = &Handler{}
+)
@@ -0,0 +1,97 @@
+package slog
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type captureEmitter struct {
+	events []map[string]interface{}
+}
+
+func (ce *captureEmitter) Emit(event map[string]interface{}) {
+	ce.events = append(ce.events, event)
+}
+
+func TestHandler(t *testing.T) {
+	assert := assert.New(t)
+	emitter := &captureEmitter{}
+	rm := ecsevent.NewRootMonitor(ecsevent.NestEvents(false))
+	rm.AppendEmitter(emitter)
+
+	logger := slog.New(NewHandler(Monitor(rm), Level(slog.LevelDebug)))
+	logger = logger.With("service.name", "checkout").WithGroup("http").With(
+		slog.Group("request", slog.String("method", "GET")),
+	)
+	logger.Warn("slow request",
+		slog.Group("response", slog.Int("status_code", 200)),
+		slog.Duration("took", 12*time.Millisecond),
+		slog.Any("error", errors.New("boom")),
+		slog.Group("empty"),
+	)
+
+	if assert.Len(emitter.events, 1) {
+		event := emitter.events[0]
+		assert.IsType(time.Time{}, event[ecsevent.FieldTimestamp])
+		delete(event, ecsevent.FieldTimestamp)
+		file, _ := event[ecsevent.FieldLogOriginFileName].(string)
+		assert.True(strings.HasSuffix(file, "handler_test.go"), file)
+		assert.IsType(0, event[ecsevent.FieldLogOriginFileLine])
+		assert.Equal("github.com/sporkmonger/ecsevent/slog.TestHandler", event[ecsevent.FieldLogOriginFunction])
+		delete(event, ecsevent.FieldLogOriginFileName)
+		delete(event, ecsevent.FieldLogOriginFileLine)
+		delete(event, ecsevent.FieldLogOriginFunction)
+		assert.Equal(map[string]interface{}{
+			ecsevent.FieldLogLevel:               "warn",
+			ecsevent.FieldMessage:                "slow request",
+			ecsevent.FieldServiceName:            "checkout",
+			ecsevent.FieldHTTPRequestMethod:      "GET",
+			ecsevent.FieldHTTPResponseStatusCode: 200,
+			"http.took":                          12000000,
+			"http.error":                         "boom",
+		}, event)
+	}
+}
+
+func TestHandlerContext(t *testing.T) {
+	assert := assert.New(t)
+	emitter := &captureEmitter{}
+	rm := ecsevent.NewRootMonitor(ecsevent.NestEvents(false))
+	rm.AppendEmitter(emitter)
+
+	logger := slog.New(NewHandler(AddSource(false)))
+	span := ecsevent.NewSpanMonitorFromParent(rm)
+	ctx := span.WithContext(context.Background())
+	logger.InfoContext(ctx, "first")
+	logger.DebugContext(ctx, "filtered")
+	logger.Log(ctx, slog.LevelError+4, "second")
+	// Without a monitor in the context or a fallback, records are dropped.
+	logger.Info("dropped")
+	span.Finish()
+
+	if assert.Len(emitter.events, 1) {
+		subevents, ok := emitter.events[0][ecsevent.FieldEventSubevents].([]map[string]interface{})
+		if assert.True(ok) && assert.Len(subevents, 2) {
+			assert.Equal("first", subevents[0][ecsevent.FieldMessage])
+			assert.Equal("info", subevents[0][ecsevent.FieldLogLevel])
+			assert.Equal("error", subevents[1][ecsevent.FieldLogLevel])
+			assert.NotContains(subevents[0], ecsevent.FieldLogOriginFileName)
+		}
+	}
+}
+
+func TestLevelName(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("debug", levelName(slog.LevelDebug-4))
+	assert.Equal("info", levelName(slog.LevelInfo+2))
+	assert.Equal("warn", levelName(slog.LevelWarn))
+	assert.Equal("error", levelName(slog.LevelError))
+}
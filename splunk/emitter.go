@@ -0,0 +1,491 @@
+package splunk
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+	"github.com/sporkmonger/ecsevent/internal/batch"
+)
+
+const (
+	defaultSourceType    = "_json"
+	defaultBatchSize     = 100
+	defaultBufferSize    = 10000
+	defaultFlushInterval = 2 * time.Second
+	defaultMaxRetries    = 3
+	defaultRetryBackoff  = 250 * time.Millisecond
+	defaultAckTimeout    = 30 * time.Second
+	defaultAckInterval   = time.Second
+)
+
+// ErrAckTimeout is reported when Splunk did not acknowledge a batch of
+// events before the acknowledgment timeout elapsed.
+var ErrAckTimeout = errors.New("splunk did not acknowledge events before timeout")
+
+// ErrBufferFull is reported when an event is dropped because the buffer
+// already holds the maximum number of events.
+var ErrBufferFull = errors.New("splunk emitter buffer is full, event dropped")
+
+// Emitter buffers ECS formatted events and ships them to a Splunk HTTP Event
+// Collector.
+//
+// Events are sent when the batch size is reached, when the flush interval
+// elapses, or when Flush or Close is called.
+type Emitter struct {
+	server          string
+	token           string
+	host            string
+	source          string
+	sourceType      string
+	index           string
+	hostField       string
+	sourceField     string
+	sourceTypeField string
+	indexField      string
+	channel         string
+	ack             bool
+	ackTimeout      time.Duration
+	ackInterval     time.Duration
+	client          *http.Client
+	batchSize       int
+	bufferSize      int
+	flushInterval   time.Duration
+	maxRetries      int
+	retryBackoff    time.Duration
+	errorHandler    func(error)
+
+	batcher *batch.Batcher
+	// acks holds the ack IDs of the batches sent during a flush and their
+	// sizes. Only touched while sending.
+	acks map[int64]int
+}
+
+// Option configures an Emitter as it's being initialized.
+type Option func(*Emitter)
+
+// Server sets the HEC origin to ship events to, e.g.
+// 'https://splunk.example.com:8088'. No trailing slash, typically.
+func Server(server string) Option {
+	return func(e *Emitter) {
+		e.server = strings.TrimSuffix(server, "/")
+	}
+}
+
+// Token sets the HEC token used to authenticate requests.
+func Token(token string) Option {
+	return func(e *Emitter) {
+		e.token = token
+	}
+}
+
+// Host sets a static host for all events, overriding HostField.
+func Host(host string) Option {
+	return func(e *Emitter) {
+		e.host = host
+	}
+}
+
+// Source sets a static source for all events, overriding SourceField.
+func Source(source string) Option {
+	return func(e *Emitter) {
+		e.source = source
+	}
+}
+
+// SourceType sets a static sourcetype for all events, overriding
+// SourceTypeField. Events with neither use '_json'.
+func SourceType(sourceType string) Option {
+	return func(e *Emitter) {
+		e.sourceType = sourceType
+	}
+}
+
+// Index sets a static index for all events, overriding IndexField. If no
+// index is set, the token's default index is used.
+func Index(index string) Option {
+	return func(e *Emitter) {
+		e.index = index
+	}
+}
+
+// HostField sets the ECS field the host is derived from. Defaults to
+// host.hostname.
+func HostField(field string) Option {
+	return func(e *Emitter) {
+		e.hostField = field
+	}
+}
+
+// SourceField sets the ECS field the source is derived from. Defaults to
+// service.name.
+func SourceField(field string) Option {
+	return func(e *Emitter) {
+		e.sourceField = field
+	}
+}
+
+// SourceTypeField sets the ECS field the sourcetype is derived from, e.g.
+// event.dataset. Events without the field use '_json'.
+func SourceTypeField(field string) Option {
+	return func(e *Emitter) {
+		e.sourceTypeField = field
+	}
+}
+
+// IndexField sets the ECS field the index is derived from. Events without the
+// field use the token's default index.
+func IndexField(field string) Option {
+	return func(e *Emitter) {
+		e.indexField = field
+	}
+}
+
+// Channel sets the channel identifier sent with every request. It must be a
+// GUID. A random one is generated if acknowledgments are enabled and no
+// channel is set.
+func Channel(channel string) Option {
+	return func(e *Emitter) {
+		e.channel = channel
+	}
+}
+
+// Acknowledgments enables indexer acknowledgment. Each batch is polled on the
+// ack endpoint until Splunk confirms it was indexed or the timeout elapses,
+// in which case ErrAckTimeout is reported.
+func Acknowledgments(timeout time.Duration) Option {
+	return func(e *Emitter) {
+		e.ack = true
+		e.ackTimeout = timeout
+	}
+}
+
+// AckInterval sets how often the ack endpoint is polled. Defaults to one
+// second.
+func AckInterval(interval time.Duration) Option {
+	return func(e *Emitter) {
+		e.ackInterval = interval
+	}
+}
+
+// HTTPClient sets the client used to send requests.
+func HTTPClient(client *http.Client) Option {
+	return func(e *Emitter) {
+		e.client = client
+	}
+}
+
+// BatchSize sets the number of buffered events that triggers a request.
+// Values below 1 use the default of 100.
+func BatchSize(size int) Option {
+	return func(e *Emitter) {
+		e.batchSize = size
+	}
+}
+
+// BufferSize sets the maximum number of events held in memory while waiting
+// to be sent. Events emitted while the buffer is full are dropped and
+// reported as ErrBufferFull. Defaults to 10000.
+func BufferSize(size int) Option {
+	return func(e *Emitter) {
+		e.bufferSize = size
+	}
+}
+
+// FlushInterval sets the maximum time an event will remain buffered. Values
+// below 1 use the default of 2 seconds.
+func FlushInterval(interval time.Duration) Option {
+	return func(e *Emitter) {
+		e.flushInterval = interval
+	}
+}
+
+// MaxRetries sets how many times a request that failed with a 429 or 5xx
+// status or a network error will be retried before its events are dropped.
+func MaxRetries(retries int) Option {
+	return func(e *Emitter) {
+		e.maxRetries = retries
+	}
+}
+
+// RetryBackoff sets the base delay between retries. The delay doubles with
+// each attempt and has jitter applied.
+func RetryBackoff(backoff time.Duration) Option {
+	return func(e *Emitter) {
+		e.retryBackoff = backoff
+	}
+}
+
+// ErrorHandler sets a callback for errors encountered while shipping events,
+// since Emit has no way to return them.
+func ErrorHandler(handler func(error)) Option {
+	return func(e *Emitter) {
+		e.errorHandler = handler
+	}
+}
+
+// New creates a new Emitter with the given Option functions applied and
+// starts its background flush loop. Call Close to stop it.
+func New(opts ...Option) *Emitter {
+	e := &Emitter{
+		hostField:     ecsevent.FieldHostHostname,
+		sourceField:   ecsevent.FieldServiceName,
+		ackTimeout:    defaultAckTimeout,
+		ackInterval:   defaultAckInterval,
+		batchSize:     defaultBatchSize,
+		bufferSize:    defaultBufferSize,
+		flushInterval: defaultFlushInterval,
+		maxRetries:    defaultMaxRetries,
+		retryBackoff:  defaultRetryBackoff,
+		errorHandler:  func(error) {},
+		acks:          map[int64]int{},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.batchSize <= 0 {
+		e.batchSize = defaultBatchSize
+	}
+	if e.flushInterval <= 0 {
+		e.flushInterval = defaultFlushInterval
+	}
+	if e.ack && e.channel == "" {
+		e.channel = newGUID()
+	}
+	if e.client == nil {
+		e.client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: (&net.Dialer{
+					Timeout:   10 * time.Second,
+					KeepAlive: 10 * time.Second,
+				}).DialContext,
+				TLSHandshakeTimeout:   10 * time.Second,
+				ExpectContinueTimeout: 4 * time.Second,
+				ResponseHeaderTimeout: 10 * time.Second,
+			},
+			Timeout: 2 * time.Minute,
+		}
+	}
+	e.batcher = batch.New(batch.Config{
+		Send:          e.send,
+		Flushed:       e.flushed,
+		BatchSize:     e.batchSize,
+		BufferSize:    e.bufferSize,
+		FlushInterval: e.flushInterval,
+	})
+	return e
+}
+
+type hecEvent struct {
+	Time       float64                `json:"time"`
+	Host       string                 `json:"host,omitempty"`
+	Source     string                 `json:"source,omitempty"`
+	SourceType string                 `json:"sourcetype,omitempty"`
+	Index      string                 `json:"index,omitempty"`
+	Event      map[string]interface{} `json:"event"`
+}
+
+type hecResponse struct {
+	Text  string `json:"text"`
+	Code  int    `json:"code"`
+	AckID *int64 `json:"ackId"`
+}
+
+// Emit takes a map of ECS fields and values and buffers the event for the
+// next request.
+func (e *Emitter) Emit(event map[string]interface{}) {
+	timestamp := ecsevent.Timestamp(event)
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	he := &hecEvent{
+		// Splunk expects epoch seconds, millisecond precision is typical.
+		Time:       float64(timestamp.UnixNano()/int64(time.Millisecond)) / 1000,
+		Host:       e.metadata(event, e.host, e.hostField),
+		Source:     e.metadata(event, e.source, e.sourceField),
+		SourceType: e.metadata(event, e.sourceType, e.sourceTypeField),
+		Index:      e.metadata(event, e.index, e.indexField),
+		Event:      event,
+	}
+	if he.SourceType == "" {
+		he.SourceType = defaultSourceType
+	}
+	data, err := json.Marshal(he)
+	if err != nil {
+		e.errorHandler(err)
+		return
+	}
+	switch e.batcher.Add(data) {
+	case batch.ErrClosed:
+		e.errorHandler(errors.New("splunk emitter is closed"))
+	case batch.ErrBufferFull:
+		e.errorHandler(ErrBufferFull)
+	}
+}
+
+// metadata resolves a metadata value, preferring the static value and
+// otherwise deriving it from the event.
+func (e *Emitter) metadata(event map[string]interface{}, static string, field string) string {
+	if static != "" || field == "" {
+		return static
+	}
+	if value, ok := ecsevent.Lookup(event, field); ok {
+		if s, ok := value.(string); ok {
+			return s
+		}
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+// Flush synchronously sends all buffered events, waiting for
+// acknowledgment if enabled.
+func (e *Emitter) Flush() {
+	e.batcher.Flush()
+}
+
+// Close flushes any buffered events and stops the background flush loop.
+// Events emitted after Close are dropped.
+func (e *Emitter) Close() error {
+	e.batcher.Close()
+	return nil
+}
+
+// flushed waits for acknowledgment of the batches sent during a flush.
+func (e *Emitter) flushed() {
+	if len(e.acks) > 0 {
+		e.waitForAcks(e.acks)
+		e.acks = map[int64]int{}
+	}
+}
+
+// send delivers a batch, retrying on network errors and retryable statuses.
+// It records the batch's ack ID if Splunk assigned one.
+func (e *Emitter) send(items []interface{}) {
+	events := make([][]byte, len(items))
+	for i, item := range items {
+		events[i] = item.([]byte)
+	}
+	body := bytes.Join(events, []byte{'\n'})
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(batch.Backoff(e.retryBackoff, 0, attempt))
+		}
+		resp, retry, err := e.post("/services/collector/event", body)
+		if err == nil {
+			if resp.AckID != nil && e.ack {
+				e.acks[*resp.AckID] = len(events)
+			}
+			return
+		}
+		if !retry || attempt >= e.maxRetries {
+			e.errorHandler(fmt.Errorf("dropped %d events: %v", len(events), err))
+			return
+		}
+	}
+}
+
+// waitForAcks polls the ack endpoint until every ack ID is confirmed or the
+// ack timeout elapses.
+func (e *Emitter) waitForAcks(acks map[int64]int) {
+	deadline := time.Now().Add(e.ackTimeout)
+	for len(acks) > 0 {
+		ids := make([]int64, 0, len(acks))
+		for id := range acks {
+			ids = append(ids, id)
+		}
+		body, _ := json.Marshal(map[string][]int64{"acks": ids})
+		status := struct {
+			Acks map[string]bool `json:"acks"`
+		}{}
+		resp, err := e.request("/services/collector/ack", body)
+		if err == nil {
+			if resp.StatusCode < 300 {
+				err = json.NewDecoder(resp.Body).Decode(&status)
+			} else {
+				io.Copy(ioutil.Discard, resp.Body)
+				err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+			}
+			resp.Body.Close()
+		}
+		if err != nil {
+			e.errorHandler(fmt.Errorf("could not query splunk acknowledgments: %v", err))
+		}
+		for id, acked := range status.Acks {
+			if n, err := strconv.ParseInt(id, 10, 64); err == nil && acked {
+				delete(acks, n)
+			}
+		}
+		if len(acks) == 0 {
+			return
+		}
+		if time.Now().Add(e.ackInterval).After(deadline) {
+			break
+		}
+		time.Sleep(e.ackInterval)
+	}
+	for _, n := range acks {
+		e.errorHandler(fmt.Errorf("%d events: %v", n, ErrAckTimeout))
+	}
+}
+
+// post sends a single request and parses the HEC response. It reports
+// whether a failed request should be retried.
+func (e *Emitter) post(path string, body []byte) (*hecResponse, bool, error) {
+	resp, err := e.request(path, body)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	hr := &hecResponse{}
+	json.Unmarshal(data, hr)
+	if resp.StatusCode < 300 {
+		return hr, false, nil
+	}
+	if hr.Text == "" {
+		hr.Text = strings.TrimSpace(string(data))
+	}
+	err = fmt.Errorf("splunk HEC request failed with status %d: %s (code %d)",
+		resp.StatusCode, hr.Text, hr.Code)
+	return nil, resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+func (e *Emitter) request(path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, e.server+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Splunk "+e.token)
+	if e.channel != "" {
+		req.Header.Set("X-Splunk-Request-Channel", e.channel)
+	}
+	return e.client.Do(req)
+}
+
+// newGUID generates a random version 4 UUID.
+func newGUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+var (
+	// This is a compile-time check to make sure our types correctly
+	// implement the interface:
+	// https://medium.com/@matryer/c167afed3aae
+	_ ecsevent.Emitter = &Emitter{}
+)
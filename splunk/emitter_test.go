@@ -0,0 +1,301 @@
+package splunk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeHEC is a minimal stand-in for a Splunk HTTP Event Collector with
+// indexer acknowledgment. Acks are confirmed after ackAfter polls.
+type fakeHEC struct {
+	mu       sync.Mutex
+	statuses []int
+	ackAfter int
+	nextAck  int64
+	polls    int
+	channels []string
+	auth     []string
+	events   [][]map[string]interface{}
+}
+
+func (fh *fakeHEC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	fh.channels = append(fh.channels, r.Header.Get("X-Splunk-Request-Channel"))
+	fh.auth = append(fh.auth, r.Header.Get("Authorization"))
+	switch r.URL.Path {
+	case "/services/collector/event":
+		if len(fh.statuses) > 0 {
+			status := fh.statuses[0]
+			fh.statuses = fh.statuses[1:]
+			w.WriteHeader(status)
+			fmt.Fprint(w, `{"text":"Server is busy","code":9}`)
+			return
+		}
+		events := []map[string]interface{}{}
+		decoder := json.NewDecoder(r.Body)
+		for decoder.More() {
+			event := map[string]interface{}{}
+			if err := decoder.Decode(&event); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"text":"Invalid data format","code":6}`)
+				return
+			}
+			events = append(events, event)
+		}
+		fh.events = append(fh.events, events)
+		if r.Header.Get("X-Splunk-Request-Channel") != "" {
+			fmt.Fprintf(w, `{"text":"Success","code":0,"ackId":%d}`, fh.nextAck)
+			fh.nextAck++
+			return
+		}
+		fmt.Fprint(w, `{"text":"Success","code":0}`)
+	case "/services/collector/ack":
+		fh.polls++
+		query := struct {
+			Acks []int64 `json:"acks"`
+		}{}
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &query)
+		acks := map[string]bool{}
+		for _, id := range query.Acks {
+			acks[fmt.Sprint(id)] = fh.polls > fh.ackAfter
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"acks": acks})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestEmitter(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeHEC{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	emitter := New(Server(server.URL), Token("secret"), SourceTypeField(ecsevent.FieldEventDataset))
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldTimestamp:    time.Date(2020, 4, 1, 12, 0, 0, 123456789, time.UTC),
+		ecsevent.FieldMessage:      "hello world",
+		ecsevent.FieldHostHostname: "web-1",
+		ecsevent.FieldServiceName:  "checkout",
+		ecsevent.FieldEventDataset: "checkout.access",
+	})
+	emitter.Emit(map[string]interface{}{
+		"host": map[string]interface{}{
+			"hostname": "web-2",
+		},
+		ecsevent.FieldMessage: "goodbye world",
+	})
+	assert.NoError(emitter.Close())
+
+	assert.Equal([]string{"Splunk secret"}, fake.auth)
+	assert.Equal([]string{""}, fake.channels)
+	assert.Len(fake.events, 1)
+	assert.Len(fake.events[0], 2)
+	if len(fake.events) == 1 && len(fake.events[0]) == 2 {
+		first := fake.events[0][0]
+		assert.Equal(1585742400.123, first["time"])
+		assert.Equal("web-1", first["host"])
+		assert.Equal("checkout", first["source"])
+		assert.Equal("checkout.access", first["sourcetype"])
+		assert.NotContains(first, "index")
+		assert.Equal("hello world", first["event"].(map[string]interface{})[ecsevent.FieldMessage])
+		second := fake.events[0][1]
+		assert.Equal("web-2", second["host"])
+		assert.NotContains(second, "source")
+		assert.Equal("_json", second["sourcetype"])
+	}
+}
+
+func TestEmitterStaticMetadata(t *testing.T) {
+	assert := assert.New(t)
+	emitter := New(Host("static"), Source("app"), SourceType("ecs"), Index("main"))
+	defer emitter.Close()
+	event := map[string]interface{}{
+		ecsevent.FieldHostHostname: "web-1",
+		ecsevent.FieldServiceName:  "checkout",
+	}
+	assert.Equal("static", emitter.metadata(event, emitter.host, emitter.hostField))
+	assert.Equal("app", emitter.metadata(event, emitter.source, emitter.sourceField))
+	assert.Equal("ecs", emitter.sourceType)
+	assert.Equal("main", emitter.index)
+}
+
+func TestEmitterAcknowledgments(t *testing.T) {
+	tcs := []struct {
+		name           string
+		ackAfter       int
+		expectedErrors int
+	}{
+		{"acknowledged", 1, 0},
+		{"timed out", 100, 2},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			fake := &fakeHEC{ackAfter: tc.ackAfter}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+
+			var errs []error
+			emitter := New(
+				Server(server.URL),
+				BatchSize(1),
+				FlushInterval(time.Hour),
+				Acknowledgments(50*time.Millisecond),
+				AckInterval(10*time.Millisecond),
+				ErrorHandler(func(err error) {
+					errs = append(errs, err)
+				}),
+			)
+			emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+			emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "goodbye world"})
+			assert.NoError(emitter.Close())
+
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			assert.Len(fake.events, 2)
+			for _, channel := range fake.channels {
+				assert.Len(channel, 36)
+				assert.Equal(fake.channels[0], channel)
+			}
+			assert.Len(errs, tc.expectedErrors)
+		})
+	}
+}
+
+func TestEmitterRetry(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeHEC{statuses: []int{http.StatusServiceUnavailable}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	var errs []error
+	emitter := New(
+		Server(server.URL),
+		RetryBackoff(time.Millisecond),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+	assert.Len(fake.auth, 2)
+	assert.Len(fake.events, 1)
+	assert.Empty(errs)
+
+	fake = &fakeHEC{statuses: []int{http.StatusForbidden}}
+	server2 := httptest.NewServer(fake)
+	defer server2.Close()
+	emitter = New(
+		Server(server2.URL),
+		RetryBackoff(time.Millisecond),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+	assert.Len(fake.auth, 1)
+	assert.Len(errs, 1)
+	if len(errs) == 1 {
+		assert.Contains(errs[0].Error(), "Server is busy")
+	}
+}
+
+func TestNewGUID(t *testing.T) {
+	assert := assert.New(t)
+	guid := newGUID()
+	assert.Len(guid, 36)
+	assert.Equal(byte('4'), guid[14])
+	assert.NotEqual(guid, newGUID())
+	assert.True(bytes.Count([]byte(guid), []byte("-")) == 4)
+}
+
+func TestEmitterStaticOverridesField(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeHEC{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	emitter := New(
+		Server(server.URL),
+		Host("static"),
+		Source("app"),
+		SourceType("ecs"),
+		SourceTypeField(ecsevent.FieldEventDataset),
+		Index("main"),
+		IndexField(ecsevent.FieldEventModule),
+	)
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldHostHostname: "web-1",
+		ecsevent.FieldServiceName:  "checkout",
+		ecsevent.FieldEventDataset: "checkout.access",
+		ecsevent.FieldEventModule:  "checkout",
+	})
+	assert.NoError(emitter.Close())
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if assert.Len(fake.events, 1) && assert.Len(fake.events[0], 1) {
+		event := fake.events[0][0]
+		assert.Equal("static", event["host"])
+		assert.Equal("app", event["source"])
+		assert.Equal("ecs", event["sourcetype"])
+		assert.Equal("main", event["index"])
+	}
+}
+
+func TestEmitterBuffer(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeHEC{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	var errs []error
+	emitter := New(
+		Server(server.URL),
+		BufferSize(2),
+		FlushInterval(time.Hour),
+		ErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}),
+	)
+	for i := 0; i < 3; i++ {
+		emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	}
+	assert.Equal([]error{ErrBufferFull}, errs)
+	assert.NoError(emitter.Close())
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Len(fake.events, 1)
+}
+
+func TestEmitterInvalidBatching(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeHEC{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	emitter := New(Server(server.URL), BatchSize(0), FlushInterval(0))
+	emitter.Emit(map[string]interface{}{ecsevent.FieldMessage: "hello world"})
+	assert.NoError(emitter.Close())
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Len(fake.events, 1)
+}
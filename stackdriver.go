@@ -1,6 +1,7 @@
 package ecsevent
 
 import (
+	"strconv"
 	"strings"
 )
 
@@ -42,7 +43,7 @@ func appendStackdriver(entry map[string]interface{}) map[string]interface{} {
 			newEntry[fieldStackdriverHTTPRequestURL] = value
 		case FieldHTTPRequestBytes:
 			if bytes, ok := value.(int64); ok {
-				newEntry[fieldStackdriverHTTPRequestSize] = string(bytes)
+				newEntry[fieldStackdriverHTTPRequestSize] = strconv.FormatInt(bytes, 10)
 			}
 		case FieldHTTPResponseStatusCode:
 			newEntry[fieldStackdriverHTTPRequestStatus] = value
@@ -64,86 +65,27 @@ func appendStackdriver(entry map[string]interface{}) map[string]interface{} {
 }
 
 func stackdriverSeverity(level string) string {
-	// ECS doesn't specify accepted values for log.level
-	switch strings.ToLower(level) {
-	case "t":
-		fallthrough
-	case "trc":
-		fallthrough
-	case "trace":
-		fallthrough
-	case "d":
-		fallthrough
-	case "dbg":
-		fallthrough
-	case "debug":
+	severity, ok := ParseSeverity(level)
+	if !ok {
+		return "DEFAULT"
+	}
+	switch severity {
+	case SeverityDebug:
 		return "DEBUG"
-	case "i":
-		fallthrough
-	case "inf":
-		fallthrough
-	case "informational":
-		fallthrough
-	case "info":
+	case SeverityInformational:
 		return "INFO"
-	case "n":
-		fallthrough
-	case "not":
-		fallthrough
-	case "ntc":
-		fallthrough
-	case "notice":
+	case SeverityNotice:
 		return "NOTICE"
-	case "w":
-		fallthrough
-	case "wrn":
-		fallthrough
-	case "warn":
-		fallthrough
-	case "warning":
+	case SeverityWarning:
 		return "WARNING"
-	case "e":
-		fallthrough
-	case "err":
-		fallthrough
-	case "error":
+	case SeverityError:
 		return "ERROR"
-	case "c":
-		fallthrough
-	case "crt":
-		fallthrough
-	case "crit":
-		fallthrough
-	case "critical":
+	case SeverityCritical:
 		return "CRITICAL"
-	case "a":
-		fallthrough
-	case "alr":
-		fallthrough
-	case "alrt":
-		fallthrough
-	case "alrm":
-		fallthrough
-	case "alarm":
-		fallthrough
-	case "alert":
+	case SeverityAlert:
 		return "ALERT"
-	case "f":
-		fallthrough
-	case "ftl":
-		fallthrough
-	case "fat":
-		fallthrough
-	case "fatal":
-		fallthrough
-	case "emg":
-		fallthrough
-	case "emrg":
-		fallthrough
-	case "emergency":
-		return "EMERGENCY"
 	default:
-		return "DEFAULT"
+		return "EMERGENCY"
 	}
 }
 
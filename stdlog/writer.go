@@ -0,0 +1,159 @@
+package stdlog
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+const (
+	tlsHandshakePrefix = "http: TLS handshake error from "
+	httpPanicPrefix    = "http: panic serving "
+)
+
+// Writer is an io.Writer that records each message written by a log.Logger
+// as an ECS event on a Monitor. The Logger writing to it should have no
+// flags or prefix set, since the event carries its own timestamp.
+type Writer struct {
+	monitor    ecsevent.Monitor
+	level      string
+	name       string
+	splitLines bool
+	parseHTTP  bool
+	now        func() time.Time
+}
+
+// Option configures a Writer as it's being initialized.
+type Option func(*Writer)
+
+// Level sets the log.level of recorded events. Defaults to "info".
+func Level(level string) Option {
+	return func(w *Writer) {
+		w.level = level
+	}
+}
+
+// Name sets the log.logger of recorded events, e.g. "http.Server".
+func Name(name string) Option {
+	return func(w *Writer) {
+		w.name = name
+	}
+}
+
+// SplitLines records each line of a multi-line message as its own event.
+// By default a message is recorded as a single event with its line breaks
+// preserved.
+func SplitLines() Option {
+	return func(w *Writer) {
+		w.splitLines = true
+	}
+}
+
+// ParseHTTPErrors parses the messages net/http logs for TLS handshake errors
+// and recovered panics. The client's address becomes client.ip and
+// client.port, the error becomes error.message, and a panic's stack becomes
+// error.stack_trace.
+func ParseHTTPErrors() Option {
+	return func(w *Writer) {
+		w.parseHTTP = true
+	}
+}
+
+// NewWriter creates a new Writer recording on the Monitor with the given
+// Option functions applied.
+func NewWriter(monitor ecsevent.Monitor, opts ...Option) *Writer {
+	w := &Writer{
+		monitor: monitor,
+		level:   "info",
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// NewLogger returns a log.Logger without flags or prefix that records its
+// messages on the Monitor, e.g. for use as an http.Server's ErrorLog.
+func NewLogger(monitor ecsevent.Monitor, opts ...Option) *log.Logger {
+	return log.New(NewWriter(monitor, opts...), "", 0)
+}
+
+// Write records the message. A log.Logger makes a single call to Write for
+// each message.
+func (w *Writer) Write(p []byte) (int, error) {
+	message := strings.TrimRight(string(p), "\r\n")
+	if message == "" {
+		return len(p), nil
+	}
+	if w.splitLines && !(w.parseHTTP && strings.HasPrefix(message, httpPanicPrefix)) {
+		for _, line := range strings.Split(message, "\n") {
+			line = strings.TrimRight(line, "\r")
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			w.record(line)
+		}
+	} else {
+		w.record(message)
+	}
+	return len(p), nil
+}
+
+func (w *Writer) record(message string) {
+	event := map[string]interface{}{
+		ecsevent.FieldTimestamp: w.now(),
+		ecsevent.FieldLogLevel:  w.level,
+		ecsevent.FieldMessage:   message,
+	}
+	if w.name != "" {
+		event[ecsevent.FieldLogLogger] = w.name
+	}
+	if w.parseHTTP {
+		parseHTTPError(event, message)
+	}
+	w.monitor.Record(event)
+}
+
+// parseHTTPError adds the client address and error from net/http's TLS
+// handshake and panic messages, e.g.:
+//
+//	http: TLS handshake error from 192.0.2.1:51234: EOF
+//	http: panic serving 192.0.2.1:51234: boom
+//	goroutine 7 [running]:
+//	...
+func parseHTTPError(event map[string]interface{}, message string) {
+	var rest string
+	switch {
+	case strings.HasPrefix(message, tlsHandshakePrefix):
+		rest = message[len(tlsHandshakePrefix):]
+	case strings.HasPrefix(message, httpPanicPrefix):
+		rest = message[len(httpPanicPrefix):]
+		if i := strings.IndexByte(rest, '\n'); i != -1 {
+			event[ecsevent.FieldErrorStackTrace] = rest[i+1:]
+			rest = rest[:i]
+		}
+	default:
+		return
+	}
+	i := strings.Index(rest, ": ")
+	if i == -1 {
+		return
+	}
+	addr, errMessage := rest[:i], rest[i+2:]
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+		if n, err := strconv.Atoi(port); err == nil {
+			event[ecsevent.FieldClientPort] = n
+		}
+	}
+	if net.ParseIP(addr) != nil {
+		event[ecsevent.FieldClientIP] = addr
+	}
+	event[ecsevent.FieldClientAddress] = addr
+	event[ecsevent.FieldErrorMessage] = errMessage
+}
@@ -0,0 +1,161 @@
+package stdlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+type captureEmitter struct {
+	events []map[string]interface{}
+}
+
+func (ce *captureEmitter) Emit(event map[string]interface{}) {
+	ce.events = append(ce.events, event)
+}
+
+func TestWriter(t *testing.T) {
+	now := time.Date(2019, 10, 28, 6, 15, 7, 0, time.UTC)
+	tcs := []struct {
+		name           string
+		opts           []Option
+		input          string
+		expectedOutput []map[string]interface{}
+	}{
+		{
+			"plain message",
+			nil,
+			"connection reset\n",
+			[]map[string]interface{}{
+				{
+					ecsevent.FieldTimestamp: now,
+					ecsevent.FieldLogLevel:  "info",
+					ecsevent.FieldMessage:   "connection reset",
+				},
+			},
+		},
+		{
+			"multi-line message",
+			[]Option{Level("warn"), Name("legacy")},
+			"first\nsecond\n",
+			[]map[string]interface{}{
+				{
+					ecsevent.FieldTimestamp: now,
+					ecsevent.FieldLogLevel:  "warn",
+					ecsevent.FieldLogLogger: "legacy",
+					ecsevent.FieldMessage:   "first\nsecond",
+				},
+			},
+		},
+		{
+			"split lines",
+			[]Option{SplitLines()},
+			"first\n\nsecond\n",
+			[]map[string]interface{}{
+				{
+					ecsevent.FieldTimestamp: now,
+					ecsevent.FieldLogLevel:  "info",
+					ecsevent.FieldMessage:   "first",
+				},
+				{
+					ecsevent.FieldTimestamp: now,
+					ecsevent.FieldLogLevel:  "info",
+					ecsevent.FieldMessage:   "second",
+				},
+			},
+		},
+		{
+			"tls handshake error",
+			[]Option{ParseHTTPErrors(), Level("error")},
+			"http: TLS handshake error from 192.0.2.1:51234: remote error: tls: bad certificate\n",
+			[]map[string]interface{}{
+				{
+					ecsevent.FieldTimestamp:     now,
+					ecsevent.FieldLogLevel:      "error",
+					ecsevent.FieldMessage:       "http: TLS handshake error from 192.0.2.1:51234: remote error: tls: bad certificate",
+					ecsevent.FieldClientIP:      "192.0.2.1",
+					ecsevent.FieldClientAddress: "192.0.2.1",
+					ecsevent.FieldClientPort:    51234,
+					ecsevent.FieldErrorMessage:  "remote error: tls: bad certificate",
+				},
+			},
+		},
+		{
+			"tls handshake error from ipv6",
+			[]Option{ParseHTTPErrors()},
+			"http: TLS handshake error from [2001:db8::1]:443: EOF\n",
+			[]map[string]interface{}{
+				{
+					ecsevent.FieldTimestamp:     now,
+					ecsevent.FieldLogLevel:      "info",
+					ecsevent.FieldMessage:       "http: TLS handshake error from [2001:db8::1]:443: EOF",
+					ecsevent.FieldClientIP:      "2001:db8::1",
+					ecsevent.FieldClientAddress: "2001:db8::1",
+					ecsevent.FieldClientPort:    443,
+					ecsevent.FieldErrorMessage:  "EOF",
+				},
+			},
+		},
+		{
+			"panic with split lines",
+			[]Option{ParseHTTPErrors(), SplitLines()},
+			"http: panic serving 192.0.2.1:51234: boom\ngoroutine 7 [running]:\nmain.handler()\n",
+			[]map[string]interface{}{
+				{
+					ecsevent.FieldTimestamp:       now,
+					ecsevent.FieldLogLevel:        "info",
+					ecsevent.FieldMessage:         "http: panic serving 192.0.2.1:51234: boom\ngoroutine 7 [running]:\nmain.handler()",
+					ecsevent.FieldClientIP:        "192.0.2.1",
+					ecsevent.FieldClientAddress:   "192.0.2.1",
+					ecsevent.FieldClientPort:      51234,
+					ecsevent.FieldErrorMessage:    "boom",
+					ecsevent.FieldErrorStackTrace: "goroutine 7 [running]:\nmain.handler()",
+				},
+			},
+		},
+		{
+			"unparsed http message",
+			[]Option{ParseHTTPErrors()},
+			"http: Accept error: too many open files\n",
+			[]map[string]interface{}{
+				{
+					ecsevent.FieldTimestamp: now,
+					ecsevent.FieldLogLevel:  "info",
+					ecsevent.FieldMessage:   "http: Accept error: too many open files",
+				},
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			emitter := &captureEmitter{}
+			rm := ecsevent.NewRootMonitor(ecsevent.NestEvents(false))
+			rm.AppendEmitter(emitter)
+			w := NewWriter(rm, tc.opts...)
+			w.now = func() time.Time { return now }
+			n, err := w.Write([]byte(tc.input))
+			assert.NoError(err)
+			assert.Equal(len(tc.input), n)
+			assert.Equal(tc.expectedOutput, emitter.events)
+		})
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	assert := assert.New(t)
+	emitter := &captureEmitter{}
+	rm := ecsevent.NewRootMonitor(ecsevent.NestEvents(false))
+	rm.AppendEmitter(emitter)
+	logger := NewLogger(rm, Level("error"))
+	logger.Printf("dial %s: refused", "db:5432")
+	if assert.Len(emitter.events, 1) {
+		assert.Equal("dial db:5432: refused", emitter.events[0][ecsevent.FieldMessage])
+		assert.Equal("error", emitter.events[0][ecsevent.FieldLogLevel])
+		assert.IsType(time.Time{}, emitter.events[0][ecsevent.FieldTimestamp])
+	}
+}
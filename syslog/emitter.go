@@ -0,0 +1,235 @@
+package syslog
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+// Transport selects how syslog messages are delivered.
+type Transport int
+
+const (
+	// UDP sends each message as a single datagram, as described in RFC 5426.
+	UDP Transport = iota
+	// TCP sends octet-counted messages over a persistent connection, as
+	// described in RFC 6587.
+	TCP
+	// TLS sends octet-counted messages over a TLS connection, as described
+	// in RFC 5425.
+	TLS
+)
+
+// Emitter converts ECS formatted events into RFC 5424 syslog messages and
+// sends them to a syslog receiver.
+type Emitter struct {
+	transport    Transport
+	address      string
+	tlsConfig    *tls.Config
+	timeout      time.Duration
+	header       header
+	errorHandler func(error)
+
+	// mu gates the connection, since Emit may be called concurrently
+	// from multiple monitors.
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// Option configures an Emitter as it's being initialized.
+type Option func(*Emitter)
+
+// Address sets the 'host:port' messages are sent to. Defaults to
+// localhost:514 for UDP and TCP and localhost:6514 for TLS.
+func Address(address string) Option {
+	return func(e *Emitter) {
+		e.address = address
+	}
+}
+
+// WithTransport selects the transport. Defaults to UDP.
+func WithTransport(transport Transport) Option {
+	return func(e *Emitter) {
+		e.transport = transport
+	}
+}
+
+// TLSConfig sets the TLS configuration used by the TLS transport.
+func TLSConfig(config *tls.Config) Option {
+	return func(e *Emitter) {
+		e.tlsConfig = config
+	}
+}
+
+// Timeout sets the dial and write timeout.
+func Timeout(timeout time.Duration) Option {
+	return func(e *Emitter) {
+		e.timeout = timeout
+	}
+}
+
+// WithFacility sets the facility used to compute each message's PRI.
+// Defaults to FacilityUser.
+func WithFacility(facility Facility) Option {
+	return func(e *Emitter) {
+		e.header.facility = facility
+	}
+}
+
+// Hostname sets the HOSTNAME for events without a host.hostname. Defaults to
+// the system hostname.
+func Hostname(hostname string) Option {
+	return func(e *Emitter) {
+		e.header.hostname = hostname
+	}
+}
+
+// AppName sets the APP-NAME for events without a service.name. Defaults to
+// the name of the running executable.
+func AppName(appName string) Option {
+	return func(e *Emitter) {
+		e.header.appName = appName
+	}
+}
+
+// WithFormat selects how the event is carried. Defaults to JSONMessage.
+func WithFormat(format Format) Option {
+	return func(e *Emitter) {
+		e.header.format = format
+	}
+}
+
+// SDID sets the SD-ID of the element used in StructuredData mode, of the form
+// 'name@<private enterprise number>' using the organization's own IANA
+// assigned number. It is required in StructuredData mode, events are
+// otherwise dropped and reported as ErrMissingSDID.
+func SDID(sdID string) Option {
+	return func(e *Emitter) {
+		e.header.sdID = sdID
+	}
+}
+
+// ErrorHandler sets a callback for errors encountered while sending
+// messages, since Emit has no way to return them.
+func ErrorHandler(handler func(error)) Option {
+	return func(e *Emitter) {
+		e.errorHandler = handler
+	}
+}
+
+// New creates a new Emitter with the given Option functions applied.
+// Connections are established lazily and re-established after errors.
+func New(opts ...Option) *Emitter {
+	e := &Emitter{
+		timeout: 10 * time.Second,
+		header: header{
+			facility: FacilityUser,
+			procID:   strconv.Itoa(os.Getpid()),
+		},
+		errorHandler: func(error) {},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.header.hostname == "" {
+		e.header.hostname, _ = os.Hostname()
+	}
+	if e.header.appName == "" && len(os.Args) > 0 {
+		e.header.appName = filepath.Base(os.Args[0])
+	}
+	if e.address == "" {
+		switch e.transport {
+		case TLS:
+			e.address = "localhost:6514"
+		default:
+			e.address = "localhost:514"
+		}
+	}
+	return e
+}
+
+// Emit takes a map of ECS fields and values and sends it as a syslog message.
+func (e *Emitter) Emit(event map[string]interface{}) {
+	data, err := formatMessage(event, &e.header)
+	if err != nil {
+		e.errorHandler(err)
+		return
+	}
+	if e.transport != UDP {
+		// Octet-counting framing: MSG-LEN SP SYSLOG-MSG
+		data = append([]byte(strconv.Itoa(len(data))+" "), data...)
+	}
+	if err := e.write(data); err != nil {
+		e.errorHandler(err)
+	}
+}
+
+// Close closes the underlying connection, if any.
+func (e *Emitter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		return nil
+	}
+	err := e.conn.Close()
+	e.conn = nil
+	return err
+}
+
+func (e *Emitter) dial() (net.Conn, error) {
+	if e.conn != nil {
+		return e.conn, nil
+	}
+	var conn net.Conn
+	var err error
+	switch e.transport {
+	case TCP:
+		conn, err = net.DialTimeout("tcp", e.address, e.timeout)
+	case TLS:
+		dialer := &net.Dialer{Timeout: e.timeout}
+		conn, err = tls.DialWithDialer(dialer, "tcp", e.address, e.tlsConfig)
+	default:
+		conn, err = net.DialTimeout("udp", e.address, e.timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.conn = conn
+	return conn, nil
+}
+
+func (e *Emitter) write(data []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// Retry once on a fresh connection, since the server may have closed an
+	// idle one.
+	for attempt := 0; ; attempt++ {
+		conn, err := e.dial()
+		if err != nil {
+			return err
+		}
+		conn.SetWriteDeadline(time.Now().Add(e.timeout))
+		_, err = conn.Write(data)
+		if err == nil {
+			return nil
+		}
+		conn.Close()
+		e.conn = nil
+		if attempt > 0 || e.transport == UDP {
+			return err
+		}
+	}
+}
+
+var (
+	// This is a compile-time check to make sure our types correctly
+	// implement the interface:
+	// https://medium.com/@matryer/c167afed3aae
+	_ ecsevent.Emitter = &Emitter{}
+)
@@ -0,0 +1,184 @@
+package syslog
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// selfSignedCert creates a certificate for 127.0.0.1 and a pool trusting it.
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// readFrame reads one octet-counted message from a stream.
+func readFrame(r *bufio.Reader) (string, error) {
+	length, err := r.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+	if err != nil {
+		return "", err
+	}
+	message := make([]byte, n)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return "", err
+	}
+	return string(message), nil
+}
+
+func TestEmitterUDP(t *testing.T) {
+	assert := assert.New(t)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	emitter := New(
+		Address(conn.LocalAddr().String()),
+		WithFacility(FacilityLocal0),
+		Hostname("web-1"),
+		AppName("checkout"),
+	)
+	defer emitter.Close()
+	emitter.Emit(map[string]interface{}{
+		ecsevent.FieldLogLevel: "info",
+		ecsevent.FieldMessage:  "hello world",
+	})
+
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := string(buf[:n])
+	assert.True(strings.HasPrefix(message, "<134>1 "), message)
+	assert.Contains(message, " web-1 checkout ")
+	assert.True(strings.HasSuffix(message, ` - - {"log.level":"info","message":"hello world"}`), message)
+}
+
+func TestEmitterStream(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+	tcs := []struct {
+		name      string
+		transport Transport
+	}{
+		{"tcp", TCP},
+		{"tls", TLS},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			var listener net.Listener
+			var err error
+			if tc.transport == TLS {
+				listener, err = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+			} else {
+				listener, err = net.Listen("tcp", "127.0.0.1:0")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+
+			messages := make(chan string, 2)
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for i := 0; i < 2; i++ {
+					message, err := readFrame(r)
+					if err != nil {
+						return
+					}
+					messages <- message
+				}
+			}()
+
+			var errs []error
+			emitter := New(
+				Address(listener.Addr().String()),
+				WithTransport(tc.transport),
+				TLSConfig(&tls.Config{RootCAs: pool}),
+				WithFormat(StructuredData),
+				// 32473 is reserved for documentation by RFC 5612.
+				SDID("ecs@32473"),
+				ErrorHandler(func(err error) {
+					errs = append(errs, err)
+				}),
+			)
+			defer emitter.Close()
+			emitter.Emit(map[string]interface{}{
+				ecsevent.FieldServiceName: "checkout",
+				ecsevent.FieldMessage:     "first\nline",
+			})
+			emitter.Emit(map[string]interface{}{
+				ecsevent.FieldLogLevel: "debug",
+			})
+
+			for i, suffix := range []string{
+				`[ecs@32473 service.name="checkout"] first` + "\nline",
+				`[ecs@32473 log.level="debug"]`,
+			} {
+				select {
+				case message := <-messages:
+					assert.True(strings.HasSuffix(message, suffix), message)
+					if i == 1 {
+						assert.True(strings.HasPrefix(message, "<15>1 "), message)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("timed out waiting for message")
+				}
+			}
+			assert.Empty(errs)
+		})
+	}
+}
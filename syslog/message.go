@@ -0,0 +1,242 @@
+package syslog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+// Format selects how the ECS event is carried in the syslog message.
+type Format int
+
+const (
+	// JSONMessage sends the ECS event as JSON in the MSG part, leaving the
+	// STRUCTURED-DATA empty.
+	JSONMessage Format = iota
+	// StructuredData sends the ECS fields as SD-PARAMs of a single
+	// SD-ELEMENT and the event's message field as MSG. The SD-ID must be set
+	// with the SDID option.
+	StructuredData
+)
+
+// ErrMissingSDID is reported when an event is dropped because StructuredData
+// mode is used without an SD-ID.
+var ErrMissingSDID = errors.New("syslog structured data requires an SD-ID")
+
+// Facility is a syslog facility as defined by RFC 5424.
+type Facility int
+
+// Syslog facilities.
+const (
+	FacilityKernel Facility = iota
+	FacilityUser
+	FacilityMail
+	FacilityDaemon
+	FacilityAuth
+	FacilitySyslog
+	FacilityLPR
+	FacilityNews
+	FacilityUUCP
+	FacilityCron
+	FacilityAuthPriv
+	FacilityFTP
+	FacilityNTP
+	FacilityAudit
+	FacilityAlert
+	FacilityClock
+	FacilityLocal0
+	FacilityLocal1
+	FacilityLocal2
+	FacilityLocal3
+	FacilityLocal4
+	FacilityLocal5
+	FacilityLocal6
+	FacilityLocal7
+)
+
+const (
+	nilValue        = "-"
+	maxHostname     = 255
+	maxAppName      = 48
+	maxProcID       = 128
+	maxSDName       = 32
+	timestampLayout = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// header holds the fallback values for the syslog header fields.
+type header struct {
+	facility Facility
+	hostname string
+	appName  string
+	procID   string
+	sdID     string
+	format   Format
+}
+
+// formatMessage renders an ECS event as an RFC 5424 syslog message.
+func formatMessage(event map[string]interface{}, h *header) ([]byte, error) {
+	flat := ecsevent.Unnest(event)
+	severity := ecsevent.SeverityInformational
+	if level, ok := flat[ecsevent.FieldLogLevel].(string); ok {
+		if parsed, ok := ecsevent.ParseSeverity(level); ok {
+			severity = parsed
+		}
+	}
+	timestamp := ecsevent.Timestamp(flat)
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	hostname := h.hostname
+	if value, ok := flat[ecsevent.FieldHostHostname]; ok {
+		hostname = fmt.Sprint(value)
+	}
+	appName := h.appName
+	if value, ok := flat[ecsevent.FieldServiceName]; ok {
+		appName = fmt.Sprint(value)
+	}
+	procID := h.procID
+	if value, ok := flat[ecsevent.FieldProcessPID]; ok {
+		procID = fmt.Sprint(value)
+	}
+
+	var sb strings.Builder
+	// PRI VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID
+	fmt.Fprintf(&sb, "<%d>1 %s %s %s %s %s ",
+		int(h.facility)*8+int(severity),
+		timestamp.UTC().Format(timestampLayout),
+		headerField(hostname, maxHostname),
+		headerField(appName, maxAppName),
+		headerField(procID, maxProcID),
+		nilValue,
+	)
+	if h.format == StructuredData {
+		if h.sdID == "" {
+			return nil, ErrMissingSDID
+		}
+		sb.WriteString(structuredData(h.sdID, flat))
+		if message, ok := flat[ecsevent.FieldMessage].(string); ok && message != "" {
+			sb.WriteByte(' ')
+			sb.WriteString(message)
+		}
+		return []byte(sb.String()), nil
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	sb.WriteString(nilValue)
+	sb.WriteByte(' ')
+	sb.Write(data)
+	return []byte(sb.String()), nil
+}
+
+// headerField sanitizes a header value: printable US-ASCII without spaces,
+// truncated to the field's maximum length, or the NILVALUE if empty.
+func headerField(value string, max int) string {
+	if value == "" {
+		return nilValue
+	}
+	b := []byte(value)
+	for i, c := range b {
+		if c < 33 || c > 126 {
+			b[i] = '_'
+		}
+	}
+	if len(b) > max {
+		b = b[:max]
+	}
+	return string(b)
+}
+
+// structuredData renders the ECS fields, except message, as a single
+// SD-ELEMENT with one SD-PARAM per field, sorted by name.
+func structuredData(sdID string, flat map[string]interface{}) string {
+	keys := make([]string, 0, len(flat))
+	for key := range flat {
+		if key == ecsevent.FieldMessage {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nilValue
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteByte('[')
+	sb.WriteString(sdName(sdID))
+	for _, key := range keys {
+		sb.WriteByte(' ')
+		sb.WriteString(sdName(key))
+		sb.WriteString(`="`)
+		sb.WriteString(escapeParamValue(paramValue(flat[key])))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte(']')
+	return sb.String()
+}
+
+// sdName sanitizes an SD-ID or PARAM-NAME, which may not contain '=', ' ',
+// ']' or '"' and is limited to 32 characters. Longer names are truncated and
+// end with a hash of the full name, so that names sharing a prefix stay
+// distinct.
+func sdName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if c < 33 || c > 126 || c == '=' || c == ']' || c == '"' {
+			b[i] = '_'
+		}
+	}
+	if len(b) > maxSDName {
+		h := fnv.New32a()
+		h.Write([]byte(name))
+		suffix := fmt.Sprintf("~%08x", h.Sum32())
+		b = append(b[:maxSDName-len(suffix)], suffix...)
+	}
+	return string(b)
+}
+
+func paramValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []string:
+		return strings.Join(v, ",")
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool, int32, uint, uint32, uint64, float32, float64:
+		return fmt.Sprint(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
+
+// escapeParamValue escapes '"', '\' and ']' as required by RFC 5424.
+func escapeParamValue(value string) string {
+	if !strings.ContainsAny(value, `"\]`) {
+		return value
+	}
+	var sb strings.Builder
+	for _, r := range value {
+		if r == '"' || r == '\\' || r == ']' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -0,0 +1,124 @@
+package syslog
+
+import (
+	"testing"
+
+	"github.com/sporkmonger/ecsevent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatMessage(t *testing.T) {
+	tcs := []struct {
+		name           string
+		input          map[string]interface{}
+		format         Format
+		expectedOutput string
+	}{
+		{
+			"json message",
+			map[string]interface{}{
+				ecsevent.FieldTimestamp:    "2019-10-28T06:15:07.226113003Z",
+				ecsevent.FieldLogLevel:     "error",
+				ecsevent.FieldMessage:      "something happened",
+				ecsevent.FieldServiceName:  "checkout",
+				ecsevent.FieldHostHostname: "web-1",
+				ecsevent.FieldProcessPID:   1234,
+			},
+			JSONMessage,
+			`<11>1 2019-10-28T06:15:07.226113Z web-1 checkout 1234 - - ` +
+				`{"@timestamp":"2019-10-28T06:15:07.226113003Z","host.hostname":"web-1",` +
+				`"log.level":"error","message":"something happened","process.pid":1234,"service.name":"checkout"}`,
+		},
+		{
+			"header defaults",
+			map[string]interface{}{
+				ecsevent.FieldTimestamp: "2019-10-28T06:15:07Z",
+				ecsevent.FieldLogLevel:  "bogus",
+			},
+			JSONMessage,
+			`<14>1 2019-10-28T06:15:07.000000Z default-host app 99 - - ` +
+				`{"@timestamp":"2019-10-28T06:15:07Z","log.level":"bogus"}`,
+		},
+		{
+			"structured data",
+			map[string]interface{}{
+				"@timestamp": "2019-10-28T06:15:07Z",
+				"log": map[string]interface{}{
+					"level": "warn",
+				},
+				"http": map[string]interface{}{
+					"request": map[string]interface{}{
+						"method": "GET",
+					},
+					"response": map[string]interface{}{
+						"status_code": 200,
+					},
+				},
+				"url": map[string]interface{}{
+					"path": `/a"b]c\d`,
+				},
+				ecsevent.FieldMessage: "hello world",
+			},
+			StructuredData,
+			`<12>1 2019-10-28T06:15:07.000000Z default-host app 99 - ` +
+				`[ecs@32473 @timestamp="2019-10-28T06:15:07Z" http.request.method="GET" ` +
+				`http.response.status_code="200" log.level="warn" url.path="/a\"b\]c\\d"] hello world`,
+		},
+		{
+			"structured data without fields",
+			map[string]interface{}{
+				ecsevent.FieldTimestamp: "2019-10-28T06:15:07Z",
+			},
+			StructuredData,
+			`<14>1 2019-10-28T06:15:07.000000Z default-host app 99 - ` +
+				`[ecs@32473 @timestamp="2019-10-28T06:15:07Z"]`,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			h := &header{
+				facility: FacilityUser,
+				hostname: "default-host",
+				appName:  "app",
+				procID:   "99",
+				sdID:     "ecs@32473",
+				format:   tc.format,
+			}
+			output, err := formatMessage(tc.input, h)
+			assert.NoError(err)
+			assert.Equal(tc.expectedOutput, string(output))
+		})
+	}
+}
+
+func TestFormatMessageMissingSDID(t *testing.T) {
+	assert := assert.New(t)
+	h := &header{
+		facility: FacilityUser,
+		format:   StructuredData,
+	}
+	_, err := formatMessage(map[string]interface{}{ecsevent.FieldMessage: "hello world"}, h)
+	assert.Equal(ErrMissingSDID, err)
+}
+
+func TestHeaderField(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("-", headerField("", maxAppName))
+	assert.Equal("my_app", headerField("my app", maxAppName))
+	assert.Equal("abc", headerField("abcdef", 3))
+}
+
+func TestSDName(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("a_b_c_d_", sdName(`a=b]c"d `))
+	assert.Equal("destination.geo.continent_name", sdName("destination.geo.continent_name"))
+	long := sdName("a.very.long.field.name.that.exceeds.the.limit")
+	assert.Len(long, maxSDName)
+	assert.Equal("a.very.long.field.name.~", long[:maxSDName-8])
+	other := sdName("a.very.long.field.name.that.exceeds.another.limit")
+	assert.Len(other, maxSDName)
+	assert.NotEqual(long, other)
+}
@@ -0,0 +1,193 @@
+package zap
+
+import (
+	"runtime"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+// defaultRenames maps the field names zap uses for errors onto ECS.
+var defaultRenames = map[string]string{
+	"error":        ecsevent.FieldErrorMessage,
+	"errorVerbose": ecsevent.FieldErrorStackTrace,
+}
+
+// Core is a zapcore.Core that translates zap entries into ECS events and
+// records them on a Monitor. Objects and namespaces become dotted fields.
+type Core struct {
+	zapcore.LevelEnabler
+	monitor ecsevent.Monitor
+	mappers []func(string) string
+	fields  map[string]interface{}
+	// namespaces are the namespaces opened by With, which the fields of
+	// later calls are placed under.
+	namespaces []string
+}
+
+// Option configures a Core as it's being initialized.
+type Option func(*Core)
+
+// RenameFields remaps field names onto ECS field names, e.g.
+// {"status": ecsevent.FieldHTTPResponseStatusCode}. Names are dotted after
+// objects and namespaces are flattened. Renaming a field to "" drops it.
+func RenameFields(renames map[string]string) Option {
+	copied := make(map[string]string, len(renames))
+	for name, ecsName := range renames {
+		copied[name] = ecsName
+	}
+	return MapFieldNames(func(name string) string {
+		if ecsName, ok := copied[name]; ok {
+			return ecsName
+		}
+		return name
+	})
+}
+
+// MapFieldNames adds a function that remaps every field name, returning ""
+// to drop the field. Mappers are applied in the order they were given, after
+// the default mapping of 'error' to error.message.
+func MapFieldNames(mapper func(name string) string) Option {
+	return func(c *Core) {
+		c.mappers = append(c.mappers, mapper)
+	}
+}
+
+// NewCore creates a Core recording entries enabled by enabler, e.g.
+// zapcore.InfoLevel, on the Monitor. Use it with zap.New, or combine it with
+// an existing core using zapcore.NewTee.
+func NewCore(monitor ecsevent.Monitor, enabler zapcore.LevelEnabler, opts ...Option) *Core {
+	c := &Core{
+		LevelEnabler: enabler,
+		monitor:      monitor,
+		fields:       map[string]interface{}{},
+	}
+	c.mappers = append(c.mappers, func(name string) string {
+		if ecsName, ok := defaultRenames[name]; ok {
+			return ecsName
+		}
+		return name
+	})
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// With returns a Core that adds the fields to every entry.
+func (c *Core) With(fields []zapcore.Field) zapcore.Core {
+	c2 := *c
+	c2.fields = make(map[string]interface{}, len(c.fields)+len(fields))
+	for key, value := range c.fields {
+		c2.fields[key] = value
+	}
+	c.addFields(c2.fields, fields)
+	// Capped so that sibling cores never share appended namespaces.
+	c2.namespaces = c.namespaces[:len(c.namespaces):len(c.namespaces)]
+	for _, field := range fields {
+		if field.Type == zapcore.NamespaceType {
+			c2.namespaces = append(c2.namespaces, field.Key)
+		}
+	}
+	return &c2
+}
+
+// Check adds the Core to the checked entry if the level is enabled.
+func (c *Core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Write translates the entry and its fields into an ECS event and records
+// it.
+func (c *Core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	event := make(map[string]interface{}, len(c.fields)+len(fields)+8)
+	for key, value := range c.fields {
+		event[key] = value
+	}
+	c.addFields(event, fields)
+	event[ecsevent.FieldLogLevel] = ent.Level.String()
+	if !ent.Time.IsZero() {
+		event[ecsevent.FieldTimestamp] = ent.Time
+	}
+	if ent.Message != "" {
+		event[ecsevent.FieldMessage] = ent.Message
+	}
+	if ent.LoggerName != "" {
+		event[ecsevent.FieldLogLogger] = ent.LoggerName
+	}
+	if ent.Caller.Defined {
+		event[ecsevent.FieldLogOriginFileName] = ent.Caller.File
+		event[ecsevent.FieldLogOriginFileLine] = ent.Caller.Line
+		if fn := runtime.FuncForPC(ent.Caller.PC); fn != nil {
+			event[ecsevent.FieldLogOriginFunction] = fn.Name()
+		}
+	}
+	if ent.Stack != "" {
+		event[ecsevent.FieldErrorStackTrace] = ent.Stack
+	}
+	c.monitor.Record(event)
+	return nil
+}
+
+// Sync does nothing, since events are recorded synchronously.
+func (c *Core) Sync() error {
+	return nil
+}
+
+// addFields encodes zap fields into flat, mapped ECS fields, under any
+// namespaces opened by With.
+func (c *Core) addFields(event map[string]interface{}, fields []zapcore.Field) {
+	if len(fields) == 0 {
+		return
+	}
+	enc := zapcore.NewMapObjectEncoder()
+	for _, namespace := range c.namespaces {
+		enc.OpenNamespace(namespace)
+	}
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+	for key, value := range ecsevent.Unnest(enc.Fields) {
+		for _, mapper := range c.mappers {
+			key = mapper(key)
+			if key == "" {
+				break
+			}
+		}
+		if key == "" {
+			continue
+		}
+		event[key] = convertValue(value)
+	}
+}
+
+// convertValue converts the types zap's map encoder produces into the types
+// ECS fields expect, e.g. int rather than int64 and durations in
+// nanoseconds.
+func convertValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int64:
+		if int64(int(v)) == v {
+			return int(v)
+		}
+		return v
+	case int32:
+		return int(v)
+	case time.Duration:
+		return int(v)
+	default:
+		return v
+	}
+}
+
+var (
+	// This is a compile-time check to make sure our types correctly
+	// implement the interface:
+	// https://medium.com/@matryer/c167afed3aae
+	_ zapcore.Core = &Core{}
+)
@@ -0,0 +1,157 @@
+package zap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+type captureEmitter struct {
+	events []map[string]interface{}
+}
+
+func (ce *captureEmitter) Emit(event map[string]interface{}) {
+	ce.events = append(ce.events, event)
+}
+
+func TestCore(t *testing.T) {
+	tcs := []struct {
+		name           string
+		opts           []Option
+		log            func(*zap.Logger)
+		expectedOutput map[string]interface{}
+	}{
+		{
+			"standard fields",
+			nil,
+			func(logger *zap.Logger) {
+				logger.Named("db").Warn("slow query", zap.Error(errors.New("boom")))
+			},
+			map[string]interface{}{
+				ecsevent.FieldLogLevel:     "warn",
+				ecsevent.FieldMessage:      "slow query",
+				ecsevent.FieldLogLogger:    "db",
+				ecsevent.FieldErrorMessage: "boom",
+			},
+		},
+		{
+			"typed and nested fields",
+			nil,
+			func(logger *zap.Logger) {
+				logger.With(zap.String("service.name", "checkout")).Info("done",
+					zap.Int64("count", 3),
+					zap.Duration(ecsevent.FieldEventDuration, 2*time.Millisecond),
+					zap.Namespace("http"),
+					zap.Int("status", 200),
+				)
+			},
+			map[string]interface{}{
+				ecsevent.FieldLogLevel:      "info",
+				ecsevent.FieldMessage:       "done",
+				ecsevent.FieldServiceName:   "checkout",
+				ecsevent.FieldEventDuration: int(2 * time.Millisecond),
+				"count":                     3,
+				"http.status":               200,
+			},
+		},
+		{
+			"namespace opened by with",
+			nil,
+			func(logger *zap.Logger) {
+				logger.With(
+					zap.String("service.name", "checkout"),
+					zap.Namespace("http"),
+					zap.String("version", "1.1"),
+				).With(
+					zap.Namespace("response"),
+				).Info("done", zap.Int("status_code", 200))
+			},
+			map[string]interface{}{
+				ecsevent.FieldLogLevel:               "info",
+				ecsevent.FieldMessage:                "done",
+				ecsevent.FieldServiceName:            "checkout",
+				ecsevent.FieldHTTPVersion:            "1.1",
+				ecsevent.FieldHTTPResponseStatusCode: 200,
+			},
+		},
+		{
+			"renamed and dropped fields",
+			[]Option{
+				RenameFields(map[string]string{"status": ecsevent.FieldHTTPResponseStatusCode}),
+				MapFieldNames(func(name string) string {
+					if name == "secret" {
+						return ""
+					}
+					return name
+				}),
+			},
+			func(logger *zap.Logger) {
+				logger.Info("done", zap.Int("status", 200), zap.String("secret", "hunter2"))
+			},
+			map[string]interface{}{
+				ecsevent.FieldLogLevel:               "info",
+				ecsevent.FieldMessage:                "done",
+				ecsevent.FieldHTTPResponseStatusCode: 200,
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			emitter := &captureEmitter{}
+			rm := ecsevent.NewRootMonitor(ecsevent.NestEvents(false))
+			rm.AppendEmitter(emitter)
+			tc.log(zap.New(NewCore(rm, zapcore.DebugLevel, tc.opts...)))
+			if assert.Len(emitter.events, 1) {
+				event := emitter.events[0]
+				assert.IsType(time.Time{}, event[ecsevent.FieldTimestamp])
+				delete(event, ecsevent.FieldTimestamp)
+				assert.Equal(tc.expectedOutput, event)
+			}
+		})
+	}
+}
+
+func TestCoreLevelAndCaller(t *testing.T) {
+	assert := assert.New(t)
+	emitter := &captureEmitter{}
+	rm := ecsevent.NewRootMonitor(ecsevent.NestEvents(false))
+	rm.AppendEmitter(emitter)
+	logger := zap.New(NewCore(rm, zapcore.InfoLevel), zap.AddCaller())
+	logger.Debug("ignored")
+	logger.Info("recorded")
+	if assert.Len(emitter.events, 1) {
+		event := emitter.events[0]
+		assert.Contains(event[ecsevent.FieldLogOriginFileName], "core_test.go")
+		assert.IsType(0, event[ecsevent.FieldLogOriginFileLine])
+		assert.Contains(event[ecsevent.FieldLogOriginFunction], "TestCoreLevelAndCaller")
+	}
+}
+
+func TestCoreSpanMonitor(t *testing.T) {
+	assert := assert.New(t)
+	emitter := &captureEmitter{}
+	rm := ecsevent.NewRootMonitor(ecsevent.NestEvents(false))
+	rm.AppendEmitter(emitter)
+	span := ecsevent.NewSpanMonitorFromParent(rm)
+	ctx := span.WithContext(context.Background())
+
+	logger := zap.New(NewCore(ecsevent.MonitorFromContext(ctx), zapcore.DebugLevel))
+	logger.Info("querying")
+	span.Finish()
+
+	if assert.Len(emitter.events, 1) {
+		subevents, ok := emitter.events[0][ecsevent.FieldEventSubevents].([]map[string]interface{})
+		if assert.True(ok) && assert.Len(subevents, 1) {
+			assert.Equal("querying", subevents[0][ecsevent.FieldMessage])
+		}
+	}
+}
@@ -8,7 +8,11 @@ import (
 	"github.com/sporkmonger/ecsevent"
 )
 
-func init() {
+// UseECSFieldNames sets zerolog's global field names and time format to their
+// ECS equivalents, so that loggers used alongside the Emitter produce ECS
+// compatible output. It affects every zerolog logger in the process, so it
+// must be called explicitly, typically from main.
+func UseECSFieldNames() {
 	zerologFieldInit()
 }
 
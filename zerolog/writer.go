@@ -0,0 +1,174 @@
+package zerolog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+// Writer is an io.Writer that parses zerolog's JSON output back into ECS
+// events and records them on a Monitor. zerolog's special fields are mapped
+// to their ECS equivalents using the current global field names, e.g.
+// 'level' becomes log.level and 'caller' becomes log.origin.file.name and
+// log.origin.file.line. Nested objects become dotted fields.
+//
+// A zerolog Hook can't see an event's fields, which is why this is a writer.
+type Writer struct {
+	Monitor ecsevent.Monitor
+}
+
+// NewLogger returns a zerolog Logger with timestamps that records its events
+// on the Monitor.
+func NewLogger(monitor ecsevent.Monitor) zerolog.Logger {
+	return zerolog.New(&Writer{Monitor: monitor}).With().Timestamp().Logger()
+}
+
+// FromContext returns a zerolog Logger that records its events on the
+// Monitor in the context, typically the current request's SpanMonitor, so
+// that they become its subevents.
+func FromContext(ctx context.Context) zerolog.Logger {
+	return NewLogger(ecsevent.MonitorFromContext(ctx))
+}
+
+// Write parses a single zerolog event and records it. Malformed input is
+// rejected with an error, which zerolog reports through its ErrorHandler.
+func (w *Writer) Write(p []byte) (int, error) {
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	raw := map[string]interface{}{}
+	if err := dec.Decode(&raw); err != nil {
+		return 0, err
+	}
+	w.Monitor.Record(toECS(raw))
+	return len(p), nil
+}
+
+// WriteLevel implements zerolog.LevelWriter. The level is already part of the
+// event, so it's ignored.
+func (w *Writer) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	return w.Write(p)
+}
+
+// toECS converts a decoded zerolog event into a flat ECS event.
+func toECS(raw map[string]interface{}) map[string]interface{} {
+	flat := ecsevent.Unnest(convertValue(raw).(map[string]interface{}))
+	event := make(map[string]interface{}, len(flat))
+	for key, value := range flat {
+		switch key {
+		case zerolog.LevelFieldName:
+			event[ecsevent.FieldLogLevel] = value
+		case zerolog.MessageFieldName:
+			event[ecsevent.FieldMessage] = value
+		case zerolog.ErrorFieldName:
+			event[ecsevent.FieldErrorMessage] = value
+		case zerolog.ErrorStackFieldName:
+			event[ecsevent.FieldErrorStackTrace] = value
+		case zerolog.TimestampFieldName:
+			if timestamp, ok := parseTimestamp(value); ok {
+				event[ecsevent.FieldTimestamp] = timestamp
+			} else {
+				event[ecsevent.FieldTimestamp] = value
+			}
+		case zerolog.CallerFieldName:
+			caller, ok := value.(string)
+			if !ok {
+				event[key] = value
+				continue
+			}
+			// zerolog renders the caller as 'file:line'.
+			if i := strings.LastIndexByte(caller, ':'); i != -1 {
+				if line, err := strconv.Atoi(caller[i+1:]); err == nil {
+					event[ecsevent.FieldLogOriginFileName] = caller[:i]
+					event[ecsevent.FieldLogOriginFileLine] = line
+					continue
+				}
+			}
+			event[ecsevent.FieldLogOriginFileName] = caller
+		default:
+			event[key] = value
+		}
+	}
+	return event
+}
+
+// convertValue turns JSON numbers into int where possible, since ECS numeric
+// fields are ints, and string arrays into []string, as used by tags.
+func convertValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			if int64(int(n)) == n {
+				return int(n)
+			}
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = convertValue(elem)
+		}
+		return v
+	case []interface{}:
+		strs := make([]string, 0, len(v))
+		for i, elem := range v {
+			v[i] = convertValue(elem)
+			if s, ok := v[i].(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		if len(v) > 0 && len(strs) == len(v) {
+			return strs
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// parseTimestamp parses a timestamp using zerolog's TimeFieldFormat.
+func parseTimestamp(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case string:
+		format := zerolog.TimeFieldFormat
+		switch format {
+		case zerolog.TimeFormatUnix, zerolog.TimeFormatUnixMs, zerolog.TimeFormatUnixMicro:
+			format = time.RFC3339Nano
+		}
+		timestamp, err := time.Parse(format, v)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return timestamp, true
+	case int:
+		return unixTimestamp(int64(v)), true
+	case int64:
+		return unixTimestamp(v), true
+	case float64:
+		sec, frac := math.Modf(v)
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// unixTimestamp converts an integer timestamp in the unit selected by
+// zerolog's TimeFieldFormat.
+func unixTimestamp(n int64) time.Time {
+	switch zerolog.TimeFieldFormat {
+	case zerolog.TimeFormatUnixMs:
+		return time.Unix(0, n*int64(time.Millisecond))
+	case zerolog.TimeFormatUnixMicro:
+		return time.Unix(0, n*int64(time.Microsecond))
+	default:
+		return time.Unix(n, 0)
+	}
+}
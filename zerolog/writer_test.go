@@ -0,0 +1,171 @@
+package zerolog
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sporkmonger/ecsevent"
+)
+
+type captureEmitter struct {
+	events []map[string]interface{}
+}
+
+func (ce *captureEmitter) Emit(event map[string]interface{}) {
+	ce.events = append(ce.events, event)
+}
+
+// defaultFieldNames restores zerolog's default global field names, since
+// other tests in this package change them.
+func defaultFieldNames() func() {
+	level, message, errorName, timestamp, format := zerolog.LevelFieldName, zerolog.MessageFieldName,
+		zerolog.ErrorFieldName, zerolog.TimestampFieldName, zerolog.TimeFieldFormat
+	zerolog.LevelFieldName = "level"
+	zerolog.MessageFieldName = "message"
+	zerolog.ErrorFieldName = "error"
+	zerolog.TimestampFieldName = "time"
+	zerolog.TimeFieldFormat = time.RFC3339
+	return func() {
+		zerolog.LevelFieldName, zerolog.MessageFieldName, zerolog.ErrorFieldName,
+			zerolog.TimestampFieldName, zerolog.TimeFieldFormat = level, message, errorName, timestamp, format
+	}
+}
+
+func TestWriter(t *testing.T) {
+	tcs := []struct {
+		name           string
+		input          string
+		expectedOutput map[string]interface{}
+	}{
+		{
+			"standard fields",
+			`{"level":"warn","error":"boom","caller":"/src/main.go:42","time":"2019-10-28T06:15:07Z","message":"hello world"}`,
+			map[string]interface{}{
+				ecsevent.FieldLogLevel:          "warn",
+				ecsevent.FieldMessage:           "hello world",
+				ecsevent.FieldErrorMessage:      "boom",
+				ecsevent.FieldLogOriginFileName: "/src/main.go",
+				ecsevent.FieldLogOriginFileLine: 42,
+				ecsevent.FieldTimestamp:         time.Date(2019, 10, 28, 6, 15, 7, 0, time.UTC),
+			},
+		},
+		{
+			"nested and typed fields",
+			`{"http":{"response":{"status_code":200}},"ratio":0.5,"tags":["a","b"],"mixed":["a",1],"service.name":"checkout"}`,
+			map[string]interface{}{
+				ecsevent.FieldHTTPResponseStatusCode: 200,
+				ecsevent.FieldServiceName:            "checkout",
+				ecsevent.FieldTags:                   []string{"a", "b"},
+				"ratio":                              0.5,
+				"mixed":                              []interface{}{"a", 1},
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			defer defaultFieldNames()()
+			assert := assert.New(t)
+			emitter := &captureEmitter{}
+			rm := ecsevent.NewRootMonitor(ecsevent.NestEvents(false))
+			rm.AppendEmitter(emitter)
+			w := &Writer{Monitor: rm}
+			n, err := w.Write([]byte(tc.input + "\n"))
+			assert.NoError(err)
+			assert.Equal(len(tc.input)+1, n)
+			if assert.Len(emitter.events, 1) {
+				assert.Equal(tc.expectedOutput, emitter.events[0])
+			}
+		})
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	defer defaultFieldNames()()
+	expected := time.Date(2019, 10, 28, 6, 15, 7, 226000000, time.UTC)
+	tcs := []struct {
+		name   string
+		format string
+		input  interface{}
+	}{
+		{"unix int", zerolog.TimeFormatUnix, int(1572243307)},
+		{"unix int64", zerolog.TimeFormatUnix, int64(1572243307)},
+		{"unix ms int", zerolog.TimeFormatUnixMs, int(1572243307226)},
+		{"unix ms int64", zerolog.TimeFormatUnixMs, int64(1572243307226)},
+		{"unix micro int64", zerolog.TimeFormatUnixMicro, int64(1572243307226000)},
+		{"unix float64", zerolog.TimeFormatUnix, 1572243307.226},
+		{"rfc3339", time.RFC3339Nano, "2019-10-28T06:15:07.226Z"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			zerolog.TimeFieldFormat = tc.format
+			timestamp, ok := parseTimestamp(tc.input)
+			assert.True(ok)
+			if tc.format == zerolog.TimeFormatUnix {
+				// Seconds only, apart from the float.
+				assert.Equal(expected.Unix(), timestamp.Unix())
+			} else {
+				assert.True(expected.Equal(timestamp), timestamp)
+			}
+		})
+	}
+}
+
+func TestWriterMalformed(t *testing.T) {
+	assert := assert.New(t)
+	w := &Writer{Monitor: ecsevent.Nop()}
+	_, err := w.Write([]byte("not json"))
+	assert.Error(err)
+}
+
+func TestFromContext(t *testing.T) {
+	defer defaultFieldNames()()
+	assert := assert.New(t)
+	emitter := &captureEmitter{}
+	rm := ecsevent.NewRootMonitor(ecsevent.NestEvents(false))
+	rm.AppendEmitter(emitter)
+	span := ecsevent.NewSpanMonitorFromParent(rm)
+	ctx := span.WithContext(context.Background())
+
+	logger := FromContext(ctx)
+	logger.Info().Str("db.statement", "SELECT 1").Msg("querying")
+	logger.Error().Err(errors.New("boom")).Send()
+	span.Finish()
+
+	if assert.Len(emitter.events, 1) {
+		subevents, ok := emitter.events[0][ecsevent.FieldEventSubevents].([]map[string]interface{})
+		if assert.True(ok) && assert.Len(subevents, 2) {
+			assert.Equal("querying", subevents[0][ecsevent.FieldMessage])
+			assert.Equal("SELECT 1", subevents[0]["db.statement"])
+			assert.IsType(time.Time{}, subevents[0][ecsevent.FieldTimestamp])
+			assert.Equal("error", subevents[1][ecsevent.FieldLogLevel])
+			assert.Equal("boom", subevents[1][ecsevent.FieldErrorMessage])
+		}
+	}
+}
+
+func TestUseECSFieldNames(t *testing.T) {
+	defer defaultFieldNames()()
+	assert := assert.New(t)
+	UseECSFieldNames()
+	assert.Equal(ecsevent.FieldLogLevel, zerolog.LevelFieldName)
+
+	// The writer maps ECS field names onto themselves.
+	emitter := &captureEmitter{}
+	rm := ecsevent.NewRootMonitor(ecsevent.NestEvents(false))
+	rm.AppendEmitter(emitter)
+	logger := NewLogger(rm)
+	logger.Warn().Msg("hello world")
+	if assert.Len(emitter.events, 1) {
+		assert.Equal("warn", emitter.events[0][ecsevent.FieldLogLevel])
+		assert.Equal("hello world", emitter.events[0][ecsevent.FieldMessage])
+		assert.IsType(time.Time{}, emitter.events[0][ecsevent.FieldTimestamp])
+	}
+}